@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// notifier delivers the zip codes that newly match the alert name.
+type notifier func(name string, datas []Data) error
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+func alertMessage(name string, datas []Data) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "zhiquery alert %s: %d new zip codes\n", name, len(datas))
+	for _, d := range datas {
+		fmt.Fprintf(&b, "  %d %s, %s (%s) %s, growth %s\n",
+			d.ZipCode, d.City, d.State, d.Dataset, formatPrice(d.Price()), formatPercent(d.GrowthRate))
+	}
+	return b.String()
+}
+
+func postJSON(url string, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s responded %s", url, resp.Status)
+	}
+	return nil
+}
+
+func printNotifier(name string, datas []Data) error {
+	_, err := fmt.Print(alertMessage(name, datas))
+	return err
+}
+
+// webhookNotifier posts {"alert": <name>, "matches": [<records>]}, with the
+// records of --format json.
+func webhookNotifier(url string) notifier {
+	return func(name string, datas []Data) error {
+		matches := make([]json.RawMessage, len(datas))
+		for i := range datas {
+			record, err := marshalRecord(&datas[i], columns)
+			if err != nil {
+				return err
+			}
+			matches[i] = record
+		}
+
+		return postJSON(url, map[string]interface{}{"alert": name, "matches": matches})
+	}
+}
+
+func slackNotifier(url string) notifier {
+	return func(name string, datas []Data) error {
+		return postJSON(url, map[string]string{"text": alertMessage(name, datas)})
+	}
+}
+
+// emailNotifier sends the message through the SMTP server at addr,
+// authenticating with $ZHIQUERY_SMTP_USER and $ZHIQUERY_SMTP_PASSWORD when
+// set.
+func emailNotifier(addr, from, to string) notifier {
+	return func(name string, datas []Data) error {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return err
+		}
+
+		var auth smtp.Auth
+		if user := os.Getenv("ZHIQUERY_SMTP_USER"); user != "" {
+			auth = smtp.PlainAuth("", user, os.Getenv("ZHIQUERY_SMTP_PASSWORD"), host)
+		}
+
+		msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: zhiquery alert %s\r\n\r\n%s",
+			from, to, name, strings.ReplaceAll(alertMessage(name, datas), "\n", "\r\n"))
+		return smtp.SendMail(addr, auth, from, strings.Split(to, ","), []byte(msg))
+	}
+}
+
+func alertStatePath(name string) (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(dir, "alerts", name+".json"), nil
+}
+
+// loadAlertState returns the zip codes by dataset that matched the alert
+// the last time it was checked.
+func loadAlertState(name string) (map[string][]uint64, error) {
+	p, err := alertStatePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return map[string][]uint64{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	state := map[string][]uint64{}
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, fmt.Errorf("Invalid alert state in %s: %v", p, err)
+	}
+	return state, nil
+}
+
+func storeAlertState(name string, datas []Data) error {
+	p, err := alertStatePath(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	state := map[string][]uint64{}
+	for _, d := range datas {
+		state[d.Dataset] = append(state[d.Dataset], d.ZipCode)
+	}
+
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, b, 0644)
+}
+
+// checkAlert runs the alert query and notifies about the zip codes that
+// didn't match the previous check. The matches are only remembered once
+// every notifier succeeded, so failed notifications are retried.
+func checkAlert(name string, filter FilterFn, opts options, notifiers []notifier) error {
+	datas, err := search(opts.repository(repository()), filter, opts)
+	if err != nil {
+		return err
+	}
+	if err := sortDatas(datas, opts.sort, opts.vocabulary); err != nil {
+		return err
+	}
+
+	state, err := loadAlertState(name)
+	if err != nil {
+		return err
+	}
+
+	previous := map[dataKey]bool{}
+	for dataset, zipCodes := range state {
+		for _, zipCode := range zipCodes {
+			previous[dataKey{dataset, zipCode}] = true
+		}
+	}
+
+	var fresh []Data
+	for _, d := range datas {
+		if !previous[dataKey{d.Dataset, d.ZipCode}] {
+			fresh = append(fresh, d)
+		}
+	}
+	logger.Info("Checked alert", "alert", name, "matches", len(datas), "new", len(fresh))
+
+	if len(fresh) > 0 {
+		for _, notify := range notifiers {
+			if err := notify(name, fresh); err != nil {
+				return fmt.Errorf("Couldn't notify alert %s: %v", name, err)
+			}
+		}
+	}
+	return storeAlertState(name, datas)
+}
+
+func alertCmd(args []string) {
+	var opts options
+	var every time.Duration
+	var webhook, slack, email, smtpAddr, from string
+	fs := flag.NewFlagSet("alert", flag.ExitOnError)
+	opts.register(fs)
+	fs.DurationVar(&every, "every", 0, "")
+	fs.StringVar(&webhook, "webhook", "", "")
+	fs.StringVar(&slack, "slack", "", "")
+	fs.StringVar(&email, "email", "", "")
+	fs.StringVar(&smtpAddr, "smtp", "localhost:25", "")
+	fs.StringVar(&from, "from", "zhiquery@localhost", "")
+	args = parseArgs(fs, args)
+
+	if len(args) == 0 {
+		usage("alert")
+		os.Exit(exitUsage)
+	}
+
+	queries, err := loadSavedQueries()
+	must(err)
+	saved, ok := queries[args[0]]
+	if !ok {
+		must(fmt.Errorf("Couldn't find saved query %s", args[0]))
+	}
+
+	must(usageError(opts.prepare()))
+	tree, _, err := parseFilterTree(composeQuery(saved, tokenize(args[1:])), opts.vocabulary)
+	must(err)
+	filter := opts.restrict(tree).filter
+
+	var notifiers []notifier
+	if webhook != "" {
+		notifiers = append(notifiers, webhookNotifier(webhook))
+	}
+	if slack != "" {
+		notifiers = append(notifiers, slackNotifier(slack))
+	}
+	if email != "" {
+		notifiers = append(notifiers, emailNotifier(smtpAddr, from, email))
+	}
+	if len(notifiers) == 0 {
+		notifiers = append(notifiers, printNotifier)
+	}
+
+	if every == 0 {
+		must(checkAlert(args[0], filter, opts, notifiers))
+		return
+	}
+
+	for {
+		if err := checkAlert(args[0], filter, opts, notifiers); err != nil {
+			logger.Error("Couldn't check alert", "alert", args[0], "error", err)
+		}
+		time.Sleep(every)
+	}
+}
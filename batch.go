@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+type batchQuery struct {
+	name string
+	tree *filterNode
+}
+
+// readBatch reads one <name> <query> per line, skipping blank lines and
+// lines starting with #.
+func readBatch(p string, v *vocabulary) ([]batchQuery, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var queries []batchQuery
+	names := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		splitted := strings.SplitN(text, " ", 2)
+		if len(splitted) != 2 {
+			return nil, fmt.Errorf("Line %d of %s: expected <name> <query>", line, p)
+		}
+
+		name := splitted[0]
+		if path.Base(name) != name || strings.HasPrefix(name, ".") {
+			return nil, fmt.Errorf("Line %d of %s: invalid query name %s", line, p, name)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("Line %d of %s: query %s is already defined", line, p, name)
+		}
+		names[name] = true
+
+		tree, _, err := parseFilterTree(tokenize([]string{strings.TrimSpace(splitted[1])}), v)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d of %s: %v", line, p, err)
+		}
+		queries = append(queries, batchQuery{name, tree})
+	}
+	return queries, scanner.Err()
+}
+
+var formatExtensions = map[string]string{
+	"text":        "txt",
+	"json":        "json",
+	"csv":         "csv",
+	"geojson":     "geojson",
+	"heatmap-svg": "svg",
+}
+
+// batchCmd loads the datasets once, keeping the rows matching any query,
+// and writes the results of every query to its own file.
+func batchCmd(args []string) {
+	var opts options
+	var out string
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	opts.register(fs)
+	fs.StringVar(&out, "out", ".", "")
+	args = parseArgs(fs, args)
+
+	if len(args) != 1 {
+		usage("batch")
+		os.Exit(exitUsage)
+	}
+
+	must(usageError(opts.prepare()))
+	for _, rejected := range []struct {
+		name string
+		set  bool
+	}{{"count", opts.count}, {"count-by", opts.countBy != ""}, {"all-snapshots", opts.allSnapshots}} {
+		if rejected.set {
+			must(usageError(fmt.Errorf("--%s can't be combined with batch, use query --%s", rejected.name, rejected.name)))
+		}
+	}
+	defer startProfiling(opts)()
+	format, ok := formats[opts.format]
+	if opts.series {
+		format = seriesFormats[opts.format]
+	}
+	if !ok {
+		must(usageError(fmt.Errorf("Couldn't find format %s", opts.format)))
+	}
+	if _, ok := benchmarks[opts.benchmark]; !ok {
+		must(fmt.Errorf("Couldn't find benchmark %s", opts.benchmark))
+	}
+
+	queries, err := readBatch(args[0], opts.vocabulary)
+	must(err)
+
+	var filters []FilterFn
+	for i, q := range queries {
+		queries[i].tree = opts.restrict(q.tree)
+		filters = append(filters, queries[i].tree.filter)
+	}
+
+	p := newPopulation()
+	datas := loadWith(opts.repository(repository()), chainByOr(filters...), opts, p.observe)
+	must(os.MkdirAll(out, 0755))
+
+	for _, q := range queries {
+		var matched []Data
+		for i := range datas {
+			if q.tree.filter(&datas[i]) {
+				matched = append(matched, datas[i])
+			}
+		}
+
+		if opts.rollup {
+			matched = rollupNeighborhoods(matched, opts)
+		}
+		results, err := p.aggregate(matched, q.tree.filter, opts)
+		must(err)
+		if opts.byHomeType {
+			results = byHomeType(results)
+		}
+		must(sortDatas(results, opts.sort, opts.vocabulary))
+		if opts.limit > 0 && len(results) > opts.limit {
+			results = results[:opts.limit]
+		}
+
+		f, err := os.Create(path.Join(out, q.name+"."+formatExtensions[opts.format]))
+		must(err)
+		must(format(f, results, opts))
+		must(f.Close())
+		logger.Info("Wrote batch query", "query", q.name, "path", f.Name(), "matches", len(results))
+	}
+}
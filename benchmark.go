@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+type average struct {
+	sum float64
+	n   int
+}
+
+func (a *average) add(v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+
+	a.sum += v
+	a.n++
+}
+
+func (a *average) value() float64 {
+	if a.n == 0 {
+		return 0
+	}
+	return a.sum / float64(a.n)
+}
+
+// growthAverages accumulates the average growth rate of every row by
+// dataset, and by dataset and state.
+type growthAverages struct {
+	mu       sync.Mutex
+	national map[string]*average
+	states   map[[2]string]*average
+}
+
+func newGrowthAverages() *growthAverages {
+	return &growthAverages{
+		national: map[string]*average{},
+		states:   map[[2]string]*average{},
+	}
+}
+
+func (g *growthAverages) observe(d *Data) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.national[d.Dataset] == nil {
+		g.national[d.Dataset] = &average{}
+	}
+	g.national[d.Dataset].add(d.GrowthRate)
+
+	state := [2]string{d.Dataset, d.State}
+	if g.states[state] == nil {
+		g.states[state] = &average{}
+	}
+	g.states[state].add(d.GrowthRate)
+}
+
+// benchmarks return the baseline growth rate of d and its label.
+var benchmarks = map[string]func(g *growthAverages, matched map[string]*average, d *Data) (float64, string){
+	"us": func(g *growthAverages, _ map[string]*average, d *Data) (float64, string) {
+		return g.national[d.Dataset].value(), "US"
+	},
+	"state": func(g *growthAverages, _ map[string]*average, d *Data) (float64, string) {
+		return g.states[[2]string{d.Dataset, d.State}].value(), d.State
+	},
+	"matched": func(_ *growthAverages, matched map[string]*average, d *Data) (float64, string) {
+		return matched[d.Dataset].value(), "matched"
+	},
+}
+
+// population accumulates what the metrics depending on the whole result
+// set need to know about every row, while loading.
+type population struct {
+	averages *growthAverages
+	states   *distributions
+}
+
+func newPopulation() *population {
+	return &population{averages: newGrowthAverages(), states: newDistributions()}
+}
+
+func (p *population) observe(d *Data) {
+	p.averages.observe(d)
+	p.states.add(stateKey(d), d)
+}
+
+// search loads the rows of repository matching filter and computes the
+// metrics that depend on the whole result set. Filters on those metrics
+// match everything while loading, so the rows are filtered again once the
+// metrics are known.
+func search(repository string, filter FilterFn, opts options) ([]Data, error) {
+	if _, ok := benchmarks[opts.benchmark]; !ok {
+		return nil, fmt.Errorf("Couldn't find benchmark %s", opts.benchmark)
+	}
+
+	p := newPopulation()
+	datas := loadWith(repository, filter, opts, p.observe)
+	if opts.rollup {
+		datas = rollupNeighborhoods(datas, opts)
+	}
+	start := time.Now()
+	defer func() { timings.add("aggregate", time.Since(start)) }()
+	return p.aggregate(datas, filter, opts)
+}
+
+// matchedStats accumulates the growth averages by dataset and the
+// distributions of the rows matching a query, for the matched benchmark
+// and the percentile ranks.
+type matchedStats struct {
+	mu            sync.Mutex
+	averages      map[string]*average
+	distributions *distributions
+}
+
+func newMatchedStats() *matchedStats {
+	return &matchedStats{averages: map[string]*average{}, distributions: newDistributions()}
+}
+
+func (m *matchedStats) add(d *Data) {
+	m.mu.Lock()
+	if m.averages[d.Dataset] == nil {
+		m.averages[d.Dataset] = &average{}
+	}
+	m.averages[d.Dataset].add(d.GrowthRate)
+	m.mu.Unlock()
+	m.distributions.add(matchedKey(d), d)
+}
+
+// complete computes the metrics of d depending on the whole result set.
+func (p *population) complete(d *Data, matched *matchedStats, opts options) error {
+	baseline, ok := benchmarks[opts.benchmark]
+	if !ok {
+		return fmt.Errorf("Couldn't find benchmark %s", opts.benchmark)
+	}
+
+	base, label := baseline(p.averages, matched.averages, d)
+	d.RelGrowth = d.GrowthRate - base
+	d.Benchmark = label
+	rank(d, matched.distributions, p.states)
+	evaluate(d, opts.definitions)
+	if opts.scoreExpr != nil {
+		d.Score = opts.scoreExpr(d)
+	}
+	d.aggregated = true
+	return nil
+}
+
+// aggregate computes the metrics depending on the whole result set of the
+// rows matching filter before aggregation, and filters them again.
+func (p *population) aggregate(datas []Data, filter FilterFn, opts options) ([]Data, error) {
+	matched := newMatchedStats()
+	for i := range datas {
+		matched.add(&datas[i])
+	}
+
+	filtered := datas[:0]
+	for i := range datas {
+		d := &datas[i]
+		if err := p.complete(d, matched, opts); err != nil {
+			return nil, err
+		}
+		if filter(d) {
+			filtered = append(filtered, *d)
+		}
+	}
+	return dedupe(filtered, opts.dedupeWins), nil
+}
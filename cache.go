@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// fingerprint identifies the content of the dataset files of the snapshot
+// of repository by their names, sizes, and modification times, so that it
+// changes when a file is refreshed. Remote repositories are listed once per
+// run, their fingerprint is their listing.
+func fingerprint(repository, snapshot string) (string, error) {
+	files, err := fileFingerprints(repository, snapshot)
+	if err != nil {
+		return "", err
+	}
+	return fingerprintOf(files), nil
+}
+
+// fingerprintOf is the fingerprint of a repository with the files of
+// fileFingerprints.
+func fingerprintOf(files map[string]string) string {
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	h := sha1.New()
+	for _, p := range paths {
+		fmt.Fprintf(h, "%s %s\n", p, files[p])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// fileFingerprints returns the size and modification time of every dataset
+// file of the snapshot of repository by repositoryDataset.path, or "" for
+// the files of remote repositories.
+func fileFingerprints(repository, snapshot string) (map[string]string, error) {
+	files := map[string]string{}
+	repositories, err := queriedRepositories(repository, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	for _, repository := range repositories {
+		datasets, err := listDatasets(repository)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, dataset := range datasets {
+			p := repositoryDataset{repository, dataset}.path()
+			if isRemote(repository) {
+				files[p] = ""
+				continue
+			}
+			files[p], err = fileFingerprint(repository, dataset)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
+
+// fileFingerprint is the size and modification time of a dataset file of
+// a local repository.
+func fileFingerprint(repository, dataset string) (string, error) {
+	info, err := os.Stat(path.Join(repository, dataset))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d %d", info.Size(), info.ModTime().UnixNano()), nil
+}
+
+// cacheKey is a query normalized by tokensString, how its results are
+// sorted, and the fingerprint of the datasets it ran against.
+type cacheKey struct {
+	query       string
+	sort        string
+	fingerprint string
+}
+
+type cacheEntry struct {
+	datas   []Data
+	expires time.Time
+}
+
+// maxCacheEntries bounds the queries a resultCache holds.
+const maxCacheEntries = 256
+
+// resultCache keeps the results of queries for ttl. Results of older
+// fingerprints are never looked up again and expire.
+type resultCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[cacheKey]cacheEntry
+}
+
+func newResultCache(ttl time.Duration) *resultCache {
+	return &resultCache{ttl: ttl, entries: map[cacheKey]cacheEntry{}}
+}
+
+// get returns a copy of the cached results of key, callers may reorder
+// it.
+func (c *resultCache) get(key cacheKey) ([]Data, bool) {
+	if c == nil || c.ttl <= 0 {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return append([]Data(nil), entry.datas...), true
+}
+
+func (c *resultCache) put(key cacheKey, datas []Data) {
+	if c == nil || c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.entries) >= maxCacheEntries {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	// drop an arbitrary entry when every entry is still fresh
+	for k := range c.entries {
+		if len(c.entries) < maxCacheEntries {
+			break
+		}
+		delete(c.entries, k)
+	}
+	c.entries[key] = cacheEntry{append([]Data(nil), datas...), now.Add(c.ttl)}
+}
+
+// clear drops every entry, e.g. once the datasets are reloaded.
+func (c *resultCache) clear() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.entries = map[cacheKey]cacheEntry{}
+	c.mu.Unlock()
+}
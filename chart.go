@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// maxChartSeries bounds the zip codes of a --chart, the first ones in the
+// order of --sort are charted.
+const maxChartSeries = 10
+
+const (
+	chartWidth  = 960
+	chartHeight = 540
+	// margins around the plot, the right one holds the legend
+	chartLeft   = 80
+	chartRight  = 170
+	chartTop    = 40
+	chartBottom = 40
+)
+
+// chartColors are the colors of the series, in order.
+var chartColors = []color.RGBA{
+	{0x1f, 0x77, 0xb4, 0xff},
+	{0xff, 0x7f, 0x0e, 0xff},
+	{0x2c, 0xa0, 0x2c, 0xff},
+	{0xd6, 0x27, 0x28, 0xff},
+	{0x94, 0x67, 0xbd, 0xff},
+	{0x8c, 0x56, 0x4b, 0xff},
+	{0xe3, 0x77, 0xc2, 0xff},
+	{0x7f, 0x7f, 0x7f, 0xff},
+	{0xbc, 0xbd, 0x22, 0xff},
+	{0x17, 0xbe, 0xcf, 0xff},
+}
+
+var (
+	chartAxis = color.RGBA{0x33, 0x33, 0x33, 0xff}
+	chartGrid = color.RGBA{0xdd, 0xdd, 0xdd, 0xff}
+)
+
+// canvas is what charts are drawn on, an SVG document or a PNG image.
+type canvas interface {
+	line(x1, y1, x2, y2 float64, c color.RGBA, width float64)
+	// text writes s with its baseline at y, starting at x, or ending at x
+	// when right is set.
+	text(x, y float64, s string, right bool)
+	// title is the heading of the chart, only written when the canvas has
+	// the glyphs for it.
+	title(s string)
+}
+
+// writeChart draws the price histories of datas as a line chart to p, an
+// SVG or PNG file by its extension.
+func writeChart(p string, datas []Data) error {
+	if len(datas) > maxChartSeries {
+		logger.Warn("Only charting the first zip codes", "matches", len(datas), "charted", maxChartSeries)
+		datas = datas[:maxChartSeries]
+	}
+
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(path.Ext(p)) {
+	case ".svg":
+		w := bufio.NewWriter(f)
+		writeSVGChart(w, datas)
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	case ".png":
+		img := newPNGCanvas()
+		drawChart(img, datas)
+		if err := png.Encode(f, img.img); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Couldn't find chart format %s, expected .svg or .png", path.Ext(p))
+	}
+	return f.Close()
+}
+
+// writeSVGChart writes the chart of datas as an SVG document, which scales
+// to its container through its viewBox.
+func writeSVGChart(w io.Writer, datas []Data) {
+	fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\" font-family=\"sans-serif\" font-size=\"12\">\n", chartWidth, chartHeight, chartWidth, chartHeight)
+	fmt.Fprintf(w, "<rect width=\"%d\" height=\"%d\" fill=\"white\"/>\n", chartWidth, chartHeight)
+	drawChart(&svgCanvas{w: w}, datas)
+	fmt.Fprintln(w, "</svg>")
+}
+
+// drawChart plots every series against the union of their months, with
+// price gridlines at round values and a label per year.
+func drawChart(c canvas, datas []Data) {
+	monthSet := map[string]bool{}
+	low, high := math.Inf(1), math.Inf(-1)
+	datasets := map[string]bool{}
+	for i := range datas {
+		datasets[datas[i].Dataset] = true
+		for j, v := range datas[i].ZHIs {
+			if v == 0 || j >= len(datas[i].Months) {
+				continue
+			}
+			monthSet[datas[i].Months[j]] = true
+			low, high = math.Min(low, v), math.Max(high, v)
+		}
+	}
+	var months []string
+	for month := range monthSet {
+		months = append(months, month)
+	}
+	sort.Strings(months)
+	if len(months) == 0 {
+		return
+	}
+	xs := make(map[string]int, len(months))
+	for i, month := range months {
+		xs[month] = i
+	}
+
+	step := niceStep((high - low) / 5)
+	low, high = math.Floor(low/step)*step, math.Ceil(high/step)*step
+	if high == low {
+		high = low + step
+	}
+	plotWidth := float64(chartWidth - chartLeft - chartRight)
+	plotHeight := float64(chartHeight - chartTop - chartBottom)
+	x := func(i int) float64 {
+		if len(months) == 1 {
+			return chartLeft
+		}
+		return chartLeft + float64(i)/float64(len(months)-1)*plotWidth
+	}
+	y := func(v float64) float64 {
+		return chartTop + (high-v)/(high-low)*plotHeight
+	}
+
+	var names []string
+	for dataset := range datasets {
+		names = append(names, dataset)
+	}
+	sort.Strings(names)
+	c.title("Price history, " + strings.Join(names, ", "))
+
+	for v := low; v <= high+step/2; v += step {
+		c.line(chartLeft, y(v), chartLeft+plotWidth, y(v), chartGrid, 1)
+		c.text(chartLeft-8, y(v)+4, shortDollars(v), true)
+	}
+	// one label per year, or every few years when they'd overlap
+	years := 0
+	for i, month := range months {
+		if i == 0 || month[:4] != months[i-1][:4] {
+			years++
+		}
+	}
+	every := max(1, years*40/int(plotWidth)+1)
+	year := 0
+	for i, month := range months {
+		if i > 0 && month[:4] == months[i-1][:4] {
+			continue
+		}
+		if year%every == 0 {
+			c.line(x(i), chartTop+plotHeight, x(i), chartTop+plotHeight+5, chartAxis, 1)
+			c.text(x(i)-14, chartTop+plotHeight+20, month[:4], false)
+		}
+		year++
+	}
+	c.line(chartLeft, chartTop, chartLeft, chartTop+plotHeight, chartAxis, 1)
+	c.line(chartLeft, chartTop+plotHeight, chartLeft+plotWidth, chartTop+plotHeight, chartAxis, 1)
+
+	for i := range datas {
+		d := &datas[i]
+		col := chartColors[i%len(chartColors)]
+		last := -1
+		for j, v := range d.ZHIs {
+			if v == 0 || j >= len(d.Months) {
+				last = -1
+				continue
+			}
+			if last >= 0 {
+				c.line(x(xs[d.Months[last]]), y(d.ZHIs[last]), x(xs[d.Months[j]]), y(v), col, 2)
+			}
+			last = j
+		}
+
+		label := fmt.Sprint(d.ZipCode)
+		if d.Neighborhood != "" {
+			label = d.Neighborhood
+		}
+		if len(datasets) > 1 {
+			label += " (" + strings.TrimSuffix(d.Dataset, path.Ext(d.Dataset)) + ")"
+		}
+		ly := float64(chartTop + 10 + i*20)
+		c.line(chartWidth-chartRight+15, ly-4, chartWidth-chartRight+35, ly-4, col, 3)
+		c.text(chartWidth-chartRight+42, ly, label, false)
+	}
+}
+
+// niceStep rounds a step up to 1, 2, or 5 times a power of 10.
+func niceStep(step float64) float64 {
+	if step <= 0 || math.IsNaN(step) || math.IsInf(step, 0) {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(step)))
+	for _, m := range []float64{1, 2, 5} {
+		if step <= m*magnitude {
+			return m * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+// shortDollars is a price like $250k or $1.5M.
+func shortDollars(v float64) string {
+	switch {
+	case math.Abs(v) >= 1e6:
+		return "$" + strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.2f", v/1e6), "0"), ".") + "M"
+	case math.Abs(v) >= 1e3:
+		return "$" + strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.1f", v/1e3), "0"), ".") + "k"
+	default:
+		return fmt.Sprintf("$%.0f", v)
+	}
+}
+
+type svgCanvas struct {
+	w io.Writer
+}
+
+func (s *svgCanvas) line(x1, y1, x2, y2 float64, c color.RGBA, width float64) {
+	fmt.Fprintf(s.w, "<line x1=\"%.1f\" y1=\"%.1f\" x2=\"%.1f\" y2=\"%.1f\" stroke=\"#%02x%02x%02x\" stroke-width=\"%v\"/>\n", x1, y1, x2, y2, c.R, c.G, c.B, width)
+}
+
+func (s *svgCanvas) text(x, y float64, text string, right bool) {
+	anchor := "start"
+	if right {
+		anchor = "end"
+	}
+	fmt.Fprintf(s.w, "<text x=\"%.1f\" y=\"%.1f\" text-anchor=\"%s\">%s</text>\n", x, y, anchor, html.EscapeString(text))
+}
+
+func (s *svgCanvas) title(text string) {
+	fmt.Fprintf(s.w, "<text x=\"%d\" y=\"%d\" font-size=\"16\">%s</text>\n", chartLeft, chartTop-16, html.EscapeString(text))
+}
+
+// pngCanvas rasterizes charts without a font library, its glyphs only
+// cover the characters of prices, years, and zip codes.
+type pngCanvas struct {
+	img *image.RGBA
+}
+
+func newPNGCanvas() *pngCanvas {
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, chartHeight))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	return &pngCanvas{img}
+}
+
+func (p *pngCanvas) line(x1, y1, x2, y2 float64, c color.RGBA, width float64) {
+	steps := int(math.Max(math.Abs(x2-x1), math.Abs(y2-y1))) + 1
+	r := int(width / 2)
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		x, y := int(math.Round(x1+(x2-x1)*t)), int(math.Round(y1+(y2-y1)*t))
+		for dx := -r; dx <= r; dx++ {
+			for dy := -r; dy <= r; dy++ {
+				if width < 2 && (dx != 0 || dy != 0) {
+					continue
+				}
+				p.img.SetRGBA(x+dx, y+dy, c)
+			}
+		}
+	}
+}
+
+func (p *pngCanvas) text(x, y float64, s string, right bool) {
+	// glyphs are 5x7 pixels drawn twice as large, a column apart
+	const scale, advance = 2, 6 * 2
+	if right {
+		x -= float64(len(s) * advance)
+	}
+	for i, r := range s {
+		// stop at the first letter, e.g. before the dataset of a legend
+		glyph, ok := pngGlyphs[r]
+		if !ok {
+			break
+		}
+		left, top := int(x)+i*advance, int(y)-7*scale
+		for row, bits := range glyph {
+			for col, bit := range bits {
+				if bit != '#' {
+					continue
+				}
+				for dx := 0; dx < scale; dx++ {
+					for dy := 0; dy < scale; dy++ {
+						p.img.SetRGBA(left+col*scale+dx, top+row*scale+dy, chartAxis)
+					}
+				}
+			}
+		}
+	}
+}
+
+// title is left out of PNG charts, the glyphs have no letters.
+func (p *pngCanvas) title(s string) {}
+
+var pngGlyphs = map[rune][7]string{
+	'0': {" ### ", "#   #", "#  ##", "# # #", "##  #", "#   #", " ### "},
+	'1': {"  #  ", " ##  ", "  #  ", "  #  ", "  #  ", "  #  ", " ### "},
+	'2': {" ### ", "#   #", "    #", "   # ", "  #  ", " #   ", "#####"},
+	'3': {"#####", "   # ", "  #  ", "   # ", "    #", "#   #", " ### "},
+	'4': {"   # ", "  ## ", " # # ", "#  # ", "#####", "   # ", "   # "},
+	'5': {"#####", "#    ", "#### ", "    #", "    #", "#   #", " ### "},
+	'6': {"  ## ", " #   ", "#    ", "#### ", "#   #", "#   #", " ### "},
+	'7': {"#####", "    #", "   # ", "  #  ", " #   ", " #   ", " #   "},
+	'8': {" ### ", "#   #", "#   #", " ### ", "#   #", "#   #", " ### "},
+	'9': {" ### ", "#   #", "#   #", " ####", "    #", "   # ", " ##  "},
+	'$': {"  #  ", " ####", "# #  ", " ### ", "  # #", "#### ", "  #  "},
+	'k': {"#    ", "#    ", "#  # ", "# #  ", "##   ", "# #  ", "#  # "},
+	'M': {"#   #", "## ##", "# # #", "# # #", "#   #", "#   #", "#   #"},
+	'.': {"     ", "     ", "     ", "     ", "     ", " ##  ", " ##  "},
+	'-': {"     ", "     ", "     ", "#####", "     ", "     ", "     "},
+}
@@ -0,0 +1,41 @@
+package main
+
+import "os"
+
+const (
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorReset  = "\x1b[0m"
+)
+
+func colorize(color, s string) string {
+	return color + s + colorReset
+}
+
+func growthColor(rate float64) string {
+	switch {
+	case rate >= 5:
+		return colorGreen
+	case rate >= 2:
+		return colorYellow
+	default:
+		return colorRed
+	}
+}
+
+func priceColor(price float64) string {
+	switch {
+	case price < 300000:
+		return colorGreen
+	case price < 700000:
+		return colorYellow
+	default:
+		return colorRed
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,621 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize/english"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress/snappy"
+)
+
+// columnsVersion changes with the layout of the column files, files of
+// other versions are ignored until they're ingested again.
+const columnsVersion = "1"
+
+// The columns ingest adds to those of the csv.
+const (
+	// rowColumn is the line of the row in the csv, from 1 after the
+	// header, since the rows are grouped by state.
+	rowColumn = "ZhiqueryRow"
+	// growthColumn is the GrowthRate of the row over all its months, what
+	// the benchmarks need of the rows pruned by a query.
+	growthColumn = "ZhiqueryGrowthRate"
+)
+
+// columnFile is a dataset file converted by ingest to Parquet: the columns
+// of the csv, its months as nullable doubles, with a row group per state.
+// Opening it only reads its footer, the row groups a query can't match are
+// skipped by their statistics and only the columns it prunes on are read of
+// the other row groups until a row may match.
+type columnFile struct {
+	f      *os.File
+	file   *parquet.File
+	header []string
+	// months is the index of the first month of header, and lines the
+	// number of lines of the csv after its header.
+	months int
+	lines  int
+	// columns are the Parquet columns of header, row and growth those of
+	// rowColumn and growthColumn.
+	columns     []int
+	row, growth int
+}
+
+func (c *columnFile) Close() error {
+	return c.f.Close()
+}
+
+// columnsDir is where ingest writes column files, $ZHIQUERY_COLUMNS or the
+// zhiquery directory of the user cache directory.
+func columnsDir() (string, error) {
+	if dir := os.Getenv("ZHIQUERY_COLUMNS"); dir != "" {
+		return dir, nil
+	}
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(dir, "zhiquery", "columns"), nil
+}
+
+// columnsPath is the column file of dataset, under a directory named after
+// the absolute path of its local repository.
+func columnsPath(repository, dataset string) (string, error) {
+	dir, err := columnsDir()
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(repository)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha1.Sum([]byte(abs))
+	return path.Join(dir, hex.EncodeToString(h[:8]), dataset+".parquet"), nil
+}
+
+// columnsSchema is the schema of the column files of header, whose months
+// start at months.
+func columnsSchema(header []string, months int) (*parquet.Schema, error) {
+	group := parquet.Group{
+		rowColumn:    parquet.Leaf(parquet.Int64Type),
+		growthColumn: parquet.Leaf(parquet.DoubleType),
+	}
+	for i, name := range header {
+		if _, ok := group[name]; ok {
+			return nil, fmt.Errorf("Couldn't convert the header, column %s is there twice", name)
+		}
+		if i < months {
+			group[name] = parquet.String()
+		} else {
+			group[name] = parquet.Optional(parquet.Leaf(parquet.DoubleType))
+		}
+	}
+	return parquet.NewSchema("zhiquery", group), nil
+}
+
+// columnIndexes returns the Parquet columns of names in schema.
+func columnIndexes(schema *parquet.Schema, names ...string) ([]int, error) {
+	indexes := make([]int, len(names))
+	for i, name := range names {
+		leaf, ok := schema.Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("Couldn't find column %s", name)
+		}
+		indexes[i] = leaf.ColumnIndex
+	}
+	return indexes, nil
+}
+
+// writeColumns converts the csv of dataset to its column file, and returns
+// the number of rows and the path of the file.
+func writeColumns(repository, dataset string) (int, string, error) {
+	source, err := fileFingerprint(repository, dataset)
+	if err != nil {
+		return 0, "", err
+	}
+	f, err := openRepositoryDataset(repository, dataset)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan()
+	header := strings.Split(scanner.Text(), ",")
+	l, err := datasetLayout(repository, dataset, header)
+	if err != nil {
+		return 0, "", err
+	}
+	schema, err := columnsSchema(header, l.months)
+	if err != nil {
+		return 0, "", fmt.Errorf("Couldn't ingest dataset %s: %v", dataset, err)
+	}
+	columns, err := columnIndexes(schema, append([]string{rowColumn, growthColumn}, header...)...)
+	if err != nil {
+		return 0, "", err
+	}
+
+	// rows are grouped by state for the statistics of their row group to
+	// rule out the states a query doesn't match
+	states := map[string][]parquet.Row{}
+	lines, invalid := 0, 0
+	for scanner.Scan() {
+		lines++
+		fields := strings.Split(scanner.Text(), ",")
+		if len(fields) <= l.months {
+			logger.Warn("Skipping row with missing columns", "dataset", dataset, "row", lines, "columns", len(fields))
+			continue
+		} else if len(fields) != len(header) {
+			return 0, "", fmt.Errorf("Couldn't ingest dataset %s, row %d has %d columns rather than the %d of the header, see validate", dataset, lines, len(fields), len(header))
+		}
+
+		row := make(parquet.Row, len(columns))
+		values := make([]float64, 0, len(fields)-l.months)
+		for i, field := range fields {
+			column := columns[i+2]
+			if i < l.months {
+				row[column] = parquet.ByteArrayValue([]byte(field)).Level(0, 0, column)
+				continue
+			}
+
+			v, err := strconv.ParseFloat(field, 64)
+			if err != nil && field != "" {
+				invalid++
+			}
+			values = append(values, v)
+			if err != nil {
+				row[column] = parquet.NullValue().Level(0, 0, column)
+			} else {
+				row[column] = parquet.DoubleValue(v).Level(0, 1, column)
+			}
+		}
+		growth, _ := calculateGrowthRate(values, 12)
+		row[columns[0]] = parquet.Int64Value(int64(lines)).Level(0, 0, columns[0])
+		row[columns[1]] = parquet.DoubleValue(growth).Level(0, 0, columns[1])
+		state := normalizeState(fields[l.state])
+		states[state] = append(states[state], row)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, "", fmt.Errorf("Couldn't read dataset %s: %v", dataset, err)
+	}
+	if invalid > 0 {
+		logger.Warn("Treated invalid values as missing", "dataset", dataset, "values", invalid)
+	}
+
+	p, err := columnsPath(repository, dataset)
+	if err != nil {
+		return 0, "", err
+	}
+	if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+		return 0, "", err
+	}
+	tmp, err := ioutil.TempFile(path.Dir(p), "."+dataset)
+	if err != nil {
+		return 0, "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := parquet.NewWriter(tmp, schema,
+		parquet.Compression(&snappy.Codec{}),
+		parquet.KeyValueMetadata("zhiquery.version", columnsVersion),
+		parquet.KeyValueMetadata("zhiquery.source", source),
+		parquet.KeyValueMetadata("zhiquery.header", strings.Join(header, ",")),
+		parquet.KeyValueMetadata("zhiquery.months", strconv.Itoa(l.months)),
+		parquet.KeyValueMetadata("zhiquery.lines", strconv.Itoa(lines)),
+	)
+	names := make([]string, 0, len(states))
+	for state := range states {
+		names = append(names, state)
+	}
+	sort.Strings(names)
+	rows := 0
+	for _, state := range names {
+		if _, err := w.WriteRows(states[state]); err != nil {
+			tmp.Close()
+			return 0, "", err
+		}
+		if err := w.Flush(); err != nil {
+			tmp.Close()
+			return 0, "", err
+		}
+		rows += len(states[state])
+	}
+	if err := w.Close(); err != nil {
+		tmp.Close()
+		return 0, "", err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return 0, "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, "", err
+	}
+	return rows, p, os.Rename(tmp.Name(), p)
+}
+
+// readColumns opens the column file of dataset when it was converted from
+// the current dataset file, or returns nil to parse the csv.
+func readColumns(repository, dataset string) *columnFile {
+	if isRemote(repository) {
+		return nil
+	}
+	p, err := columnsPath(repository, dataset)
+	if err != nil {
+		return nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil
+	}
+
+	c, err := openColumns(repository, dataset, f)
+	if err != nil {
+		logger.Debug("Ignoring column file", "dataset", dataset, "path", p, "reason", err)
+		f.Close()
+		return nil
+	}
+	return c
+}
+
+func openColumns(repository, dataset string, f *os.File) (*columnFile, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	file, err := parquet.OpenFile(f, info.Size(), parquet.SkipPageIndex(true), parquet.SkipBloomFilters(true))
+	if err != nil {
+		return nil, err
+	}
+
+	lookup := func(key string) string {
+		v, _ := file.Lookup(key)
+		return v
+	}
+	source, err := fileFingerprint(repository, dataset)
+	if err != nil {
+		return nil, err
+	}
+	if lookup("zhiquery.version") != columnsVersion || lookup("zhiquery.source") != source {
+		return nil, fmt.Errorf("stale")
+	}
+
+	c := &columnFile{f: f, file: file, header: strings.Split(lookup("zhiquery.header"), ",")}
+	if c.months, err = strconv.Atoi(lookup("zhiquery.months")); err != nil {
+		return nil, err
+	}
+	if c.lines, err = strconv.Atoi(lookup("zhiquery.lines")); err != nil {
+		return nil, err
+	}
+	// the layout may have changed since, e.g. with .zhiquery.json
+	if l, err := datasetLayout(repository, dataset, c.header); err != nil || l.months != c.months {
+		return nil, fmt.Errorf("another layout")
+	}
+	if c.columns, err = columnIndexes(file.Schema(), c.header...); err != nil {
+		return nil, err
+	}
+	indexes, err := columnIndexes(file.Schema(), rowColumn, growthColumn)
+	if err != nil {
+		return nil, err
+	}
+	c.row, c.growth = indexes[0], indexes[1]
+	return c, nil
+}
+
+// scanColumn calls each with every value of column in g, or page with the
+// pages it can read faster as a whole and reports it did.
+func scanColumn(g parquet.RowGroup, column int, each func(v parquet.Value), page func(p parquet.Page) bool) error {
+	pages := g.ColumnChunks()[column].Pages()
+	defer pages.Close()
+
+	buf := make([]parquet.Value, 1024)
+	for {
+		p, err := pages.ReadPage()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if page != nil && page(p) {
+			parquet.Release(p)
+			continue
+		}
+
+		values := p.Values()
+		for {
+			n, err := values.ReadValues(buf)
+			for _, v := range buf[:n] {
+				each(v)
+			}
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				parquet.Release(p)
+				return err
+			}
+		}
+		parquet.Release(p)
+	}
+}
+
+func readStrings(g parquet.RowGroup, column int) ([]string, error) {
+	strs := make([]string, 0, g.NumRows())
+	err := scanColumn(g, column, func(v parquet.Value) { strs = append(strs, string(v.ByteArray())) }, nil)
+	return strs, err
+}
+
+// readDoubles reads column in g, with missing values as 0.
+func readDoubles(g parquet.RowGroup, column int) ([]float64, error) {
+	doubles := make([]float64, 0, g.NumRows())
+	err := scanColumn(g, column, func(v parquet.Value) {
+		if v.IsNull() {
+			doubles = append(doubles, 0)
+		} else {
+			doubles = append(doubles, v.Double())
+		}
+	}, func(p parquet.Page) bool {
+		// plain pages hold the doubles that aren't null, decoding them
+		// value by value is most of the time of a query
+		if p.Dictionary() != nil {
+			return false
+		}
+		data := p.Data()
+		values, levels := data.Double(), p.DefinitionLevels()
+		if levels == nil {
+			doubles = append(doubles, values...)
+			return true
+		}
+		for _, level := range levels {
+			if level == 0 {
+				doubles = append(doubles, 0)
+			} else {
+				doubles = append(doubles, values[0])
+				values = values[1:]
+			}
+		}
+		return true
+	})
+	return doubles, err
+}
+
+func readInts(g parquet.RowGroup, column int) ([]int, error) {
+	ints := make([]int, 0, g.NumRows())
+	err := scanColumn(g, column, func(v parquet.Value) { ints = append(ints, int(v.Int64())) }, nil)
+	return ints, err
+}
+
+// columnRow is a row of a column file. The rows a query surely doesn't
+// match are pruned, with only the State, GrowthRate, and latest price of
+// data when they're observed.
+type columnRow struct {
+	line   int
+	fields []string
+	values []float64
+	pruned bool
+	data   Data
+}
+
+// bounds returns the range of the values of column in row group g, the
+// price of missing values being 0.
+func (c *columnFile) bounds(g int, column int) (lo, hi float64) {
+	stats := c.file.Metadata().RowGroups[g].Columns[column].MetaData.Statistics
+	decode := func(b []byte) float64 {
+		if len(b) != 8 {
+			return 0
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	}
+	lo, hi = decode(stats.MinValue), decode(stats.MaxValue)
+	if stats.NullCount > 0 {
+		lo, hi = math.Min(lo, 0), math.Max(hi, 0)
+	}
+	return lo, hi
+}
+
+// readRows returns the rows of c in the order of the csv. With tree, the
+// row groups whose state and latest prices it can't match are pruned
+// without reading their prices, then the rows it can't match by their
+// State, County, and latest price. Prices are only pruned on with prices.
+func (c *columnFile) readRows(l layout, tree *filterNode, prices, observed bool) ([]columnRow, error) {
+	var rows []columnRow
+	latest := c.columns[len(c.columns)-1]
+	for i, g := range c.file.RowGroups() {
+		n := int(g.NumRows())
+		lines, err := readInts(g, c.row)
+		if err != nil {
+			return nil, err
+		}
+		keep := make([]bool, n)
+		for j := range keep {
+			keep[j] = true
+		}
+
+		var states []string
+		var lasts []float64
+		if tree != nil && n > 0 {
+			if states, err = readStrings(g, c.columns[l.state]); err != nil {
+				return nil, err
+			}
+			// rows are grouped by state
+			state := normalizeState(states[0])
+			lo, hi := c.bounds(i, latest)
+			if !mayMatch(tree, &Data{State: state, ZHIs: []float64{lo}}, &Data{State: state, ZHIs: []float64{hi}}, false, prices) {
+				for j := range keep {
+					keep[j] = false
+				}
+			} else {
+				counties, err := readStrings(g, c.columns[l.county])
+				if err != nil {
+					return nil, err
+				}
+				if lasts, err = readDoubles(g, latest); err != nil {
+					return nil, err
+				}
+				for j := range keep {
+					d := &Data{State: state, County: counties[j], ZHIs: lasts[j : j+1]}
+					keep[j] = mayMatch(tree, d, d, true, prices)
+				}
+			}
+		}
+
+		kept := 0
+		for _, k := range keep {
+			if k {
+				kept++
+			}
+		}
+		var growths []float64
+		if observed && kept < n {
+			if lasts == nil {
+				if lasts, err = readDoubles(g, latest); err != nil {
+					return nil, err
+				}
+			}
+			if growths, err = readDoubles(g, c.growth); err != nil {
+				return nil, err
+			}
+		}
+		for j := 0; j < n; j++ {
+			if !keep[j] {
+				row := columnRow{line: lines[j], pruned: true}
+				if observed {
+					row.data = Data{State: normalizeState(states[j]), GrowthRate: growths[j], ZHIs: lasts[j : j+1]}
+				}
+				rows = append(rows, row)
+			}
+		}
+		if kept == 0 {
+			continue
+		}
+
+		fields := make([][]string, c.months)
+		for j := range fields {
+			if fields[j], err = readStrings(g, c.columns[j]); err != nil {
+				return nil, err
+			}
+		}
+		values := make([][]float64, len(c.columns)-c.months)
+		for j := range values {
+			if values[j], err = readDoubles(g, c.columns[c.months+j]); err != nil {
+				return nil, err
+			}
+		}
+		for j := 0; j < n; j++ {
+			if !keep[j] {
+				continue
+			}
+			row := columnRow{line: lines[j], fields: make([]string, len(fields)), values: make([]float64, len(values))}
+			for k := range fields {
+				row.fields[k] = fields[k][j]
+			}
+			for k := range values {
+				row.values[k] = values[k][j]
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool { return rows[i].line < rows[j].line })
+	return rows, nil
+}
+
+// pushdownKinds are the filters rows of column files are pruned with, by
+// whether they read the latest price.
+var pushdownKinds = map[string]bool{
+	"State":    false,
+	"County":   false,
+	"Price":    true,
+	"PriceMin": true,
+}
+
+// mayMatch returns false when tree surely matches no row between lo and hi,
+// rows with only their State, County, and latest price. The other filters
+// are assumed to match, and so are the County filters unless counties is
+// set and the price filters unless prices is set.
+func mayMatch(tree *filterNode, lo, hi *Data, counties, prices bool) bool {
+	if tree == nil {
+		return true
+	}
+
+	switch tree.op {
+	case "and":
+		return mayMatch(tree.left, lo, hi, counties, prices) && mayMatch(tree.right, lo, hi, counties, prices)
+	case "or":
+		return mayMatch(tree.left, lo, hi, counties, prices) || mayMatch(tree.right, lo, hi, counties, prices)
+	}
+	kind := strings.SplitN(tree.token, ":", 2)[0]
+	price, ok := pushdownKinds[kind]
+	if !ok || tree.aggregate || price && !prices || kind == "County" && !counties {
+		return true
+	}
+	return tree.filter(lo) || hi != lo && tree.filter(hi)
+}
+
+// plainPrices tells whether the prices of the rows of dataset are those of
+// its file, which the price filters can then be pushed down to.
+func plainPrices(dataset string, opts options) bool {
+	_, merged := opts.merges[dataset]
+	return opts.asOf == "" && !merged && resamplePeriods[opts.resample] == nil &&
+		(opts.interpolate == "" || opts.interpolate == "none") && !opts.seasonal
+}
+
+func ingestCmd(args []string) { ingestDatasets("ingest", args, false) }
+
+func indexCmd(args []string) { ingestDatasets("index", args, true) }
+
+// ingestDatasets converts the files of the dataset_dir of the args of
+// command name to Parquet files, only those without an up to date one when
+// stale is set.
+func ingestDatasets(name string, args []string, stale bool) {
+	if len(args) > 1 {
+		usage(name)
+		os.Exit(exitUsage)
+	}
+
+	repo := repository()
+	if len(args) == 1 {
+		repo = args[0]
+	}
+	repositories, err := queriedRepositories(repo, "")
+	must(datasetError(err))
+	for _, repository := range repositories {
+		if isRemote(repository) {
+			must(usageError(fmt.Errorf("Couldn't ingest %s, only local dataset directories can be ingested", repository)))
+		}
+		datasets, err := listDatasets(repository)
+		must(datasetError(err))
+
+		for _, dataset := range datasets {
+			if stale {
+				if c := readColumns(repository, dataset); c != nil {
+					c.Close()
+					p, err := columnsPath(repository, dataset)
+					must(err)
+					fmt.Printf("%s: up to date, %s\n", dataset, p)
+					continue
+				}
+			}
+
+			start := time.Now()
+			rows, p, err := writeColumns(repository, dataset)
+			must(datasetError(err))
+			fmt.Printf("%s: %s in %v, %s\n", dataset, english.Plural(rows, "row", ""), time.Since(start).Round(time.Millisecond), p)
+		}
+	}
+}
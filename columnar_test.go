@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestColumnFiles(t *testing.T) {
+	embedded = true
+	defer func() { embedded = false }()
+	t.Setenv("ZHIQUERY_COLUMNS", t.TempDir())
+	repository := testRepository(t)
+
+	queries := [][]string{
+		{"[ State:PA or PriceMin:400000 ]", "--limit", "0"},
+		{"[ State:MT or Price:300000 ]", "--benchmark", "state"},
+		{"[ County:~\"county 1\" or GrowthRate:5 ]", "--sort", "GrowthRate"},
+		{"[ State:TX or PriceMin:200000 ]", "--as-of", "2021-06", "--limit", "0"},
+		{"[ State:PA and Price:2000000 ]"},
+	}
+	parsed := make([]string, len(queries))
+	for i, query := range queries {
+		out, err := queryLibrary(append([]string{repository}, query...))
+		if err != nil {
+			t.Fatalf("%v: %v", query, err)
+		}
+		var records []map[string]interface{}
+		if err := json.Unmarshal(out, &records); err != nil || len(records) == 0 {
+			t.Fatalf("%v matched no zip code: %s", query, out)
+		}
+		parsed[i] = string(out)
+	}
+
+	rows, path, err := writeColumns(repository, "Zip_zhvi.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 50 {
+		t.Errorf("writeColumns = %d rows, want 50", rows)
+	}
+	c := readColumns(repository, "Zip_zhvi.csv")
+	if c == nil {
+		t.Fatal("readColumns = nil after writeColumns")
+	}
+	c.Close()
+	checkParquet(t, path)
+	for i, query := range queries {
+		out, err := queryLibrary(append([]string{repository}, query...))
+		if err != nil {
+			t.Fatalf("%v: %v", query, err)
+		}
+		if string(out) != parsed[i] {
+			t.Errorf("%v = %s with the column file, want %s", query, out, parsed[i])
+		}
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(repository, "Zip_zhvi.csv"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if readColumns(repository, "Zip_zhvi.csv") != nil {
+		t.Error("readColumns returned a column file older than its csv")
+	}
+}
+
+// checkParquet checks path reads as a plain Parquet file, the way DuckDB or
+// pandas would read it.
+func checkParquet(t *testing.T, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := parquet.OpenFile(f, info.Size())
+	if err != nil {
+		t.Fatalf("parquet.OpenFile(%s): %v", path, err)
+	}
+
+	if n := file.NumRows(); n != 50 {
+		t.Errorf("%s has %d rows, want 50", path, n)
+	}
+	for _, name := range []string{"RegionName", "State", "CountyName", "2020-01-31", "2022-12-31", rowColumn, growthColumn} {
+		if _, ok := file.Schema().Lookup(name); !ok {
+			t.Errorf("%s has no column %s", path, name)
+		}
+	}
+
+	// a row group per state, so readers can skip the other states
+	states := map[string]bool{}
+	c, _ := file.Schema().Lookup("State")
+	for _, g := range file.RowGroups() {
+		strs, err := readStrings(g, c.ColumnIndex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, state := range strs[1:] {
+			if state != strs[0] {
+				t.Fatalf("row group of %s has %s rows", strs[0], state)
+			}
+		}
+		if states[strs[0]] {
+			t.Errorf("%s has two row groups of %s", path, strs[0])
+		}
+		states[strs[0]] = true
+	}
+}
+
+func TestMayMatch(t *testing.T) {
+	d := &Data{State: "CA", County: "San Mateo County", ZHIs: []float64{400000, 500000}}
+	tests := []struct {
+		query  string
+		prices bool
+		want   bool
+	}{
+		{"[ State:CA ]", false, true},
+		{"[ State:NY ]", false, false},
+		{"[ State:NY or County:\"San Mateo County\" ]", false, true},
+		{"[ State:CA and County:Orange ]", false, false},
+		{"[ State:NY or GrowthRate:5 ]", false, true},
+		{"[ State:NY and GrowthRate:5 ]", false, false},
+		{"[ State:CA and Price:300000 ]", false, true},
+		{"[ State:CA and Price:300000 ]", true, false},
+		{"[ PriceMin:450000 ]", true, true},
+		{"[ [ State:NY and Price:600000 ] or PriceMin:600000 ]", true, false},
+	}
+	for _, test := range tests {
+		tree, _, err := parseFilterTree(tokenize([]string{test.query}), nil)
+		if err != nil {
+			t.Fatalf("%s: %v", test.query, err)
+		}
+		if got := mayMatch(tree, d, d, true, test.prices); got != test.want {
+			t.Errorf("mayMatch(%s, prices %v) = %v, want %v", test.query, test.prices, got, test.want)
+		}
+	}
+}
+
+func TestMayMatchRowGroup(t *testing.T) {
+	lo, hi := &Data{State: "CA", ZHIs: []float64{100000}}, &Data{State: "CA", ZHIs: []float64{900000}}
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"[ Price:50000 ]", false},
+		{"[ Price:200000 ]", true},
+		{"[ PriceMin:950000 ]", false},
+		{"[ PriceMin:500000 ]", true},
+		{"[ State:NY or Price:50000 ]", false},
+		// counties aren't known of a row group
+		{"[ State:CA and County:Orange ]", true},
+		{"[ State:NY and County:Orange ]", false},
+	}
+	for _, test := range tests {
+		tree, _, err := parseFilterTree(tokenize([]string{test.query}), nil)
+		if err != nil {
+			t.Fatalf("%s: %v", test.query, err)
+		}
+		if got := mayMatch(tree, lo, hi, false, true); got != test.want {
+			t.Errorf("mayMatch(%s) = %v, want %v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestReadRowsPrunes(t *testing.T) {
+	t.Setenv("ZHIQUERY_COLUMNS", t.TempDir())
+	repository := testRepository(t)
+	if _, _, err := writeColumns(repository, "Zip_zhvi.csv"); err != nil {
+		t.Fatal(err)
+	}
+	c := readColumns(repository, "Zip_zhvi.csv")
+	if c == nil {
+		t.Fatal("readColumns = nil after writeColumns")
+	}
+	defer c.Close()
+	l, err := datasetLayout(repository, "Zip_zhvi.csv", c.header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := c.readRows(l, nil, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != c.lines {
+		t.Fatalf("readRows = %d rows, want %d", len(all), c.lines)
+	}
+	states := map[string]int{}
+	for i, row := range all {
+		if row.pruned || row.line != i+1 {
+			t.Fatalf("row %d = line %d, pruned %v without a filter", i, row.line, row.pruned)
+		}
+		states[normalizeState(row.fields[l.state])]++
+	}
+
+	var state string
+	for state = range states {
+		break
+	}
+	tree, _, err := parseFilterTree(tokenize([]string{"[ State:" + state + " ]"}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := c.readRows(l, tree, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := 0
+	for i, row := range rows {
+		if row.line != i+1 {
+			t.Fatalf("row %d = line %d, want the order of the csv", i, row.line)
+		}
+		if row.pruned {
+			if row.data.State == state {
+				t.Errorf("line %d of %s pruned by State:%s", row.line, row.data.State, state)
+			}
+			continue
+		}
+		kept++
+		if got := normalizeState(row.fields[l.state]); got != state {
+			t.Errorf("line %d of %s kept by State:%s", row.line, got, state)
+		}
+		if row.values[len(row.values)-1] != all[i].values[len(all[i].values)-1] {
+			t.Errorf("line %d = %v, want %v", row.line, row.values, all[i].values)
+		}
+	}
+	if kept != states[state] {
+		t.Errorf("State:%s kept %d rows, want %d", state, kept, states[state])
+	}
+}
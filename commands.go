@@ -0,0 +1,378 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// command is a subcommand of zhiquery.
+type command struct {
+	name string
+	// usage lists the forms of the arguments, one per line of the usage.
+	usage []string
+	// description is the help text of the command, a bullet indented like
+	// the Commands section of help.
+	description string
+	// queryFlags is set when the command takes the flags of query.
+	queryFlags bool
+	// hidden is set for the commands of the completion scripts, which help
+	// leaves out.
+	hidden bool
+	// run runs the command with the arguments following its name.
+	run func(args []string)
+}
+
+// commands are the subcommands of zhiquery, in the order of help, set by
+// init as running them refers to commands for their usage. main runs query
+// when the first argument isn't a command.
+var commands []command
+
+func init() {
+	commands = []command{
+		{
+			name: "query",
+			run:  queryCmd,
+			usage: []string{
+				"[query] <dataset_dir> [ <kind_1>:<arg_1> or/and <kind_2>:<arg_2> or/and [ <kind_n>:<arg_n> ... ]] [flags]",
+				"[query] --data <dataset_dir> [--data <dataset_dir> ...] <query> [flags]",
+			},
+			description: `    * print the zip codes of the datasets of the dataset_dir matching the
+      query, see Kinds and Arguments and Flags. The command name can be
+      omitted, e.g. ./zhiquery dataset '[ State:CA ]'`,
+		},
+		{
+			name:        "save",
+			run:         saveCmd,
+			usage:       []string{"save <name> <query>"},
+			description: `    * store a query under a name`,
+		},
+		{
+			name:  "run",
+			run:   runCmd,
+			usage: []string{"run [<name> [<query>]] [flags]"},
+			description: `    * run a saved query against $ZHIQUERY_REPOSITORY (default: dataset), extra
+      query arguments are combined with the saved query using "and". Lists the
+      saved queries when no name is given`,
+			queryFlags: true,
+		},
+		{
+			name:  "alert",
+			run:   alertCmd,
+			usage: []string{"alert <name> [<query>] [flags]"},
+			description: `    * run a saved query like run and notify about the zip codes that didn't
+      match the previous run of the alert, e.g. save the thresholds with
+      ./zhiquery save cheap-growth '[ GrowthRate:8 and Price:300000 ]' and
+      check them from cron or with --every. The matches of each alert are
+      kept in the zhiquery config directory. Flags:
+      * --every <duration>: keep running and check every duration, e.g. 6h
+      * --webhook <url>: post {"alert": <name>, "matches": [<json records>]}
+      * --slack <url>: post the message to a Slack incoming webhook
+      * --email <addresses>: mail the message to comma separated addresses
+        through --smtp <host:port> (default: localhost:25) from --from,
+        authenticating with $ZHIQUERY_SMTP_USER and $ZHIQUERY_SMTP_PASSWORD
+        when set
+      The message is printed to stdout when no notifier is given`,
+			queryFlags: true,
+		},
+		{
+			name:  "batch",
+			run:   batchCmd,
+			usage: []string{"batch <queries_file> [flags]"},
+			description: `    * run every query of the file against $ZHIQUERY_REPOSITORY (default:
+      dataset), parsing the datasets once, and write the results of each
+      query to <name>.<format> (txt for text) in --out <dir> (default: .).
+      Each line is a name followed by a query, e.g.
+      cheap [ Price:300000 and GrowthRate:5 ]
+      Blank lines and lines starting with # are skipped. The query flags
+      apply to every query, except --count, --count-by, and --all-snapshots,
+      which batch rejects`,
+			queryFlags: true,
+		},
+		{
+			name:  "download",
+			run:   downloadCmd,
+			usage: []string{"download [<dataset_dir>] [--source <file>=<url> ...]"},
+			description: `    * download the dataset files into the local dataset_dir, or
+      $ZHIQUERY_REPOSITORY (default: dataset), replacing each file only once
+      complete: the file of each --source <file>=<url>, or Zip_zhvi.csv, the
+      ZHVI of Zillow by zip code, without any. Run daemon to keep them up to
+      date`,
+		},
+		{
+			name:  "daemon",
+			run:   daemonCmd,
+			usage: []string{"daemon [flags]"},
+			description: `    * keep the dataset_dir of --data or $ZHIQUERY_REPOSITORY (default:
+      dataset) up to date and evaluate every saved query after each refresh,
+      storing the results in the json format in results/<name>.json of the
+      zhiquery config directory. Saved queries are only evaluated again once
+      they or the dataset files change. Downloads replace a dataset file only
+      once complete. Flags:
+      * --source <file>=<url>: download the dataset file from url into the
+        dataset_dir on every refresh, can be repeated. The dataset_dir must be
+        a single local directory
+      * --every <duration>: time between refreshes (default: 24h), 0 to
+        refresh once and exit
+      * --listen <addr>: serve Prometheus metrics on http://<addr>/metrics:
+        evaluation counts, durations, and match counts per saved query, and
+        row counts, refresh counts, and last refresh times per dataset`,
+			queryFlags: true,
+		},
+		{
+			name:  "serve",
+			run:   serveCmd,
+			usage: []string{"serve [<dataset_dir>] [flags]"},
+			description: `    * load the dataset_dir, or $ZHIQUERY_REPOSITORY (default: dataset), and
+      serve a dashboard with a query builder, a sortable results table, and
+      price charts on http://<addr>/ with --listen <addr> (default:
+      localhost:8080). The query flags apply to every query. A file of the
+      dataset_dir is loaded again once it changes, e.g. after a refresh of
+      daemon, while the rows of the other files are kept, unless --join or
+      --merge is set. Queries run against the previous rows until the new
+      ones are loaded, and results are cached per query until then or for
+      --cache-ttl <duration> (default: 10m, 0 disables the cache). The
+      dashboard uses a JSON API:
+      * GET /api/query?q=<query>&sort=<key>&desc=1&limit=<n>: the matches
+        as {"total": <n>, "offset": <n>, "columns": [...], "results":
+        [<json records>], "next": <cursor>}, at most 500 unless limit is
+        set. next is only set when more matches follow, and
+        /api/query?cursor=<cursor>&limit=<n> returns them, with the query,
+        sort, and order of the first page
+      * GET /api/series?zip=<zip_code>: the price history of the zip code
+        in every dataset
+      * GET /api/kinds: the filter kinds and sort keys
+      * GET /schema: the vocabulary of queries, like the schema command
+      * GET /metrics: Prometheus metrics: the count, durations, and match
+        counts of the queries of /api/query, and the rows loaded per dataset
+        file, reload counts, and the time of the last reload`,
+			queryFlags: true,
+		},
+		{
+			name:  "stats",
+			run:   statsCmd,
+			usage: []string{"stats <dataset_dir> [<query>] [flags]", "stats --data <dataset_dir> [<query>] [flags]"},
+			description: `    * print the summary statistics of the zip codes matching the query, or
+      of every zip code, per dataset and over all of them: the count of zip
+      codes, the median and range of the prices, and the median growth rate
+      and YoY. The query flags select the zip codes like for query, e.g.
+      --snapshot, or --limit with --sort for the top ones. The statistics
+      are a table, --format only takes text`,
+			queryFlags: true,
+		},
+		{
+			name:  "compare",
+			run:   compareCmd,
+			usage: []string{"compare <dataset_dir> <zip_code_1> <zip_code_2> ..."},
+			description: `    * print the metrics of the given zip codes side by side, the best value
+      of each metric is marked with a *`,
+		},
+		{
+			name:  "diff",
+			run:   diffCmd,
+			usage: []string{"diff <old_dataset_dir> <new_dataset_dir> [<query>]"},
+			description: `    * run the same query against two dataset snapshots and report which zip
+      codes newly match, which dropped out, and how the price and growth
+      rate of the zip codes matching in both changed. Without a query every
+      zip code matches`,
+		},
+		{
+			name:  "correlate",
+			run:   correlateCmd,
+			usage: []string{"correlate [<dataset_dir>] <zip_code_a> <zip_code_b>", "correlate [<dataset_dir>] <query>"},
+			description: `    * print the correlation of the monthly returns of two zip codes per
+      dataset, or the correlation matrix of the zip codes matching a query.
+      Uses $ZHIQUERY_REPOSITORY when no dataset_dir is given`,
+		},
+		{
+			name:  "spread",
+			run:   spreadCmd,
+			usage: []string{"spread [<dataset_dir>] <zip_code_a> <zip_code_b> [--threshold <z>]"},
+			description: `    * print the history of the price ratio of zip_code_a to zip_code_b per
+      dataset: its current value, mean, standard deviation, range, and the
+      z-score of the current ratio against its history. The spread is wide
+      when the z-score is at least --threshold (default: 2), narrow when
+      it's at most -threshold, and normal otherwise. Uses
+      $ZHIQUERY_REPOSITORY when no dataset_dir is given`,
+		},
+		{
+			name:  "portfolio",
+			run:   portfolioCmd,
+			usage: []string{"portfolio [<dataset_dir>] <portfolio_file>"},
+			description: `    * value the zip codes of a portfolio file with the latest prices of the
+      dataset_dir, or $ZHIQUERY_REPOSITORY (default: dataset): the price of
+      each owned zip code grown like its ZHI since its purchase, the change
+      and its yearly rate, and the totals of the portfolio. Zip codes
+      without a price are watched, their ZHI and its change since their
+      date are printed. The file is YAML, e.g.
+      dataset: 3-bedrooms.csv
+      holdings:
+        - zip: 94110
+          name: Mission condo
+          price: 850000
+          date: 2019-06
+        - zip: 78704
+          date: 2022-01
+      Holdings may set their own dataset, the first dataset holding the zip
+      code by name is used when neither does`,
+		},
+		{
+			name:  "gen-testdata",
+			run:   genTestdataCmd,
+			usage: []string{"gen-testdata [--rows <n>] [--months <n>] [--start <yyyy-mm>] [--missing <rate>] [--seed <n>] [--out <file>]"},
+			description: `    * write a synthetic ZHVI shaped export to --out, or stdout, for
+      performance work and integrations without Zillow's data: --rows zip
+      codes (default: 1000, at most 90000) over --months months (default: 240)
+      from --start (default: 2000-01). Every price history has its own trend,
+      seasonality, and noise, a quarter of them start late, and --missing
+      (default: 0.01) of the later months are left empty. The same --seed
+      (default: 1) writes the same file`,
+		},
+		{
+			name:  "schema",
+			run:   schemaCmd,
+			usage: []string{"schema [flags]"},
+			description: `    * print the vocabulary of queries as JSON, for UIs and completion
+      scripts: the filter kinds with the type of their argument (string,
+      float, uint, comparison, or custom), their bound or operators, the
+      numeric fields of --score and --define, the sort keys, the columns of
+      --fields, and the formats. The query flags add the fields they
+      register, e.g. --join or --define`,
+			queryFlags: true,
+		},
+		{
+			name:  "validate",
+			run:   validateCmd,
+			usage: []string{"validate [<dataset_dir>]"},
+			description: `    * check every file of the dataset_dir, or $ZHIQUERY_REPOSITORY (default:
+      dataset), for a header with a RegionName column and dates, rows with a
+      different number of columns than the header, invalid or duplicated zip
+      codes, months out of order, and truncated files. Prints a report per
+      file and exits with 1 when any file has problems`,
+		},
+		{
+			name:  "ingest",
+			run:   ingestCmd,
+			usage: []string{"ingest [<dataset_dir>]"},
+			description: `    * convert every file of the local dataset_dir, or $ZHIQUERY_REPOSITORY
+      (default: dataset), to a Parquet file in $ZHIQUERY_COLUMNS (default: the
+      zhiquery directory of the user cache directory). Queries then read the
+      Parquet files rather than parsing the csvs, and skip the states and rows
+      their State, County, Price, and PriceMin filters rule out. A Parquet
+      file is ignored once its csv changes, until ingest runs again.
+      The files have the columns of the csv, months as doubles with missing
+      prices as nulls, the ZhiqueryRow line of the csv and the
+      ZhiqueryGrowthRate of each row, and a row group per state, for other
+      tools, e.g. DuckDB:
+        SELECT State, avg(ZhiqueryGrowthRate) FROM 'Zip_zhvi.csv.parquet'
+        GROUP BY State`,
+		},
+		{
+			name:  "index",
+			run:   indexCmd,
+			usage: []string{"index [<dataset_dir>]"},
+			description: `    * like ingest, but only convert the files without an up to date Parquet
+      file, e.g. after a refresh of daemon, and list the others`,
+		},
+		{
+			name:  "inspect",
+			run:   inspectCmd,
+			usage: []string{"inspect <dataset_file>"},
+			description: `    * print what a dataset file covers: its region types, first and last
+      months, rows, rows per state, and the share of missing values, overall
+      and in its first and last months`,
+		},
+		{
+			name:  "completion",
+			run:   completionCmd,
+			usage: []string{"completion bash|zsh|fish"},
+			description: `    * print a completion script for bash, zsh, or fish, e.g.
+      source <(./zhiquery completion bash)`,
+		},
+		{
+			name:  "help",
+			run:   helpCmd,
+			usage: []string{"help [<command>]"},
+			description: `    * print this help, or the usage and description of a command, also
+      printed by ./zhiquery <command> --help`,
+		},
+		{
+			name:   "__complete",
+			run:    completeCmd,
+			hidden: true,
+		},
+	}
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+func commandNames() []string {
+	var names []string
+	for _, c := range commands {
+		if !c.hidden {
+			names = append(names, c.name)
+		}
+	}
+	return names
+}
+
+func isHelp(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+// printUsage prints the usage lines of c, prefixed for the first.
+func printUsage(c command, first bool) {
+	for _, line := range c.usage {
+		prefix := "       "
+		if first {
+			prefix, first = "Usage: ", false
+		}
+		fmt.Printf("%s./zhiquery %s\n", prefix, line)
+	}
+}
+
+// usage prints the help of the command name, or the whole help for query
+// and unknown names.
+func usage(name string) {
+	c, ok := findCommand(name)
+	if !ok || name == "query" {
+		help()
+		return
+	}
+
+	fmt.Println()
+	printUsage(c, true)
+	fmt.Println()
+	// unindent the bullet of the Commands section
+	for i, line := range strings.Split(c.description, "\n") {
+		if i == 0 {
+			line = strings.TrimPrefix(line, "    * ")
+			line = strings.ToUpper(line[:1]) + line[1:]
+		} else {
+			line = strings.TrimPrefix(line, "      ")
+		}
+		fmt.Println(line)
+	}
+	if c.queryFlags {
+		fmt.Println()
+		fmt.Println("The flags of query apply as well, see ./zhiquery help query")
+	}
+}
+
+// helpCmd prints the whole help, or the help of a command.
+func helpCmd(args []string) {
+	if len(args) == 0 {
+		help()
+		return
+	}
+	if _, ok := findCommand(args[0]); !ok {
+		must(fmt.Errorf("Couldn't find command %s", args[0]))
+	}
+	usage(args[0])
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestCommands(t *testing.T) {
+	names := map[string]bool{}
+	for _, c := range commands {
+		if c.run == nil {
+			t.Errorf("command %s has no run", c.name)
+		}
+		if names[c.name] {
+			t.Errorf("command %s is listed twice", c.name)
+		}
+		names[c.name] = true
+		if !c.hidden && (len(c.usage) == 0 || c.description == "") {
+			t.Errorf("command %s has no usage or description for help", c.name)
+		}
+	}
+	for _, name := range []string{"query", "serve", "download", "index", "stats", "compare", "inspect"} {
+		if !names[name] {
+			t.Errorf("command %s is missing", name)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+)
+
+type compareRow struct {
+	name  string
+	value func(d *Data) float64
+	// better reports whether a is a better value than b, nil when the row
+	// has no best value.
+	better func(a, b float64) bool
+	format func(v float64) string
+}
+
+func higher(a, b float64) bool { return a > b }
+func lower(a, b float64) bool  { return a < b }
+
+func formatPrice(v float64) string   { return "$" + humanize.Comma(int64(v)) }
+func formatPercent(v float64) string { return fmt.Sprintf("%.2f%%", v) }
+func formatYears(v float64) string   { return strconv.FormatFloat(v, 'f', -1, 64) }
+func formatQuality(v float64) string { return fmt.Sprintf("%.2f", v) }
+func formatPoints(v float64) string  { return fmt.Sprintf("%+.2fpp", v) }
+
+var compareRows = []compareRow{
+	{"Price", (*Data).Price, lower, formatPrice},
+	{"Growth Rate", func(d *Data) float64 { return d.GrowthRate }, higher, formatPercent},
+	{"YoY", func(d *Data) float64 { return d.YoY }, higher, formatPercent},
+	{"Volatility", func(d *Data) float64 { return d.Volatility }, lower, formatPercent},
+	{"Drawdown", func(d *Data) float64 { return d.Drawdown }, lower, formatPercent},
+	{"Yield", func(d *Data) float64 { return d.Yield }, higher, formatPercent},
+	{"CapRate", func(d *Data) float64 { return d.CapRate }, higher, formatPercent},
+	{"Years", func(d *Data) float64 { return d.Years }, nil, formatYears},
+	{"Quality", func(d *Data) float64 { return d.Quality }, higher, formatQuality},
+	{"Acceleration", func(d *Data) float64 { return d.Acceleration }, higher, formatPoints},
+	{"MissingPct", func(d *Data) float64 { return d.MissingPct }, lower, formatPercent},
+}
+
+func compareCmd(args []string) {
+	if len(args) < 2 {
+		usage("compare")
+		os.Exit(exitUsage)
+	}
+
+	order := map[uint64]int{}
+	var filters []FilterFn
+	for i, arg := range args[1:] {
+		zipCode, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			must(usageError(fmt.Errorf("Couldn't parse zip code %s", arg)))
+		}
+		order[zipCode] = i
+		filters = append(filters, filterByZipCode(zipCode))
+	}
+
+	datas := load(args[0], chainByOr(filters...))
+	sort.SliceStable(datas, func(i, j int) bool {
+		if datas[i].ZipCode != datas[j].ZipCode {
+			return order[datas[i].ZipCode] < order[datas[j].ZipCode]
+		}
+		return datas[i].Dataset < datas[j].Dataset
+	})
+
+	found := map[uint64]bool{}
+	for _, data := range datas {
+		found[data.ZipCode] = true
+	}
+	for _, arg := range args[1:] {
+		zipCode, _ := strconv.ParseUint(arg, 10, 64)
+		if !found[zipCode] {
+			fmt.Fprintf(os.Stderr, "Couldn't find zip code %d\n", zipCode)
+		}
+	}
+
+	if len(datas) == 0 {
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	writeRow := func(name string, cells []string) {
+		fmt.Fprintf(w, "%s\t%s\t\n", name, strings.Join(cells, "\t"))
+	}
+	writeStrings := func(name string, value func(d *Data) string) {
+		cells := make([]string, len(datas))
+		for i := range datas {
+			cells[i] = value(&datas[i])
+		}
+		writeRow(name, cells)
+	}
+
+	writeStrings("Zip Code", func(d *Data) string { return strconv.FormatUint(d.ZipCode, 10) })
+	writeStrings("Dataset", func(d *Data) string { return d.Dataset })
+	writeStrings("City", func(d *Data) string { return d.City })
+	writeStrings("State", func(d *Data) string { return d.State })
+	writeStrings("County", func(d *Data) string { return d.County })
+
+	for _, row := range compareRows {
+		writeRow(row.name, row.cells(datas))
+	}
+
+	must(w.Flush())
+}
+
+// cells formats the values of row of datas, marking the best ones with a *.
+func (row compareRow) cells(datas []Data) []string {
+	// the best value is only marked when some other value is worse, not
+	// when all of them are equal or missing
+	best, worse := math.NaN(), false
+	for i := range datas {
+		v := row.value(&datas[i])
+		if row.better == nil || math.IsNaN(v) {
+			continue
+		}
+		if math.IsNaN(best) || row.better(v, best) {
+			worse = worse || !math.IsNaN(best)
+			best = v
+		} else if v != best {
+			worse = true
+		}
+	}
+
+	cells := make([]string, len(datas))
+	for i := range datas {
+		cells[i] = row.format(row.value(&datas[i]))
+		if worse && row.value(&datas[i]) == best {
+			cells[i] += " *"
+		}
+	}
+	return cells
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestCompareCells(t *testing.T) {
+	nan := math.NaN()
+	value := func(d *Data) float64 { return d.GrowthRate }
+	highest := compareRow{"higher", value, higher, formatPercent}
+	lowest := compareRow{"lower", value, lower, formatPercent}
+	none := compareRow{"no best", value, nil, formatYears}
+
+	tests := []struct {
+		row    compareRow
+		values []float64
+		want   []string
+	}{
+		{highest, []float64{3, 5, 4}, []string{"3.00%", "5.00% *", "4.00%"}},
+		{lowest, []float64{3, 5, 4}, []string{"3.00% *", "5.00%", "4.00%"}},
+		{highest, []float64{5, 3, 5}, []string{"5.00% *", "3.00%", "5.00% *"}},
+		{highest, []float64{4, 4, 4}, []string{"4.00%", "4.00%", "4.00%"}},
+		{highest, []float64{nan, 2, 1}, []string{"NaN%", "2.00% *", "1.00%"}},
+		{highest, []float64{nan, 2, nan}, []string{"NaN%", "2.00%", "NaN%"}},
+		{highest, []float64{nan, nan}, []string{"NaN%", "NaN%"}},
+		{none, []float64{1, 2}, []string{"1", "2"}},
+	}
+	for _, tt := range tests {
+		datas := make([]Data, len(tt.values))
+		for i, v := range tt.values {
+			datas[i].GrowthRate = v
+		}
+		if got := tt.row.cells(datas); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("cells of %v with the %s value best = %q, want %q", tt.values, tt.row.name, got, tt.want)
+		}
+	}
+}
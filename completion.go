@@ -0,0 +1,222 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const bashCompletion = `_zhiquery() {
+	local line="${COMP_LINE:0:COMP_POINT}"
+	local cur="${line##*[[:space:]]}"
+	local IFS=$'\n'
+	local words=(${line//[[:space:]]/$'\n'})
+	[[ -z "$cur" ]] && words+=("")
+	COMPREPLY=($(zhiquery __complete "${words[@]:1}"))
+	if [[ ${#COMPREPLY[@]} -eq 1 && ( "${COMPREPLY[0]}" == *: || "${COMPREPLY[0]}" == */ ) ]]; then
+		compopt -o nospace
+	fi
+	if [[ "$cur" == *:* && "$COMP_WORDBREAKS" == *:* ]]; then
+		local prefix="${cur%"${cur##*:}"}"
+		COMPREPLY=("${COMPREPLY[@]#"$prefix"}")
+	fi
+}
+complete -F _zhiquery zhiquery
+`
+
+const zshCompletion = `#compdef zhiquery
+_zhiquery() {
+	local -a candidates nospace
+	candidates=("${(@f)$(zhiquery __complete "${(@)words[2,CURRENT]}")}")
+	nospace=(${(M)candidates:#*[:/]})
+	candidates=(${candidates:#*[:/]})
+	compadd -S '' -- $nospace
+	compadd -- $candidates
+}
+compdef _zhiquery zhiquery
+`
+
+const fishCompletion = `complete -c zhiquery -f -a '(zhiquery __complete (commandline -opc)[2..-1] (commandline -ct))'
+`
+
+func completionCmd(args []string) {
+	scripts := map[string]string{
+		"bash": bashCompletion,
+		"zsh":  zshCompletion,
+		"fish": fishCompletion,
+	}
+
+	if len(args) != 1 {
+		usage("completion")
+		os.Exit(exitUsage)
+	}
+
+	script, ok := scripts[args[0]]
+	if !ok {
+		must(fmt.Errorf("Unsupported shell %s", args[0]))
+	}
+	fmt.Print(script)
+}
+
+// completeCmd prints the candidates for the last argument given the
+// arguments before it. It backs the generated completion scripts.
+func completeCmd(args []string) {
+	if len(args) == 0 {
+		return
+	}
+
+	for _, candidate := range complete(args[:len(args)-1], args[len(args)-1]) {
+		fmt.Println(candidate)
+	}
+}
+
+func complete(words []string, cur string) []string {
+	var candidates []string
+
+	var prev string
+	if len(words) > 0 {
+		prev = words[len(words)-1]
+	}
+
+	switch {
+	case len(words) == 0:
+		candidates = append(append(candidates, commandNames()...), completeDirs(cur)...)
+	case words[0] == "completion":
+		if len(words) == 1 {
+			candidates = []string{"bash", "zsh", "fish"}
+		}
+	case prev == "-sort" || prev == "--sort":
+		for key := range allFields(nil).sortKeys {
+			candidates = append(candidates, key)
+		}
+	case prev == "-format" || prev == "--format":
+		for format := range formats {
+			candidates = append(candidates, format)
+		}
+	case prev == "-benchmark" || prev == "--benchmark":
+		for benchmark := range benchmarks {
+			candidates = append(candidates, benchmark)
+		}
+	case prev == "-heatmap-by" || prev == "--heatmap-by":
+		candidates = []string{"zip", "county"}
+	case prev == "-dedupe" || prev == "--dedupe":
+		candidates = []string{"latest", "all"}
+		datasets, _ := ioutil.ReadDir(repository())
+		for _, dataset := range datasets {
+			candidates = append(candidates, "prefer:"+dataset.Name())
+		}
+	case strings.HasPrefix(cur, "-"):
+		var opts options
+		fs := flag.NewFlagSet("zhiquery", flag.ContinueOnError)
+		opts.register(fs)
+		fs.VisitAll(func(f *flag.Flag) {
+			candidates = append(candidates, "--"+f.Name)
+		})
+	case words[0] == "help":
+		if len(words) == 1 {
+			candidates = commandNames()
+		}
+	case (words[0] == "query" || words[0] == "stats") && len(words) == 1:
+		candidates = completeDirs(cur)
+	case words[0] == "validate" || words[0] == "ingest" || words[0] == "index" || words[0] == "download":
+		if len(words) == 1 {
+			candidates = completeDirs(cur)
+		}
+	case words[0] == "inspect":
+		if len(words) == 1 {
+			candidates = completeFiles(cur)
+		}
+	case words[0] == "portfolio":
+		if len(words) <= 2 {
+			candidates = completeFiles(cur)
+		}
+	case (words[0] == "run" || words[0] == "alert") && len(words) == 1:
+		queries, err := loadSavedQueries()
+		if err == nil {
+			for name := range queries {
+				candidates = append(candidates, name)
+			}
+		}
+	case words[0] == "save" && len(words) == 1:
+	case strings.HasPrefix(cur, "Dataset:"):
+		dir := words[0]
+		if (dir == "query" || dir == "stats") && len(words) > 1 {
+			dir = words[1]
+		}
+		if dir == "run" || dir == "alert" || dir == "save" || dir == "correlate" || dir == "spread" {
+			dir = repository()
+		}
+
+		datasets, _ := ioutil.ReadDir(dir)
+		for _, dataset := range datasets {
+			candidates = append(candidates, "Dataset:"+dataset.Name())
+		}
+	default:
+		candidates = append(candidates, tokenGroupStart, tokenGroupEnd, "and", "or")
+		for _, kind := range filterKinds(nil) {
+			candidates = append(candidates, kind+":")
+		}
+	}
+
+	var matched []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, cur) {
+			matched = append(matched, candidate)
+		}
+	}
+	sort.Strings(matched)
+	return matched
+}
+
+func filterKinds(v *vocabulary) []string {
+	var kinds []string
+	for kind := range stringFilters {
+		kinds = append(kinds, kind)
+	}
+	for kind := range floatFilters {
+		kinds = append(kinds, kind)
+	}
+	for kind := range uintFilters {
+		kinds = append(kinds, kind)
+	}
+	for kind := range allFields(v).comparisonFilters {
+		kinds = append(kinds, kind)
+	}
+	for kind := range customFilters {
+		kinds = append(kinds, kind)
+	}
+	kinds = append(kinds, providerKinds()...)
+	sort.Strings(kinds)
+	return kinds
+}
+
+func completeDirs(cur string) []string {
+	matches, _ := filepath.Glob(cur + "*")
+
+	var dirs []string
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && info.IsDir() {
+			dirs = append(dirs, path.Clean(match)+"/")
+		}
+	}
+	return dirs
+}
+
+func completeFiles(cur string) []string {
+	matches, _ := filepath.Glob(cur + "*")
+
+	var files []string
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && info.IsDir() {
+			files = append(files, path.Clean(match)+"/")
+		} else if err == nil {
+			files = append(files, match)
+		}
+	}
+	return files
+}
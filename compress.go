@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+type datasetReader struct {
+	io.Reader
+	closers []func() error
+}
+
+func (r *datasetReader) Close() error {
+	var err error
+	for _, close := range r.closers {
+		if closeErr := close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// openDataset opens a dataset file, decompressed.
+func openDataset(p string) (io.ReadCloser, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(f)
+}
+
+// decompress decompresses gzip and zstd files detected by their magic
+// bytes, e.g. 3-bedrooms.csv.gz, and passes other files through.
+func decompress(f io.ReadCloser) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(f)
+	magic, _ := buffered.Peek(len(zstdMagic))
+	r := &datasetReader{Reader: buffered, closers: []func() error{f.Close}}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		r.Reader = gz
+		r.closers = append([]func() error{gz.Close}, r.closers...)
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(buffered)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		r.Reader = zr
+		r.closers = append([]func() error{func() error { zr.Close(); return nil }}, r.closers...)
+	}
+	return r, nil
+}
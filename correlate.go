@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// correlation returns the Pearson correlation of the monthly returns of a
+// and b over the months where both series have values, and the number of
+// returns used.
+func correlation(a, b []float64) (float64, int) {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	// align the most recent months
+	a, b = a[len(a)-n:], b[len(b)-n:]
+
+	var ras, rbs []float64
+	for i := 1; i < n; i++ {
+		if a[i-1] != 0 && a[i] != 0 && b[i-1] != 0 && b[i] != 0 {
+			ras = append(ras, a[i]/a[i-1]-1)
+			rbs = append(rbs, b[i]/b[i-1]-1)
+		}
+	}
+	if len(ras) < 2 {
+		return math.NaN(), len(ras)
+	}
+
+	var meanA, meanB float64
+	for i := range ras {
+		meanA += ras[i]
+		meanB += rbs[i]
+	}
+	meanA /= float64(len(ras))
+	meanB /= float64(len(rbs))
+
+	var cov, varA, varB float64
+	for i := range ras {
+		cov += (ras[i] - meanA) * (rbs[i] - meanB)
+		varA += (ras[i] - meanA) * (ras[i] - meanA)
+		varB += (rbs[i] - meanB) * (rbs[i] - meanB)
+	}
+	return cov / math.Sqrt(varA*varB), len(ras)
+}
+
+func correlateCmd(args []string) {
+	repo := repository()
+	if len(args) > 0 && !strings.HasPrefix(args[0], tokenGroupStart) {
+		if _, err := strconv.ParseUint(args[0], 10, 64); err != nil {
+			repo, args = args[0], args[1:]
+		}
+	}
+
+	if len(args) == 0 {
+		usage("correlate")
+		os.Exit(exitUsage)
+	}
+
+	if strings.HasPrefix(args[0], tokenGroupStart) {
+		tokens := tokenize(args)
+		filter, _, err := parseFilters(tokens, nil)
+		if err != nil {
+			must(&queryError{err, tokens})
+		}
+		correlateMatrix(load(repo, filter))
+		return
+	}
+
+	if len(args) != 2 {
+		usage("correlate")
+		os.Exit(exitUsage)
+	}
+
+	zipA, err := strconv.ParseUint(args[0], 10, 64)
+	must(usageError(err))
+	zipB, err := strconv.ParseUint(args[1], 10, 64)
+	must(usageError(err))
+
+	datas := load(repo, chainByOr(filterByZipCode(zipA), filterByZipCode(zipB)))
+	as, bs := map[string]*Data{}, map[string]*Data{}
+	for i := range datas {
+		if datas[i].ZipCode == zipA {
+			as[datas[i].Dataset] = &datas[i]
+		}
+		if datas[i].ZipCode == zipB {
+			bs[datas[i].Dataset] = &datas[i]
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	found := false
+	for _, dataset := range sortedDatasets(as) {
+		b, ok := bs[dataset]
+		if !ok {
+			continue
+		}
+
+		found = true
+		r, n := correlation(as[dataset].ZHIs, b.ZHIs)
+		fmt.Fprintf(w, "%s\t%.4f\t(%d monthly returns)\t\n", dataset, r, n)
+	}
+	must(w.Flush())
+
+	if !found {
+		must(datasetError(fmt.Errorf("Couldn't find a dataset with both %d and %d", zipA, zipB)))
+	}
+}
+
+func correlateMatrix(datas []Data) {
+	must(sortDatas(datas, "ZipCode", nil))
+	must(sortDatas(datas, "Dataset", nil))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', tabwriter.AlignRight)
+	label := func(d *Data) string { return fmt.Sprintf("%d %s", d.ZipCode, d.Dataset) }
+
+	fmt.Fprint(w, "\t")
+	for i := range datas {
+		fmt.Fprintf(w, "%d\t", datas[i].ZipCode)
+	}
+	fmt.Fprintln(w)
+
+	for i := range datas {
+		fmt.Fprintf(w, "%s\t", label(&datas[i]))
+		for j := range datas {
+			if datas[i].Dataset != datas[j].Dataset {
+				fmt.Fprint(w, "-\t")
+				continue
+			}
+
+			r, _ := correlation(datas[i].ZHIs, datas[j].ZHIs)
+			fmt.Fprintf(w, "%.2f\t", r)
+		}
+		fmt.Fprintln(w)
+	}
+	must(w.Flush())
+}
+
+func sortedDatasets(byDataset map[string]*Data) []string {
+	var datasets []string
+	for dataset := range byDataset {
+		datasets = append(datasets, dataset)
+	}
+	sort.Strings(datasets)
+	return datasets
+}
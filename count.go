@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+)
+
+// count writes the number of rows matching tree, by the value of
+// --count-by when set. Matches are counted while loading unless the query
+// depends on the whole result set. Counts of a --sample are scaled to
+// estimate the counts of the whole datasets.
+func count(w io.Writer, repository string, tree *filterNode, opts options) error {
+	key := func(*Data) string { return "" }
+	// the fields of exact match filters are known while loading
+	loaded := true
+	if opts.countBy != "" {
+		columns, err := parseFields(opts.countBy, opts.vocabulary)
+		if err != nil {
+			return err
+		}
+		key = func(d *Data) string { return formatValue(columns[0].value(d)) }
+
+		_, isString := stringFilters[columns[0].name]
+		_, isUint := uintFilters[columns[0].name]
+		loaded = isString || isUint
+	}
+
+	counts := map[string]int{}
+	if !loaded || tree.aggregate || opts.dedupeWins != nil || len(opts.definitions) > 0 {
+		datas, err := search(repository, tree.filter, opts)
+		if err != nil {
+			return err
+		}
+		for i := range datas {
+			counts[key(&datas[i])]++
+		}
+	} else {
+		var mu sync.Mutex
+		loadWith(repository, func(d *Data) bool {
+			if tree.filter(d) {
+				mu.Lock()
+				counts[key(d)]++
+				mu.Unlock()
+			}
+			return false
+		}, opts, nil)
+	}
+
+	if opts.countBy == "" {
+		_, err := fmt.Fprintln(w, estimate(counts[""], opts))
+		return err
+	}
+
+	var keys []string
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, k := range keys {
+		fmt.Fprintf(tw, "%s\t%d\n", k, estimate(counts[k], opts))
+	}
+	return tw.Flush()
+}
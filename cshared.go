@@ -0,0 +1,33 @@
+//go:build cshared
+
+// The c-shared library of the query engine, built with
+//
+//	go build -tags cshared -buildmode=c-shared -o libzhiquery.so .
+//
+// which also writes libzhiquery.h. See queryLibraryJSON for the json of
+// ZhiqueryQuery.
+package main
+
+// #include <stdlib.h>
+import "C"
+
+import "unsafe"
+
+func init() {
+	embedded = true
+}
+
+// ZhiqueryQuery runs the query of the json array of arguments, returning a
+// json string to release with ZhiqueryFree.
+//
+//export ZhiqueryQuery
+func ZhiqueryQuery(args *C.char) *C.char {
+	return C.CString(queryLibraryJSON(C.GoString(args)))
+}
+
+// ZhiqueryFree releases a string returned by ZhiqueryQuery.
+//
+//export ZhiqueryFree
+func ZhiqueryFree(s *C.char) {
+	C.free(unsafe.Pointer(s))
+}
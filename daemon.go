@@ -0,0 +1,214 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// source is a dataset file of the repository and the url it's downloaded
+// from.
+type source struct {
+	file string
+	url  string
+}
+
+func parseSources(specs []string) ([]source, error) {
+	var sources []source
+	for _, spec := range specs {
+		splitted := strings.SplitN(spec, "=", 2)
+		if len(splitted) != 2 || splitted[0] == "" || splitted[1] == "" {
+			return nil, fmt.Errorf("--source expects <file>=<url>, got %s", spec)
+		}
+		if path.Base(splitted[0]) != splitted[0] {
+			return nil, fmt.Errorf("Invalid dataset file name %s", splitted[0])
+		}
+		sources = append(sources, source{splitted[0], splitted[1]})
+	}
+	return sources, nil
+}
+
+type daemon struct {
+	repository string
+	sources    []source
+	opts       options
+	metrics    *metrics
+	// evaluated is the key of the last evaluation of each saved query, the
+	// query isn't evaluated again until its key changes.
+	evaluated map[string]cacheKey
+}
+
+func (dm *daemon) refresh() {
+	for _, s := range dm.sources {
+		start := time.Now()
+		if err := downloadSource(dm.repository, s); err != nil {
+			dm.metrics.add("zhiquery_dataset_refreshes_total", labels("dataset", s.file, "result", "error"), 1)
+			logger.Error("Couldn't refresh dataset", "dataset", s.file, "error", err)
+			continue
+		}
+		dm.metrics.add("zhiquery_dataset_refreshes_total", labels("dataset", s.file, "result", "ok"), 1)
+		dm.metrics.set("zhiquery_dataset_last_refresh_timestamp_seconds", labels("dataset", s.file), float64(time.Now().Unix()))
+		logger.Info("Refreshed dataset", "dataset", s.file, "duration", time.Since(start))
+	}
+}
+
+func resultsPath(name string) (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(dir, "results", name+".json"), nil
+}
+
+// evaluate runs the saved query name and stores its results in the json
+// format.
+func (dm *daemon) evaluate(name string, tokens []string) error {
+	tree, _, err := parseFilterTree(tokens, dm.opts.vocabulary)
+	if err != nil {
+		return err
+	}
+
+	datas, err := search(dm.repository, dm.opts.restrict(tree).filter, dm.opts)
+	if err != nil {
+		return err
+	}
+	if err := sortDatas(datas, dm.opts.sort, dm.opts.vocabulary); err != nil {
+		return err
+	}
+	dm.metrics.set("zhiquery_query_matches", labels("query", name), float64(len(datas)))
+
+	p, err := resultsPath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(path.Dir(p), "."+name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := writeJSON(tmp, datas, dm.opts); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	logger.Info("Evaluated saved query", "query", name, "matches", len(datas))
+	return os.Rename(tmp.Name(), p)
+}
+
+func (dm *daemon) hasResults(name string) bool {
+	p, err := resultsPath(name)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(p)
+	return err == nil
+}
+
+// run refreshes the datasets, then evaluates every saved query that
+// changed or whose datasets changed since its last evaluation. Saved
+// queries are reloaded each time, so queries saved while the daemon runs
+// are picked up.
+func (dm *daemon) run() {
+	dm.refresh()
+	dm.metrics.countDatasetRows(dm.repository)
+
+	queries, err := loadSavedQueries()
+	if err != nil {
+		logger.Error("Couldn't load saved queries", "error", err)
+		return
+	}
+
+	fp, err := fingerprint(dm.repository, dm.opts.snapshot)
+	if err != nil {
+		logger.Error("Couldn't fingerprint datasets", "repository", dm.repository, "error", err)
+		return
+	}
+
+	var names []string
+	for name := range queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		start := time.Now()
+		result := "ok"
+		key := cacheKey{tokensString(queries[name]), dm.opts.sort, fp}
+		if dm.evaluated[name] == key && dm.hasResults(name) {
+			result = "cached"
+			logger.Info("Skipped unchanged saved query", "query", name)
+		} else if err := dm.evaluate(name, queries[name]); err != nil {
+			result = "error"
+			delete(dm.evaluated, name)
+			logger.Error("Couldn't evaluate saved query", "query", name, "error", err)
+		} else {
+			dm.evaluated[name] = key
+		}
+		dm.metrics.add("zhiquery_query_evaluations_total", labels("query", name, "result", result), 1)
+		dm.metrics.observe("zhiquery_query_duration_seconds", labels("query", name), time.Since(start).Seconds())
+	}
+}
+
+func daemonCmd(args []string) {
+	var opts options
+	var every time.Duration
+	var specs stringsFlag
+	var listen string
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	opts.register(fs)
+	fs.DurationVar(&every, "every", 24*time.Hour, "")
+	fs.Var(&specs, "source", "")
+	fs.StringVar(&listen, "listen", "", "")
+	args = parseArgs(fs, args)
+
+	if len(args) > 0 {
+		usage("daemon")
+		os.Exit(exitUsage)
+	}
+
+	sources, err := parseSources(specs)
+	must(usageError(err))
+	must(usageError(opts.prepare()))
+	repo := opts.repository(repository())
+	if len(sources) > 0 {
+		if isRemote(repo) || len(splitRepositories(repo)) != 1 {
+			must(usageError(fmt.Errorf("Couldn't download the sources into %s, --source needs a single local dataset_dir", repo)))
+		}
+		must(os.MkdirAll(repo, 0755))
+	}
+
+	dm := &daemon{repository: repo, sources: sources, opts: opts, metrics: newMetrics(daemonMetrics), evaluated: map[string]cacheKey{}}
+	if listen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", dm.metrics)
+		go func() {
+			must(http.ListenAndServe(listen, mux))
+		}()
+	}
+
+	for {
+		dm.run()
+		if every == 0 {
+			return
+		}
+		time.Sleep(every)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+func lastMonth(d *Data) string {
+	if len(d.Months) == 0 {
+		return ""
+	}
+	return d.Months[len(d.Months)-1]
+}
+
+// dedupePolicies report whether a should win over b, both rows of the same
+// zip code.
+var dedupePolicies = map[string]func(arg string) func(a, b *Data) bool{
+	"latest": func(string) func(a, b *Data) bool { return newer },
+	"prefer": func(dataset string) func(a, b *Data) bool {
+		return func(a, b *Data) bool {
+			if (a.Dataset == dataset) != (b.Dataset == dataset) {
+				return a.Dataset == dataset
+			}
+			return newer(a, b)
+		}
+	},
+}
+
+// newer prefers the dataset ending later, then the first dataset by name.
+func newer(a, b *Data) bool {
+	if lastMonth(a) != lastMonth(b) {
+		return lastMonth(a) > lastMonth(b)
+	}
+	return a.Dataset < b.Dataset
+}
+
+// parseDedupe returns nil for all, which keeps every row.
+func parseDedupe(policy string) (func(a, b *Data) bool, error) {
+	if policy == "all" {
+		return nil, nil
+	}
+
+	splitted := strings.SplitN(policy, ":", 2)
+	newPolicy, ok := dedupePolicies[splitted[0]]
+	if !ok || (splitted[0] == "prefer") != (len(splitted) == 2) {
+		return nil, fmt.Errorf("Couldn't find dedupe policy %s, use latest, prefer:<dataset>, or all", policy)
+	}
+
+	var arg string
+	if len(splitted) == 2 {
+		arg = splitted[1]
+	}
+	return newPolicy(arg), nil
+}
+
+// dedupe keeps one row per zip code, the one winning by wins, and records
+// the datasets hiding the other rows in Duplicates.
+func dedupe(datas []Data, wins func(a, b *Data) bool) []Data {
+	if wins == nil {
+		return datas
+	}
+
+	winners := map[uint64]int{}
+	duplicates := map[uint64][]string{}
+	for i := range datas {
+		d := &datas[i]
+		// neighborhoods without a zip code are never duplicates
+		if d.ZipCode == 0 {
+			continue
+		}
+		j, ok := winners[d.ZipCode]
+		if !ok {
+			winners[d.ZipCode] = i
+			continue
+		}
+
+		if wins(d, &datas[j]) {
+			winners[d.ZipCode] = i
+			duplicates[d.ZipCode] = append(duplicates[d.ZipCode], datas[j].Dataset)
+		} else {
+			duplicates[d.ZipCode] = append(duplicates[d.ZipCode], d.Dataset)
+		}
+	}
+
+	deduped := datas[:0]
+	for i := range datas {
+		d := datas[i]
+		if d.ZipCode != 0 && winners[d.ZipCode] != i {
+			continue
+		}
+
+		d.Duplicates = duplicates[d.ZipCode]
+		sort.Strings(d.Duplicates)
+		deduped = append(deduped, d)
+	}
+	return deduped
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDedupe(t *testing.T) {
+	rows := func() []Data {
+		return []Data{
+			{Dataset: "a.csv", ZipCode: 1, Months: []string{"2023-01"}},
+			{Dataset: "b.csv", ZipCode: 1, Months: []string{"2023-06"}},
+			{Dataset: "c.csv", ZipCode: 1, Months: []string{"2023-06"}},
+			{Dataset: "a.csv", ZipCode: 2, Months: []string{"2023-01"}},
+			{Dataset: "a.csv", Neighborhood: "Mission", Months: []string{"2023-01"}},
+			{Dataset: "b.csv", Neighborhood: "Mission", Months: []string{"2023-06"}},
+		}
+	}
+	type kept struct {
+		dataset    string
+		zipCode    uint64
+		duplicates []string
+	}
+	tests := []struct {
+		policy string
+		want   []kept
+	}{
+		{"latest", []kept{{"b.csv", 1, []string{"a.csv", "c.csv"}}, {"a.csv", 2, nil}, {"a.csv", 0, nil}, {"b.csv", 0, nil}}},
+		{"prefer:a.csv", []kept{{"a.csv", 1, []string{"b.csv", "c.csv"}}, {"a.csv", 2, nil}, {"a.csv", 0, nil}, {"b.csv", 0, nil}}},
+	}
+	for _, test := range tests {
+		wins, err := parseDedupe(test.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []kept
+		for _, d := range dedupe(rows(), wins) {
+			got = append(got, kept{d.Dataset, d.ZipCode, d.Duplicates})
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: dedupe = %v, want %v", test.policy, got, test.want)
+		}
+	}
+
+	if wins, err := parseDedupe("all"); err != nil || len(dedupe(rows(), wins)) != 6 {
+		t.Errorf("all: dedupe didn't keep every row")
+	}
+	for _, policy := range []string{"newest", "prefer", "latest:a.csv"} {
+		if _, err := parseDedupe(policy); err == nil {
+			t.Errorf("parseDedupe(%s) succeeded, want an error", policy)
+		}
+	}
+}
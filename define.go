@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+	"unicode"
+)
+
+// stringsFlag collects the values of a repeated flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ", ") }
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+type definition struct {
+	name string
+	expr expr
+}
+
+func isIdentifier(s string) bool {
+	for i, r := range s {
+		if !(r == '_' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r))) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// vocabulary holds the fields a query adds with --define, --join, and
+// --by-home-type to those of every query: the numeric fields of
+// expressions, the comparison filters, the sort keys, and the columns. A nil
+// vocabulary adds none.
+type vocabulary struct {
+	numericFields     map[string]func(d *Data) float64
+	comparisonFilters map[string]comparisonField
+	sortKeys          map[string]func(a, b *Data) bool
+	columns           []column
+}
+
+// add makes value the field name of the query.
+func (v *vocabulary) add(name string, value func(d *Data) float64, aggregate bool) error {
+	if _, ok := lookupField(name, v); ok {
+		return fmt.Errorf("Field %s is already defined", name)
+	}
+	if _, ok := lookupSortKey(name, v); ok {
+		return fmt.Errorf("Field %s is already defined", name)
+	}
+
+	if v.numericFields == nil {
+		v.numericFields = map[string]func(d *Data) float64{}
+		v.comparisonFilters = map[string]comparisonField{}
+		v.sortKeys = map[string]func(a, b *Data) bool{}
+	}
+	v.numericFields[name] = value
+	v.comparisonFilters[name] = comparisonField{value, aggregate}
+	v.sortKeys[name] = func(a, b *Data) bool { return value(a) < value(b) }
+	v.columns = append(v.columns, column{name, func(d *Data) interface{} { return value(d) }})
+	return nil
+}
+
+// allFields returns the fields of every query, the registered metrics
+// included, together with those of v, for listing them.
+func allFields(v *vocabulary) vocabulary {
+	all := vocabulary{maps.Clone(numericFields), maps.Clone(comparisonFilters), maps.Clone(sortKeys), queryColumns(v)}
+	for _, fields := range []*vocabulary{&metricFields, v} {
+		if fields == nil {
+			continue
+		}
+		maps.Copy(all.numericFields, fields.numericFields)
+		maps.Copy(all.comparisonFilters, fields.comparisonFilters)
+		maps.Copy(all.sortKeys, fields.sortKeys)
+	}
+	return all
+}
+
+// parseDefinitions compiles <name>=<expression> definitions and adds each
+// name to v, for later expressions, filters, sorting, and output.
+// Definitions can refer to the ones before them.
+func parseDefinitions(defines []string, v *vocabulary) ([]definition, error) {
+	var definitions []definition
+	for _, define := range defines {
+		splitted := strings.SplitN(define, "=", 2)
+		if len(splitted) != 2 {
+			return nil, fmt.Errorf("--define expects <name>=<expression>, got %s", define)
+		}
+
+		name := strings.TrimSpace(splitted[0])
+		if !isIdentifier(name) {
+			return nil, fmt.Errorf("Invalid field name %q", name)
+		}
+		if _, ok := lookupField(name, v); ok {
+			return nil, fmt.Errorf("Field %s is already defined", name)
+		}
+
+		e, err := parseExpr(splitted[1], v)
+		if err != nil {
+			return nil, err
+		}
+		if err := v.add(name, func(d *Data) float64 { return d.Defined[name] }, true); err != nil {
+			return nil, err
+		}
+
+		definitions = append(definitions, definition{name, e})
+	}
+	return definitions, nil
+}
+
+// evaluate computes the defined fields of d in order.
+func evaluate(d *Data, definitions []definition) {
+	if len(definitions) == 0 {
+		return
+	}
+
+	d.Defined = make(map[string]float64, len(definitions))
+	for _, def := range definitions {
+		d.Defined[def.name] = def.expr(d)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+type dataKey struct {
+	dataset string
+	zipCode uint64
+}
+
+func keyDatas(datas []Data) map[dataKey]*Data {
+	keyed := map[dataKey]*Data{}
+	for i := range datas {
+		keyed[dataKey{datas[i].Dataset, datas[i].ZipCode}] = &datas[i]
+	}
+	return keyed
+}
+
+func sortedKeys(keyed map[dataKey]*Data, keep func(dataKey) bool) []dataKey {
+	var keys []dataKey
+	for key := range keyed {
+		if keep(key) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].dataset != keys[j].dataset {
+			return keys[i].dataset < keys[j].dataset
+		}
+		return keys[i].zipCode < keys[j].zipCode
+	})
+	return keys
+}
+
+func matchAll(*Data) bool { return true }
+
+func diffCmd(args []string) {
+	if len(args) < 2 {
+		usage("diff")
+		os.Exit(exitUsage)
+	}
+
+	filter := FilterFn(matchAll)
+	if tokens := tokenize(args[2:]); len(tokens) > 0 {
+		var err error
+		filter, _, err = parseFilters(tokens, nil)
+		must(err)
+	}
+
+	opts := options{benchmark: "us"}
+	oldDatas, err := search(args[0], filter, opts)
+	must(err)
+	newDatas, err := search(args[1], filter, opts)
+	must(err)
+	must(writeDiff(os.Stdout, oldDatas, newDatas))
+}
+
+// priceChange is the change in percent from old to d, n/a when the old
+// price is 0 or missing.
+func priceChange(old, d float64) string {
+	change := (d/old - 1) * 100
+	if math.IsInf(change, 0) || math.IsNaN(change) {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.2f%%", change)
+}
+
+// writeDiff writes the zip codes matching only in newDatas, only in
+// oldDatas, and the changes of those matching in both.
+func writeDiff(out io.Writer, oldDatas, newDatas []Data) error {
+	olds, news := keyDatas(oldDatas), keyDatas(newDatas)
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	describe := func(d *Data) string {
+		return fmt.Sprintf("%d\t%s, %s\t%s", d.ZipCode, d.City, d.State, d.Dataset)
+	}
+
+	added := sortedKeys(news, func(key dataKey) bool { return olds[key] == nil })
+	fmt.Fprintln(w, "New matches:")
+	for _, key := range added {
+		d := news[key]
+		fmt.Fprintf(w, "  %s\t%s\t%s\t\n", describe(d), formatPrice(d.Price()), formatPercent(d.GrowthRate))
+	}
+
+	dropped := sortedKeys(olds, func(key dataKey) bool { return news[key] == nil })
+	fmt.Fprintln(w, "\nDropped out:")
+	for _, key := range dropped {
+		d := olds[key]
+		fmt.Fprintf(w, "  %s\t%s\t%s\t\n", describe(d), formatPrice(d.Price()), formatPercent(d.GrowthRate))
+	}
+
+	kept := sortedKeys(news, func(key dataKey) bool { return olds[key] != nil })
+	fmt.Fprintln(w, "\nChanged:")
+	for _, key := range kept {
+		old, d := olds[key], news[key]
+		fmt.Fprintf(w, "  %s\t%s -> %s (%s)\t%s -> %s (%+.2fpp)\t\n",
+			describe(d),
+			formatPrice(old.Price()), formatPrice(d.Price()), priceChange(old.Price(), d.Price()),
+			formatPercent(old.GrowthRate), formatPercent(d.GrowthRate), d.GrowthRate-old.GrowthRate)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(out, "\nNew: %d, Dropped: %d, Matched in both: %d\n", len(added), len(dropped), len(kept))
+	return err
+}
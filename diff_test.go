@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteDiff(t *testing.T) {
+	row := func(zipCode uint64, price, growth float64) Data {
+		return Data{Dataset: "zhvi.csv", ZipCode: zipCode, City: "Springfield", State: "IL", ZHIs: []float64{price}, GrowthRate: growth}
+	}
+	olds := []Data{row(10001, 100000, 4), row(10002, 200000, 3), row(10003, 0, 1)}
+	news := []Data{row(10002, 220000, 3.5), row(10003, 150000, 2), row(10004, 300000, 6)}
+
+	var b bytes.Buffer
+	if err := writeDiff(&b, olds, news); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	sections := strings.Split(out, "\n\n")
+	if len(sections) != 4 {
+		t.Fatalf("writeDiff wrote %d sections, want 4:\n%s", len(sections), out)
+	}
+
+	for i, want := range []struct {
+		title    string
+		included []string
+		excluded []string
+	}{
+		{"New matches:", []string{"10004", "$300,000"}, []string{"10001", "10002", "10003"}},
+		{"Dropped out:", []string{"10001", "$100,000"}, []string{"10002", "10003", "10004"}},
+		{"Changed:", []string{"$200,000 -> $220,000 (+10.00%)", "3.00% -> 3.50% (+0.50pp)", "$0 -> $150,000 (n/a)"}, []string{"10001", "10004", "Inf", "NaN"}},
+	} {
+		section := sections[i]
+		if !strings.HasPrefix(section, want.title) {
+			t.Errorf("section %d = %q, want %s", i+1, section, want.title)
+		}
+		for _, s := range want.included {
+			if !strings.Contains(section, s) {
+				t.Errorf("%s has no %s:\n%s", want.title, s, section)
+			}
+		}
+		for _, s := range want.excluded {
+			if strings.Contains(section, s) {
+				t.Errorf("%s has %s:\n%s", want.title, s, section)
+			}
+		}
+	}
+	if want := "New: 1, Dropped: 1, Matched in both: 2\n"; sections[3] != want {
+		t.Errorf("summary = %q, want %q", sections[3], want)
+	}
+}
+
+func TestPriceChange(t *testing.T) {
+	for _, tt := range []struct {
+		old, d float64
+		want   string
+	}{
+		{100, 110, "+10.00%"},
+		{100, 90, "-10.00%"},
+		{0, 100, "n/a"},
+		{0, 0, "n/a"},
+	} {
+		if got := priceChange(tt.old, tt.d); got != tt.want {
+			t.Errorf("priceChange(%v, %v) = %s, want %s", tt.old, tt.d, got, tt.want)
+		}
+	}
+}
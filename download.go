@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+)
+
+// defaultSources are downloaded when download isn't given a --source: the
+// ZHVI of every home type by zip code.
+var defaultSources = []string{
+	"Zip_zhvi.csv=https://files.zillowstatic.com/research/public_csvs/zhvi/Zip_zhvi_uc_sfrcondo_tier_0.33_0.67_sm_sa_month.csv",
+}
+
+// downloadSource replaces the dataset file of s in repository once it's
+// completely downloaded, so queries never see a partial file.
+func downloadSource(repository string, s source) error {
+	resp, err := httpClient.Get(s.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s responded %s", s.url, resp.Status)
+	}
+
+	tmp, err := ioutil.TempFile(repository, "."+s.file)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path.Join(repository, s.file))
+}
+
+func downloadCmd(args []string) {
+	var specs stringsFlag
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	fs.Var(&specs, "source", "")
+	args = parseArgs(fs, args)
+
+	if len(args) > 1 {
+		usage("download")
+		os.Exit(exitUsage)
+	}
+
+	repo := repository()
+	if len(args) == 1 {
+		repo = args[0]
+	}
+	if len(specs) == 0 {
+		specs = defaultSources
+	}
+	sources, err := parseSources(specs)
+	must(usageError(err))
+	if isRemote(repo) || len(splitRepositories(repo)) != 1 {
+		must(usageError(fmt.Errorf("Couldn't download the sources into %s, download needs a single local dataset_dir", repo)))
+	}
+	must(os.MkdirAll(repo, 0755))
+
+	for _, s := range sources {
+		start := time.Now()
+		if err := downloadSource(repo, s); err != nil {
+			must(fmt.Errorf("Couldn't download %s: %v", s.file, err))
+		}
+		fmt.Printf("%s: downloaded in %v from %s\n", s.file, time.Since(start).Round(time.Millisecond), s.url)
+	}
+}
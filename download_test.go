@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDownloadIsNotListedUntilComplete(t *testing.T) {
+	repository := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(repository, "Zip_zhvi.csv"), []byte("RegionName\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(repository, "2023-01"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// the download stalls after its first row until listed is closed
+	started, listed := make(chan struct{}), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("RegionID,RegionName\n"))
+		w.(http.Flusher).Flush()
+		close(started)
+		<-listed
+		w.Write([]byte("1,94110\n"))
+	}))
+	defer server.Close()
+
+	done := make(chan error)
+	go func() { done <- downloadSource(repository, source{"Zip_zori.csv", server.URL}) }()
+
+	<-started
+	partial, err := listDatasets(repository)
+	close(listed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Zip_zhvi.csv"}; !reflect.DeepEqual(partial, want) {
+		t.Errorf("listDatasets during the download = %v, want %v", partial, want)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	complete, err := listDatasets(repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Zip_zhvi.csv", "Zip_zori.csv"}; !reflect.DeepEqual(complete, want) {
+		t.Errorf("listDatasets after the download = %v, want %v", complete, want)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(repository, "Zip_zori.csv"))
+	if err != nil || string(b) != "RegionID,RegionName\n1,94110\n" {
+		t.Errorf("downloaded %q, %v", b, err)
+	}
+}
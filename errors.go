@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Exit codes, by kind of failure, so that scripts can tell them apart.
+const (
+	// exitFailure is any other error.
+	exitFailure = 1
+	// exitUsage is an invalid query, flag, or argument, like the exit code
+	// of the flag package.
+	exitUsage = 2
+	// exitNoMatches is a query matching no zip code.
+	exitNoMatches = 3
+	// exitDataset is a dataset_dir or dataset file that couldn't be read.
+	exitDataset = 4
+)
+
+// exitKinds name the exit codes in the --errors json objects.
+var exitKinds = map[int]string{
+	exitFailure:   "error",
+	exitUsage:     "usage",
+	exitNoMatches: "no_matches",
+	exitDataset:   "dataset",
+}
+
+// errorFormat is --errors, set by options.prepare as must is called from
+// everywhere.
+var errorFormat = "text"
+
+// exitError is an error exiting with code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code, err}
+}
+
+// usageError marks err as an invalid query, flag, or argument.
+func usageError(err error) error {
+	return withExitCode(exitUsage, err)
+}
+
+// datasetError marks err as a dataset that couldn't be read.
+func datasetError(err error) error {
+	return withExitCode(exitDataset, err)
+}
+
+// queryError is a query that couldn't be parsed, printed with the tokens
+// of the query and the failing token underlined when known.
+type queryError struct {
+	err    error
+	tokens []string
+}
+
+func (e *queryError) Error() string {
+	var perr *parseError
+	if errors.As(e.err, &perr) {
+		return fmt.Sprintf("%v\n%s", e.err, perr.pointAt(e.tokens))
+	}
+	return e.err.Error()
+}
+
+func (e *queryError) Unwrap() error {
+	return e.err
+}
+
+func exitCode(err error) int {
+	var eerr *exitError
+	var qerr *queryError
+	switch {
+	case errors.As(err, &qerr):
+		return exitUsage
+	case errors.As(err, &eerr):
+		return eerr.code
+	default:
+		return exitFailure
+	}
+}
+
+// errorObject is the json of an error with --errors json. Token is the
+// 1-based token of the query the error is at, when known.
+type errorObject struct {
+	Error string `json:"error"`
+	Kind  string `json:"kind"`
+	Code  int    `json:"code"`
+	Token int    `json:"token,omitempty"`
+}
+
+// newErrorObject returns the errorObject of err.
+func newErrorObject(err error) errorObject {
+	code := exitCode(err)
+	object := errorObject{Error: err.Error(), Kind: exitKinds[code], Code: code}
+	var qerr *queryError
+	if errors.As(err, &qerr) {
+		object.Error = qerr.err.Error()
+	}
+	var perr *parseError
+	if errors.As(err, &perr) {
+		object.Error, object.Token = perr.msg, perr.token+1
+	}
+	return object
+}
+
+// embedded is set by the library builds, where exiting would exit the
+// process loading the library, see queryLibrary.
+var embedded bool
+
+// embeddedExit is the panic of exit in the library builds.
+type embeddedExit struct {
+	err error
+}
+
+// exit prints err, on stdout or as json on stderr with --errors json, and
+// exits with its code. In the library builds it panics with an embeddedExit
+// instead.
+func exit(err error) {
+	if embedded {
+		panic(embeddedExit{err})
+	}
+	if errorFormat != "json" {
+		fmt.Println(err)
+		os.Exit(exitCode(err))
+	}
+
+	object := newErrorObject(err)
+	json.NewEncoder(os.Stderr).Encode(object)
+	os.Exit(object.Code)
+}
+
+// noMatches reports a query matching no zip code with --errors json, and
+// returns its exit code.
+func noMatches() int {
+	if errorFormat == "json" {
+		json.NewEncoder(os.Stderr).Encode(errorObject{
+			Error: "No zip code matched the query",
+			Kind:  exitKinds[exitNoMatches],
+			Code:  exitNoMatches,
+		})
+	}
+	return exitNoMatches
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// numericFields are the fields expressions can refer to, matched case
+// insensitively.
+var numericFields = map[string]func(d *Data) float64{
+	"ZipCode":        func(d *Data) float64 { return float64(d.ZipCode) },
+	"GrowthRate":     func(d *Data) float64 { return d.GrowthRate },
+	"RealGrowthRate": func(d *Data) float64 { return d.RealGrowthRate },
+	"Income":         func(d *Data) float64 { return d.Income },
+	"Affordability":  func(d *Data) float64 { return d.Affordability },
+	"Yield":          func(d *Data) float64 { return d.Yield },
+	"CapRate":        func(d *Data) float64 { return d.CapRate },
+	"Payment":        func(d *Data) float64 { return d.Payment },
+	"Tax":            func(d *Data) float64 { return d.Tax },
+	"TaxRate":        func(d *Data) float64 { return d.TaxRate },
+	"Inventory":      func(d *Data) float64 { return d.Inventory },
+	"DaysOnMarket":   func(d *Data) float64 { return d.DaysOnMarket },
+	"SizeRank":       func(d *Data) float64 { return float64(d.SizeRank) },
+	"RankDelta":      func(d *Data) float64 { return float64(d.RankDelta) },
+	"Years":          func(d *Data) float64 { return d.Years },
+	"YoY":            func(d *Data) float64 { return d.YoY },
+	"Volatility":     func(d *Data) float64 { return d.Volatility },
+	"Drawdown":       func(d *Data) float64 { return d.Drawdown },
+	"Quality":        func(d *Data) float64 { return d.Quality },
+	"Acceleration":   func(d *Data) float64 { return d.Acceleration },
+	"MissingPct":     func(d *Data) float64 { return d.MissingPct },
+	"RelGrowth":      func(d *Data) float64 { return d.RelGrowth },
+	"GrowthPct":      func(d *Data) float64 { return d.GrowthPct },
+	"PricePct":       func(d *Data) float64 { return d.PricePct },
+	"GrowthStatePct": func(d *Data) float64 { return d.GrowthStatePct },
+	"PriceStatePct":  func(d *Data) float64 { return d.PriceStatePct },
+	"Price":          (*Data).Price,
+}
+
+var fieldAliases = map[string]string{
+	"growth": "GrowthRate",
+	"cagr":   "GrowthRate",
+	"zip":    "ZipCode",
+}
+
+// lookupField returns the numeric field name, of every query or of v.
+func lookupField(name string, v *vocabulary) (func(d *Data) float64, bool) {
+	if alias, ok := fieldAliases[strings.ToLower(name)]; ok {
+		name = alias
+	}
+
+	fields := []map[string]func(d *Data) float64{numericFields, metricFields.numericFields}
+	if v != nil {
+		fields = append(fields, v.numericFields)
+	}
+	for _, m := range fields {
+		for field, value := range m {
+			if strings.EqualFold(field, name) {
+				return value, true
+			}
+		}
+	}
+	return nil, false
+}
+
+type expr func(d *Data) float64
+
+// exprParser compiles arithmetic over numeric fields:
+//
+//	expr    = term { ("+" | "-") term }
+//	term    = unary { ("*" | "/") unary }
+//	unary   = "-" unary | primary
+//	primary = number | field | "(" expr ")"
+type exprParser struct {
+	src    string
+	pos    int
+	fields *vocabulary
+}
+
+func parseExpr(src string, v *vocabulary) (expr, error) {
+	p := &exprParser{src: src, fields: v}
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpaces()
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q", p.src[p.pos:])
+	}
+	return e, nil
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("Invalid expression %q at %d: %s", p.src, p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *exprParser) skipSpaces() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// peek returns the next non-space byte, or 0 at the end.
+func (p *exprParser) peek() byte {
+	p.skipSpaces()
+	if p.pos == len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *exprParser) expr() (expr, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			return left, nil
+		}
+		p.pos++
+
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+
+		l := left
+		if op == '+' {
+			left = func(d *Data) float64 { return l(d) + right(d) }
+		} else {
+			left = func(d *Data) float64 { return l(d) - right(d) }
+		}
+	}
+}
+
+func (p *exprParser) term() (expr, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' {
+			return left, nil
+		}
+		p.pos++
+
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		l := left
+		if op == '*' {
+			left = func(d *Data) float64 { return l(d) * right(d) }
+		} else {
+			left = func(d *Data) float64 { return l(d) / right(d) }
+		}
+	}
+}
+
+func (p *exprParser) unary() (expr, error) {
+	if p.peek() == '-' {
+		p.pos++
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(d *Data) float64 { return -e(d) }, nil
+	}
+
+	return p.primary()
+}
+
+func (p *exprParser) primary() (expr, error) {
+	c := p.peek()
+	start := p.pos
+
+	switch {
+	case c == '(':
+		p.pos++
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, p.errorf("expected )")
+		}
+		p.pos++
+		return e, nil
+	case c == '.' || unicode.IsDigit(rune(c)):
+		for p.pos < len(p.src) && (p.src[p.pos] == '.' || unicode.IsDigit(rune(p.src[p.pos]))) {
+			p.pos++
+		}
+		number := p.src[start:p.pos]
+		v, err := strconv.ParseFloat(number, 64)
+		if err != nil {
+			p.pos = start
+			return nil, p.errorf("invalid number %q", number)
+		}
+		return func(*Data) float64 { return v }, nil
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || unicode.IsLetter(rune(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos]))) {
+			p.pos++
+		}
+		name := p.src[start:p.pos]
+		value, ok := lookupField(name, p.fields)
+		if !ok {
+			p.pos = start
+			return nil, p.errorf("couldn't find field %s", name)
+		}
+		return expr(value), nil
+	case c == 0:
+		return nil, p.errorf("unexpected end")
+	default:
+		return nil, p.errorf("unexpected %q", c)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseExpr(t *testing.T) {
+	d := &Data{GrowthRate: 4, YoY: 2, Volatility: 1, ZHIs: []float64{100, 200}}
+	tests := []struct {
+		src  string
+		want float64
+	}{
+		{"1", 1},
+		{"growth", 4},
+		{"GrowthRate", 4},
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"-growth + 10", 6},
+		{"--2", 2},
+		{"growth / yoy - volatility", 1},
+		{"8 / 2 / 2", 2},
+		{"10 - 2 - 3", 5},
+		{"growth*0.5 + yoy*0.3 - volatility*0.2", 2.4},
+		{"Price / 100", 2},
+		{" 1.5 * 10 ", 15},
+	}
+	for _, tt := range tests {
+		e, err := parseExpr(tt.src, nil)
+		if err != nil {
+			t.Errorf("parseExpr(%q) = %v", tt.src, err)
+			continue
+		}
+		if got := e(d); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("parseExpr(%q) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	for _, src := range []string{"", "1 +", "(1 + 2", "1 2", "Unknown * 2", "2 $ 3", ")"} {
+		if _, err := parseExpr(src, nil); err == nil {
+			t.Errorf("parseExpr(%q) = nil error, want an error", src)
+		}
+	}
+}
+
+func TestDefinitionsArePerQuery(t *testing.T) {
+	d := &Data{GrowthRate: 4, YoY: 2}
+	a, b := &vocabulary{}, &vocabulary{}
+	da, err := parseDefinitions([]string{"Twice=growth*2", "More=Twice+1"}, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := parseDefinitions([]string{"Twice=yoy*2"}, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseDefinitions([]string{"Twice=1"}, a); err == nil {
+		t.Error("parseDefinitions redefined Twice")
+	}
+
+	for _, tt := range []struct {
+		v           *vocabulary
+		definitions []definition
+		want        float64
+	}{
+		{a, da, 8},
+		{b, db, 4},
+	} {
+		if _, _, err := parseFilterTree(tokenize([]string{"[ Twice:>=6 ]"}), tt.v); err != nil {
+			t.Fatal(err)
+		}
+		value, ok := lookupField("twice", tt.v)
+		if !ok {
+			t.Fatal("lookupField(twice) = false after --define")
+		}
+		evaluate(d, tt.definitions)
+		if got := value(d); got != tt.want {
+			t.Errorf("Twice = %v, want %v", got, tt.want)
+		}
+	}
+	if _, _, err := parseFilterTree(tokenize([]string{"[ Twice:>=6 ]"}), nil); err == nil {
+		t.Error("Twice is a filter of queries without --define")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// foldedLetters maps the accented Latin letters of place names to the
+// letters they're written with without their accents, as datasets spell
+// some names either way, e.g. Doña Ana and Dona Ana.
+var foldedLetters = map[rune]string{}
+
+func init() {
+	for base, letters := range map[string]string{
+		"a":  "àáâãäåāăą",
+		"c":  "çćĉċč",
+		"d":  "ďđ",
+		"e":  "èéêëēĕėęě",
+		"g":  "ĝğġģ",
+		"h":  "ĥħ",
+		"i":  "ìíîïĩīĭįı",
+		"j":  "ĵ",
+		"k":  "ķ",
+		"l":  "ĺļľŀł",
+		"n":  "ñńņňŉ",
+		"o":  "òóôõöøōŏő",
+		"r":  "ŕŗř",
+		"s":  "śŝşš",
+		"t":  "ţťŧ",
+		"u":  "ùúûüũūŭůűų",
+		"w":  "ŵ",
+		"y":  "ýÿŷ",
+		"z":  "źżž",
+		"ae": "æ",
+		"oe": "œ",
+		"ss": "ß",
+	} {
+		for _, r := range letters {
+			foldedLetters[r] = base
+		}
+	}
+}
+
+// foldText normalizes a name for comparisons: lowercased, without accents,
+// and with its spaces trimmed and collapsed, e.g. "Doña  Ana " is "dona
+// ana". Every string filter and the values they match are compared folded.
+func foldText(s string) string {
+	if isFolded(s) {
+		return s
+	}
+
+	var b strings.Builder
+	space := false
+	for _, r := range strings.TrimSpace(s) {
+		r = unicode.ToLower(r)
+		switch {
+		case unicode.IsSpace(r):
+			space = true
+			continue
+		// combining accents of decomposed letters
+		case unicode.Is(unicode.Mn, r):
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		if folded, ok := foldedLetters[r]; ok {
+			b.WriteString(folded)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// isFolded reports whether foldText would return s as it is, which is the
+// case of most names, to spare them an allocation.
+func isFolded(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= utf8.RuneSelf || ('A' <= c && c <= 'Z') || (c == ' ' && (i == 0 || i == len(s)-1 || s[i+1] == ' ')) || (c != ' ' && unicode.IsSpace(rune(c))) {
+			return false
+		}
+	}
+	return true
+}
+
+// equalFolded reports whether a and b are the same name once folded.
+func equalFolded(a, b string) bool {
+	return a == b || foldText(a) == foldText(b)
+}
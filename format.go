@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// priceColumns hold dollar amounts, formatted with --price-format.
+var priceColumns = map[string]bool{
+	"Price":   true,
+	"Income":  true,
+	"Payment": true,
+	"Tax":     true,
+}
+
+// priceFormats format a dollar amount, without its $, for --price-format.
+var priceFormats = map[string]func(v float64, o options) string{
+	// raw is the plain number, e.g. 350000
+	"raw": func(v float64, o options) string { return o.float(v) },
+	// comma groups the thousands, e.g. 350,000
+	"comma": func(v float64, o options) string {
+		if o.precise {
+			return humanize.CommafWithDigits(v, o.precision)
+		}
+		return humanize.Comma(int64(math.Round(v)))
+	},
+	// short abbreviates thousands and millions, e.g. 350k or 1.2M
+	"short": func(v float64, o options) string {
+		value, prefix := humanize.ComputeSI(v)
+		precision := 1
+		if o.precise {
+			precision = o.precision
+		}
+		formatted := strconv.FormatFloat(value, 'f', precision, 64)
+		if !o.precise && strings.Contains(formatted, ".") {
+			formatted = strings.TrimSuffix(strings.TrimRight(formatted, "0"), ".")
+		}
+		return formatted + prefix
+	},
+}
+
+// roundTo rounds v to precision decimals.
+func roundTo(v float64, precision int) float64 {
+	pow := math.Pow(10, float64(precision))
+	return math.Round(v*pow) / pow
+}
+
+// float formats v with --precision decimals, or as few as needed to be
+// exact without it.
+func (o options) float(v float64) string {
+	if !o.precise || math.IsNaN(v) || math.IsInf(v, 0) {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'f', o.precision, 64)
+}
+
+// money formats the dollar amount v with --price-format, or with fallback
+// when it isn't set.
+func (o options) money(v float64, fallback string) string {
+	format := o.priceFormat
+	if format == "" {
+		format = fallback
+	}
+	return "$" + priceFormats[format](v, o)
+}
+
+// formatColumns rounds the float values of columns to --precision and
+// formats the values of priceColumns with --price-format, when set.
+func (o options) formatColumns(columns []column) []column {
+	if !o.precise && o.priceFormat == "" {
+		return columns
+	}
+
+	formatted := make([]column, len(columns))
+	for i, c := range columns {
+		value, isPrice := c.value, priceColumns[c.name]
+		formatted[i] = column{c.name, func(d *Data) interface{} {
+			v, ok := value(d).(float64)
+			if !ok || math.IsNaN(v) || math.IsInf(v, 0) {
+				return value(d)
+			}
+			if isPrice && o.priceFormat != "" && o.priceFormat != "raw" {
+				return priceFormats[o.priceFormat](v, o)
+			}
+			if o.precise {
+				return roundTo(v, o.precision)
+			}
+			return v
+		}}
+	}
+	return formatted
+}
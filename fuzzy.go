@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dustin/go-humanize/english"
+)
+
+// fuzzyPrefix marks a City or County argument matching similar names, e.g.
+// City:~=Pittsburg.
+const fuzzyPrefix = "~="
+
+// nameAbbreviations are abbreviated before comparing names, so that Saint
+// Paul matches St. Paul.
+var nameAbbreviations = map[string]string{
+	"saint":  "st",
+	"sainte": "ste",
+	"fort":   "ft",
+	"mount":  "mt",
+	"port":   "pt",
+}
+
+// normalizeName lowercases name and drops its punctuation and county
+// suffix, e.g. St. Mary's County becomes st marys.
+func normalizeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '.' || r == '\'' {
+			return -1
+		}
+		if r == '-' {
+			return ' '
+		}
+		return r
+	}, name)
+
+	words := strings.Fields(countyKey("", name)[1])
+	for i, word := range words {
+		if abbreviation, ok := nameAbbreviations[word]; ok {
+			words[i] = abbreviation
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// nameDistance is the edit distance of two normalized names.
+func nameDistance(a, b string) int {
+	return editDistance(normalizeName(a), normalizeName(b))
+}
+
+// isSimilarName reports whether name is within a typo of target, about one
+// edit every 5 letters.
+func isSimilarName(target, name string) bool {
+	target = normalizeName(target)
+	return editDistance(target, normalizeName(name)) <= len([]rune(target))/5
+}
+
+// filterByFuzzyName matches the rows whose value is a similar name to
+// name. Names are compared once per distinct value.
+func filterByFuzzyName(name string, value func(d *Data) string) FilterFn {
+	var similar sync.Map
+	return FilterFn(func(d *Data) bool {
+		v := value(d)
+		if matched, ok := similar.Load(v); ok {
+			return matched.(bool)
+		}
+		matched := isSimilarName(name, v)
+		similar.Store(v, matched)
+		return matched
+	})
+}
+
+// nameFilters are the filter kinds suggestNames suggests values for.
+var nameFilters = map[string]func(d *Data) string{
+	"City":         func(d *Data) string { return d.City },
+	"County":       func(d *Data) string { return d.County },
+	"Neighborhood": func(d *Data) string { return d.Neighborhood },
+}
+
+// maxSuggestions bounds the names suggested per filter.
+const maxSuggestions = 3
+
+// suggestNames writes the closest names to the argument of every City and
+// County filter of tree matching no row of repository, since spelling
+// differences like Saint and St. otherwise silently match nothing.
+func suggestNames(w io.Writer, repository string, tree *filterNode, opts options) {
+	type nameFilter struct{ kind, arg string }
+	var filters []nameFilter
+	var walk func(node *filterNode)
+	walk = func(node *filterNode) {
+		if node == nil {
+			return
+		}
+		if node.op != "" {
+			walk(node.left)
+			walk(node.right)
+			return
+		}
+		splitted := strings.SplitN(node.token, ":", 2)
+		if _, ok := nameFilters[splitted[0]]; ok && len(splitted) == 2 && !strings.HasPrefix(splitted[1], fuzzyPrefix) {
+			filters = append(filters, nameFilter{splitted[0], splitted[1]})
+		}
+	}
+	walk(tree)
+	if len(filters) == 0 {
+		return
+	}
+
+	names := map[string]map[string]bool{}
+	for kind := range nameFilters {
+		names[kind] = map[string]bool{}
+	}
+	var mu sync.Mutex
+	loadWith(repository, func(d *Data) bool {
+		mu.Lock()
+		for kind, value := range nameFilters {
+			if name := value(d); name != "" {
+				names[kind][name] = true
+			}
+		}
+		mu.Unlock()
+		return false
+	}, opts, nil)
+
+	for _, filter := range filters {
+		var candidates []string
+		found := false
+		for name := range names[filter.kind] {
+			if equalFolded(name, filter.arg) {
+				found = true
+				break
+			}
+			candidates = append(candidates, name)
+		}
+		if found {
+			continue
+		}
+
+		best := len([]rune(filter.arg))/3 + 1
+		distances := map[string]int{}
+		var closest []string
+		for _, name := range candidates {
+			if d := nameDistance(filter.arg, name); d <= best {
+				distances[name] = d
+				closest = append(closest, name)
+			}
+		}
+		sort.Slice(closest, func(i, j int) bool {
+			if distances[closest[i]] != distances[closest[j]] {
+				return distances[closest[i]] < distances[closest[j]]
+			}
+			return closest[i] < closest[j]
+		})
+		if len(closest) > maxSuggestions {
+			closest = closest[:maxSuggestions]
+		}
+
+		token := tokensString([]string{filter.kind + ":" + filter.arg})
+		if len(closest) == 0 {
+			fmt.Fprintf(w, "%s matches no zip code\n", token)
+			continue
+		}
+		fuzzy := tokensString([]string{filter.kind + ":" + fuzzyPrefix + filter.arg})
+		fmt.Fprintf(w, "%s matches no zip code, did you mean %s? %s matches similar names\n",
+			token, english.OxfordWordSeries(closest, "or"), fuzzy)
+	}
+}
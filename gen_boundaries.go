@@ -0,0 +1,165 @@
+//go:build ignore
+// +build ignore
+
+// gen_boundaries.go regenerates geodata/boundaries.csv from the census
+// cartographic boundary files of counties and ZCTAs:
+//
+//	go generate
+//
+// Points are rounded to 3 decimals, about 100m, dropping the points that
+// round to the previous one, to keep the embedded table small.
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var kmls = []struct {
+	kind string
+	url  string
+}{
+	{"county", "https://www2.census.gov/geo/tiger/GENZ2020/kml/cb_2020_us_county_20m.zip"},
+	{"zip", "https://www2.census.gov/geo/tiger/GENZ2020/kml/cb_2020_us_zcta520_500k.zip"},
+}
+
+type placemark struct {
+	Data []struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"ExtendedData>SchemaData>SimpleData"`
+	Polygons []polygon `xml:"Polygon"`
+	Multi    []polygon `xml:"MultiGeometry>Polygon"`
+}
+
+type polygon struct {
+	Outer []string `xml:"outerBoundaryIs>LinearRing>coordinates"`
+	Inner []string `xml:"innerBoundaryIs>LinearRing>coordinates"`
+}
+
+func must(err error) {
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func main() {
+	out, err := os.Create("geodata/boundaries.csv")
+	must(err)
+	defer out.Close()
+
+	w := bufio.NewWriter(out)
+	cw := csv.NewWriter(w)
+	must(cw.Write([]string{"Kind", "GEOID", "Rings"}))
+	for _, kml := range kmls {
+		must(writeKML(cw, kml.kind, kml.url))
+	}
+	cw.Flush()
+	must(cw.Error())
+	must(w.Flush())
+}
+
+func writeKML(cw *csv.Writer, kind, url string) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return err
+	}
+
+	f, err := archive.File[0].Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := xml.NewDecoder(f)
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "Placemark" {
+			continue
+		}
+		var p placemark
+		if err := dec.DecodeElement(&p, &start); err != nil {
+			return err
+		}
+
+		var geoid string
+		for _, data := range p.Data {
+			// ZCTAs name it GEOID20 since 2020
+			if data.Name == "GEOID" || data.Name == "GEOID20" {
+				geoid = data.Value
+			}
+		}
+		var rings []string
+		for _, polygon := range append(p.Polygons, p.Multi...) {
+			for _, coordinates := range append(polygon.Outer, polygon.Inner...) {
+				if ring := roundRing(coordinates); ring != "" {
+					rings = append(rings, ring)
+				}
+			}
+		}
+		if geoid == "" || len(rings) == 0 {
+			continue
+		}
+		if err := cw.Write([]string{kind, geoid, strings.Join(rings, ";")}); err != nil {
+			return err
+		}
+	}
+}
+
+// roundRing rounds the lng,lat[,alt] points of a KML ring, dropping the
+// altitudes and the repeated points.
+func roundRing(coordinates string) string {
+	var points []string
+	for _, point := range strings.Fields(coordinates) {
+		fields := strings.Split(point, ",")
+		if len(fields) < 2 {
+			continue
+		}
+		lng, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		lat, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+
+		rounded := strconv.FormatFloat(lng, 'f', 3, 64) + "," + strconv.FormatFloat(lat, 'f', 3, 64)
+		if len(points) == 0 || points[len(points)-1] != rounded {
+			points = append(points, rounded)
+		}
+	}
+	if len(points) < 3 {
+		return ""
+	}
+	return strings.Join(points, " ")
+}
@@ -0,0 +1,65 @@
+//go:build ignore
+// +build ignore
+
+// gen_zips.go regenerates geodata/zips.csv from the census ZCTA gazetteer:
+//
+//	go generate
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const gazetteer = "https://www2.census.gov/geo/docs/maps-data/data/gazetteer/2020_Gazetteer/2020_Gaz_zcta_national.zip"
+
+func must(err error) {
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func main() {
+	resp, err := http.Get(gazetteer)
+	must(err)
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	must(err)
+
+	archive, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	must(err)
+
+	f, err := archive.File[0].Open()
+	must(err)
+	defer f.Close()
+
+	out, err := os.Create("geodata/zips.csv")
+	must(err)
+	defer out.Close()
+
+	w := bufio.NewWriter(out)
+	fmt.Fprintln(w, "ZipCode,Latitude,Longitude")
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan()
+	header := strings.Fields(scanner.Text())
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		fmt.Fprintf(w, "%s,%s,%s\n", fields[columns["GEOID"]], fields[columns["INTPTLAT"]], fields[columns["INTPTLONG"]])
+	}
+	must(scanner.Err())
+	must(w.Flush())
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:generate go run gen_zips.go
+
+// embeddedZips maps zip codes to their centroid, see gen_zips.go.
+//
+//go:embed geodata/zips.csv
+var embeddedZips string
+
+type coordinate struct {
+	lat, lng float64
+}
+
+var (
+	zipsOnce sync.Once
+	zips     map[uint64]coordinate
+	zipsErr  error
+)
+
+// loadZips returns the zip code centroids, read from $ZHIQUERY_ZIPS when set
+// or from the embedded table otherwise. The embedded table is empty unless
+// go generate filled it before building, and an empty table is an error, as
+// every geographic filter would silently match nothing.
+func loadZips() (map[uint64]coordinate, error) {
+	zipsOnce.Do(func() {
+		table, source := embeddedZips, "the embedded zip table, which go generate fills,"
+		if p := os.Getenv("ZHIQUERY_ZIPS"); p != "" {
+			b, err := ioutil.ReadFile(p)
+			if err != nil {
+				zipsErr = err
+				return
+			}
+			table, source = string(b), p
+		}
+
+		zips, zipsErr = parseZips(strings.NewReader(table))
+		if zipsErr == nil && len(zips) == 0 {
+			zipsErr = usageError(fmt.Errorf("Couldn't find any zip code centroid in %s, set $ZHIQUERY_ZIPS to a zip,lat,lng csv, e.g. the geodata/zips.csv of go generate", source))
+		}
+	})
+
+	return zips, zipsErr
+}
+
+func parseZips(r io.Reader) (map[uint64]coordinate, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 3
+
+	// ignore header
+	if _, err := cr.Read(); err != nil {
+		return nil, fmt.Errorf("Invalid zip table: %v", err)
+	}
+
+	table := map[uint64]coordinate{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return table, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("Invalid zip table: %v", err)
+		}
+
+		zipCode, err := strconv.ParseUint(record[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid zip table: %v", err)
+		}
+		lat, err := strconv.ParseFloat(record[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid zip table: %v", err)
+		}
+		lng, err := strconv.ParseFloat(record[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid zip table: %v", err)
+		}
+
+		table[zipCode] = coordinate{lat, lng}
+	}
+}
+
+const (
+	earthRadiusMiles = 3958.8
+	kmPerMile        = 1.609344
+)
+
+// distance returns the great-circle distance between a and b in miles.
+func distance(a, b coordinate) float64 {
+	toRadians := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRadians(b.lat - a.lat)
+	dLng := toRadians(b.lng - a.lng)
+	h := math.Pow(math.Sin(dLat/2), 2) +
+		math.Cos(toRadians(a.lat))*math.Cos(toRadians(b.lat))*math.Pow(math.Sin(dLng/2), 2)
+	return 2 * earthRadiusMiles * math.Asin(math.Sqrt(h))
+}
+
+// parseDistance parses a distance like 25, 25mi, or 40km into miles.
+func parseDistance(s string) (float64, error) {
+	scale := 1.0
+	if strings.HasSuffix(s, "km") {
+		s, scale = strings.TrimSuffix(s, "km"), 1/kmPerMile
+	} else {
+		s = strings.TrimSuffix(s, "mi")
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return v * scale, nil
+}
+
+func zipCoordinate(zipCode uint64) (coordinate, error) {
+	zips, err := loadZips()
+	if err != nil {
+		return coordinate{}, err
+	}
+
+	c, ok := zips[zipCode]
+	if !ok {
+		return coordinate{}, fmt.Errorf("Couldn't find coordinates for zip code %d", zipCode)
+	}
+	return c, nil
+}
+
+func filterByNear(arg string) (FilterFn, error) {
+	splitted := strings.Split(arg, ":")
+	if len(splitted) != 2 {
+		return nil, fmt.Errorf("Near expects <zip_code>:<distance>, got %s", arg)
+	}
+
+	zipCode, err := strconv.ParseUint(splitted[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	miles, err := parseDistance(splitted[1])
+	if err != nil {
+		return nil, err
+	}
+	center, err := zipCoordinate(zipCode)
+	if err != nil {
+		return nil, err
+	}
+
+	zips, _ := loadZips()
+	return FilterFn(func(d *Data) bool {
+		c, ok := zips[d.ZipCode]
+		return ok && distance(center, c) <= miles
+	}), nil
+}
+
+func filterByBBox(arg string) (FilterFn, error) {
+	splitted := strings.Split(arg, ",")
+	if len(splitted) != 4 {
+		return nil, fmt.Errorf("BBox expects <min_lat>,<min_lng>,<max_lat>,<max_lng>, got %s", arg)
+	}
+
+	var bounds [4]float64
+	for i, s := range splitted {
+		v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, err
+		}
+		bounds[i] = v
+	}
+	min, max := coordinate{bounds[0], bounds[1]}, coordinate{bounds[2], bounds[3]}
+	if min.lat > max.lat || min.lng > max.lng {
+		return nil, fmt.Errorf("BBox minimum %s is above its maximum", arg)
+	}
+
+	zips, err := loadZips()
+	if err != nil {
+		return nil, err
+	}
+
+	return FilterFn(func(d *Data) bool {
+		c, ok := zips[d.ZipCode]
+		return ok && c.lat >= min.lat && c.lat <= max.lat && c.lng >= min.lng && c.lng <= max.lng
+	}), nil
+}
+
+const adjacentNeighbors = 6
+
+// neighbors approximates the zip codes sharing a border with zipCode by
+// its nearest centroids.
+func neighbors(zips map[uint64]coordinate, zipCode uint64) []uint64 {
+	type candidate struct {
+		zipCode  uint64
+		distance float64
+	}
+
+	center := zips[zipCode]
+	var candidates []candidate
+	for other, c := range zips {
+		if other != zipCode {
+			candidates = append(candidates, candidate{other, distance(center, c)})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+	if len(candidates) > adjacentNeighbors {
+		candidates = candidates[:adjacentNeighbors]
+	}
+
+	zipCodes := make([]uint64, len(candidates))
+	for i, c := range candidates {
+		zipCodes[i] = c.zipCode
+	}
+	return zipCodes
+}
+
+func filterByAdjacent(arg string) (FilterFn, error) {
+	splitted := strings.Split(arg, ":")
+	if len(splitted) > 2 {
+		return nil, fmt.Errorf("Adjacent expects <zip_code>[:<depth>], got %s", arg)
+	}
+
+	zipCode, err := strconv.ParseUint(splitted[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	depth := uint64(1)
+	if len(splitted) == 2 {
+		if depth, err = strconv.ParseUint(splitted[1], 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := zipCoordinate(zipCode); err != nil {
+		return nil, err
+	}
+
+	zips, _ := loadZips()
+	matched := map[uint64]bool{zipCode: true}
+	ring := []uint64{zipCode}
+	for i := uint64(0); i < depth && len(ring) > 0; i++ {
+		var next []uint64
+		for _, z := range ring {
+			for _, neighbor := range neighbors(zips, z) {
+				if !matched[neighbor] {
+					matched[neighbor] = true
+					next = append(next, neighbor)
+				}
+			}
+		}
+		ring = next
+	}
+
+	return FilterFn(func(d *Data) bool {
+		return matched[d.ZipCode]
+	}), nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testZips are the centroids of a few zip codes, rounded from the census
+// ZCTA gazetteer.
+const testZips = `zip,lat,lng
+94110,37.7500,-122.4150
+94103,37.7725,-122.4105
+94301,37.4439,-122.1500
+90012,34.0614,-118.2385
+10001,40.7506,-73.9972
+`
+
+// setZips makes loadZips read table like $ZHIQUERY_ZIPS, for the rest of
+// the test.
+func setZips(t *testing.T, table string) {
+	p := filepath.Join(t.TempDir(), "zips.csv")
+	if err := ioutil.WriteFile(p, []byte(table), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ZHIQUERY_ZIPS", p)
+	reset := func() { zipsOnce, zips, zipsErr = sync.Once{}, nil, nil }
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestZipCoordinate(t *testing.T) {
+	setZips(t, testZips)
+
+	c, err := zipCoordinate(94110)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(c.lat-37.75) > 1e-9 || math.Abs(c.lng+122.415) > 1e-9 {
+		t.Errorf("zipCoordinate(94110) = %+v, want 37.75, -122.415", c)
+	}
+	if _, err := zipCoordinate(99999); err == nil {
+		t.Error("zipCoordinate(99999) succeeded, want an error")
+	}
+}
+
+func TestLoadZipsEmpty(t *testing.T) {
+	setZips(t, "zip,lat,lng\n")
+
+	_, err := loadZips()
+	if err == nil || !strings.Contains(err.Error(), "$ZHIQUERY_ZIPS") {
+		t.Errorf("loadZips() = %v, want an error naming $ZHIQUERY_ZIPS", err)
+	}
+	if exitCode(err) != exitUsage {
+		t.Errorf("loadZips() exits with %d, want %d", exitCode(err), exitUsage)
+	}
+}
+
+func TestWriteGeoJSON(t *testing.T) {
+	setZips(t, testZips)
+
+	columns, err := parseFields("ZipCode,State", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	datas := []Data{{ZipCode: 94110, State: "CA"}, {ZipCode: 99999, State: "CA"}}
+	if err := writeGeoJSON(&out, datas, options{columns: columns}); err != nil {
+		t.Fatal(err)
+	}
+	var collection struct {
+		Features []struct {
+			Geometry struct {
+				Coordinates [2]float64 `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal([]byte(out.String()), &collection); err != nil {
+		t.Fatal(err)
+	}
+	// GeoJSON writes longitudes first, the zip code without a centroid is
+	// skipped
+	if len(collection.Features) != 1 || collection.Features[0].Geometry.Coordinates != [2]float64{-122.415, 37.75} {
+		t.Errorf("writeGeoJSON = %s, want 94110 at -122.415, 37.75", out.String())
+	}
+}
+
+func TestDistance(t *testing.T) {
+	sf, la := coordinate{37.7749, -122.4194}, coordinate{34.0522, -118.2437}
+	// 559 km by the haversine formula on a sphere of the mean radius
+	if got := distance(sf, la); math.Abs(got-347.4) > 0.5 {
+		t.Errorf("distance(San Francisco, Los Angeles) = %.1f miles, want 347.4", got)
+	}
+	if got := distance(la, sf); math.Abs(got-distance(sf, la)) > 1e-9 {
+		t.Errorf("distance isn't symmetric: %v and %v", got, distance(sf, la))
+	}
+	if got := distance(sf, sf); got != 0 {
+		t.Errorf("distance(San Francisco, San Francisco) = %v, want 0", got)
+	}
+}
+
+func TestParseDistance(t *testing.T) {
+	tests := []struct {
+		s     string
+		miles float64
+	}{
+		{"25", 25},
+		{"25mi", 25},
+		{"40km", 40 / kmPerMile},
+		{"0.5", 0.5},
+	}
+	for _, tt := range tests {
+		miles, err := parseDistance(tt.s)
+		if err != nil || math.Abs(miles-tt.miles) > 1e-9 {
+			t.Errorf("parseDistance(%s) = %v, %v, want %v", tt.s, miles, err, tt.miles)
+		}
+	}
+	if _, err := parseDistance("far"); err == nil {
+		t.Error("parseDistance(far) succeeded, want an error")
+	}
+}
+
+func TestFilterByNear(t *testing.T) {
+	setZips(t, testZips)
+
+	near, err := filterByNear("94110:5mi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 94301 is 25 miles away, 90012 and 10001 hundreds
+	for zipCode, want := range map[uint64]bool{94110: true, 94103: true, 94301: false, 90012: false, 10001: false, 99999: false} {
+		if got := near(&Data{ZipCode: zipCode}); got != want {
+			t.Errorf("Near:94110:5mi matches %d = %v, want %v", zipCode, got, want)
+		}
+	}
+	if near, err = filterByNear("94110:50km"); err != nil || !near(&Data{ZipCode: 94301}) {
+		t.Errorf("Near:94110:50km doesn't match 94301, error %v", err)
+	}
+
+	for _, arg := range []string{"94110", "94110:far", "99999:5mi"} {
+		if _, err := filterByNear(arg); err == nil {
+			t.Errorf("filterByNear(%s) succeeded, want an error", arg)
+		}
+	}
+}
+
+func TestFilterByBBox(t *testing.T) {
+	setZips(t, testZips)
+
+	// the Bay Area down to Palo Alto
+	bbox, err := filterByBBox("37.2,-122.6,38.0,-121.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for zipCode, want := range map[uint64]bool{94110: true, 94103: true, 94301: true, 90012: false, 10001: false, 99999: false} {
+		if got := bbox(&Data{ZipCode: zipCode}); got != want {
+			t.Errorf("BBox:37.2,-122.6,38.0,-121.7 matches %d = %v, want %v", zipCode, got, want)
+		}
+	}
+
+	for _, arg := range []string{"37.2,-122.6,38.0", "38.0,-122.6,37.2,-121.7", "37.2,west,38.0,-121.7"} {
+		if _, err := filterByBBox(arg); err == nil {
+			t.Errorf("filterByBBox(%s) succeeded, want an error", arg)
+		}
+	}
+}
+
+func TestFilterByBBoxWithoutZips(t *testing.T) {
+	setZips(t, "zip,lat,lng\n")
+
+	if _, err := filterByBBox("37.2,-122.6,38.0,-121.7"); err == nil || !strings.Contains(err.Error(), "$ZHIQUERY_ZIPS") {
+		t.Errorf("filterByBBox without a zip table = %v, want an error naming $ZHIQUERY_ZIPS", err)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:generate go run gen_boundaries.go
+
+// embeddedBoundaries holds the boundary polygons of counties and zip codes,
+// see gen_boundaries.go. Each row has the kind of region, its GEOID, the
+// county FIPS code or the zip code, and its rings as KML coordinates, lng,lat
+// pairs separated by spaces, with rings separated by semicolons. It's empty
+// unless go generate filled it before building.
+//
+//go:embed geodata/boundaries.csv
+var embeddedBoundaries string
+
+// polygon is the rings of a region, as lng,lat coordinates.
+type polygon [][]coordinate
+
+var (
+	boundariesOnce sync.Once
+	boundaries     map[string]polygon
+	boundariesErr  error
+)
+
+// loadBoundaries returns the boundaries by kind and GEOID, e.g. county:06075
+// or zip:94110, read from $ZHIQUERY_BOUNDARIES when set or from the embedded
+// table otherwise. An empty table is an error like an empty zip table, a map
+// of dots isn't the map asked for.
+func loadBoundaries() (map[string]polygon, error) {
+	boundariesOnce.Do(func() {
+		table, source := embeddedBoundaries, "the embedded boundary table, which go generate fills,"
+		if p := os.Getenv("ZHIQUERY_BOUNDARIES"); p != "" {
+			b, err := ioutil.ReadFile(p)
+			if err != nil {
+				boundariesErr = err
+				return
+			}
+			table, source = string(b), p
+		}
+
+		boundaries, boundariesErr = parseBoundaries(strings.NewReader(table))
+		if boundariesErr == nil && len(boundaries) == 0 {
+			boundariesErr = usageError(fmt.Errorf("Couldn't find any boundary in %s, set $ZHIQUERY_BOUNDARIES to a Kind,GEOID,Rings csv, e.g. the geodata/boundaries.csv of go generate", source))
+		}
+	})
+
+	return boundaries, boundariesErr
+}
+
+func parseBoundaries(r io.Reader) (map[string]polygon, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 3
+
+	// ignore header
+	if _, err := cr.Read(); err != nil {
+		return nil, fmt.Errorf("Invalid boundary table: %v", err)
+	}
+
+	table := map[string]polygon{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return table, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("Invalid boundary table: %v", err)
+		}
+
+		var p polygon
+		for _, ring := range strings.Split(record[2], ";") {
+			var points []coordinate
+			for _, point := range strings.Fields(ring) {
+				lng, lat, ok := strings.Cut(point, ",")
+				x, err := strconv.ParseFloat(lng, 64)
+				if err != nil || !ok {
+					return nil, fmt.Errorf("Invalid boundary table: bad point %s of %s", point, record[1])
+				}
+				y, err := strconv.ParseFloat(lat, 64)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid boundary table: bad point %s of %s", point, record[1])
+				}
+				points = append(points, coordinate{y, x})
+			}
+			if len(points) >= 3 {
+				p = append(p, points)
+			}
+		}
+		table[record[0]+":"+record[1]] = p
+	}
+}
+
+const (
+	heatmapWidth  = 960
+	heatmapMargin = 40
+	// heatmapLegend is the height below the map holding the color scale.
+	heatmapLegend = 60
+	// heatmapDot is the radius of the regions drawn at their centroid, for
+	// want of a boundary.
+	heatmapDot = 6
+)
+
+// heatmapStops are the colors of the lowest, middle, and highest growth
+// rates, red to green like the colors of the text output.
+var heatmapStops = [3][3]float64{
+	{0xd7, 0x30, 0x27},
+	{0xfe, 0xe0, 0x8b},
+	{0x1a, 0x98, 0x50},
+}
+
+// heatmapColor interpolates the color of t, from 0 for the lowest rate to 1
+// for the highest.
+func heatmapColor(t float64) string {
+	if math.IsNaN(t) {
+		t = 0.5
+	}
+	t = math.Max(0, math.Min(1, t))
+	from, to, f := heatmapStops[0], heatmapStops[1], t*2
+	if t > 0.5 {
+		from, to, f = heatmapStops[1], heatmapStops[2], t*2-1
+	}
+
+	var rgb [3]int
+	for i := range rgb {
+		rgb[i] = int(math.Round(from[i] + (to[i]-from[i])*f))
+	}
+	return fmt.Sprintf("#%02x%02x%02x", rgb[0], rgb[1], rgb[2])
+}
+
+// heatmapRegion is a county or zip code of a heatmap, with the mean growth
+// rate of its rows.
+type heatmapRegion struct {
+	name     string
+	boundary polygon
+	// centroids of the zip codes of the region, drawn when it has no
+	// boundary.
+	centroids  []coordinate
+	zipCodes   map[uint64]bool
+	growthRate average
+}
+
+// heatmapRegions groups datas by county or zip code, keeping the regions
+// with a boundary or a zip code centroid.
+func heatmapRegions(datas []Data, by string) ([]*heatmapRegion, int, error) {
+	zips, err := loadZips()
+	if err != nil {
+		return nil, 0, err
+	}
+	shapes, err := loadBoundaries()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	byKey := map[string]*heatmapRegion{}
+	var regions []*heatmapRegion
+	for i := range datas {
+		d := &datas[i]
+		var key, geoid, name string
+		if by == "county" {
+			county := countyKey(d.State, d.County)
+			key, geoid, name = "county:"+county[0]+","+county[1], d.Fips, d.County
+			if d.Fips != "" {
+				key = "county:" + d.Fips
+			}
+		} else {
+			geoid = fmt.Sprintf("%05d", d.ZipCode)
+			key, name = "zip:"+geoid, geoid+" "+d.City
+		}
+
+		r, ok := byKey[key]
+		if !ok {
+			r = &heatmapRegion{name: name, zipCodes: map[uint64]bool{}}
+			if geoid != "" {
+				r.boundary = shapes[by+":"+geoid]
+			}
+			byKey[key] = r
+			regions = append(regions, r)
+		}
+		// the rows of a zip code in several datasets have one centroid
+		if c, ok := zips[d.ZipCode]; ok && !r.zipCodes[d.ZipCode] {
+			r.zipCodes[d.ZipCode] = true
+			r.centroids = append(r.centroids, c)
+		}
+		r.growthRate.add(d.GrowthRate)
+	}
+
+	kept, skipped := regions[:0], 0
+	for _, r := range regions {
+		if len(r.boundary) == 0 && len(r.centroids) == 0 {
+			skipped++
+			continue
+		}
+		kept = append(kept, r)
+	}
+	return kept, skipped, nil
+}
+
+// writeHeatmapSVG draws a choropleth of the growth rates of datas, which
+// must all be in one state, by county or zip code. Regions without a
+// boundary in the boundary table are drawn as dots at their centroid.
+func writeHeatmapSVG(w io.Writer, datas []Data, opts options) error {
+	states := map[string]bool{}
+	for i := range datas {
+		states[normalizeState(datas[i].State)] = true
+	}
+	if len(states) != 1 {
+		return fmt.Errorf("--format heatmap-svg maps a single state, got %d, select one with State", len(states))
+	}
+	var state string
+	for state = range states {
+	}
+
+	regions, skipped, err := heatmapRegions(datas, opts.heatmapBy)
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "Skipped %d regions without a boundary or coordinates\n", skipped)
+	}
+	if len(regions) == 0 {
+		return fmt.Errorf("Couldn't find a boundary or coordinates for any of the %d matches", len(datas))
+	}
+
+	minLat, maxLat, minLng, maxLng := math.Inf(1), math.Inf(-1), math.Inf(1), math.Inf(-1)
+	low, high := math.Inf(1), math.Inf(-1)
+	extend := func(c coordinate) {
+		minLat, maxLat = math.Min(minLat, c.lat), math.Max(maxLat, c.lat)
+		minLng, maxLng = math.Min(minLng, c.lng), math.Max(maxLng, c.lng)
+	}
+	for _, r := range regions {
+		for _, ring := range r.boundary {
+			for _, c := range ring {
+				extend(c)
+			}
+		}
+		for _, c := range r.centroids {
+			extend(c)
+		}
+		if v := r.growthRate.value(); !math.IsNaN(v) {
+			low, high = math.Min(low, v), math.Max(high, v)
+		}
+	}
+
+	// equirectangular projection, with longitudes shrunk at the latitude of
+	// the state so that its shape isn't stretched
+	aspect := math.Cos((minLat + maxLat) / 2 * math.Pi / 180)
+	spanX := math.Max((maxLng-minLng)*aspect, 1e-6)
+	spanY := math.Max(maxLat-minLat, 1e-6)
+	scale := float64(heatmapWidth-2*heatmapMargin) / spanX
+	mapHeight := math.Ceil(spanY * scale)
+	height := int(mapHeight) + 2*heatmapMargin + heatmapLegend
+	project := func(c coordinate) (float64, float64) {
+		return heatmapMargin + (c.lng-minLng)*aspect*scale, heatmapMargin + (maxLat-c.lat)*scale
+	}
+	shade := func(v float64) string {
+		if high == low {
+			return heatmapColor(0.5)
+		}
+		return heatmapColor((v - low) / (high - low))
+	}
+
+	fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\" font-family=\"sans-serif\" font-size=\"12\">\n", heatmapWidth, height, heatmapWidth, height)
+	fmt.Fprintf(w, "<rect width=\"%d\" height=\"%d\" fill=\"white\"/>\n", heatmapWidth, height)
+	fmt.Fprintf(w, "<text x=\"%d\" y=\"%d\" font-size=\"16\">%s GrowthRate by %s</text>\n", heatmapMargin, heatmapMargin-16, html.EscapeString(state), html.EscapeString(opts.heatmapBy))
+
+	// dots go over the polygons so that they aren't hidden by them
+	sort.SliceStable(regions, func(i, j int) bool { return len(regions[i].boundary) > len(regions[j].boundary) })
+	for _, r := range regions {
+		v := r.growthRate.value()
+		tooltip := html.EscapeString(fmt.Sprintf("%s: %.2f%%", r.name, v))
+		if len(r.boundary) > 0 {
+			var d strings.Builder
+			for _, ring := range r.boundary {
+				for i, c := range ring {
+					x, y := project(c)
+					op := "L"
+					if i == 0 {
+						op = "M"
+					}
+					fmt.Fprintf(&d, "%s%.1f %.1f", op, x, y)
+				}
+				d.WriteString("Z")
+			}
+			fmt.Fprintf(w, "<path d=\"%s\" fill=\"%s\" fill-rule=\"evenodd\" stroke=\"white\" stroke-width=\"0.5\"><title>%s</title></path>\n", d.String(), shade(v), tooltip)
+			continue
+		}
+		for _, c := range r.centroids {
+			x, y := project(c)
+			fmt.Fprintf(w, "<circle cx=\"%.1f\" cy=\"%.1f\" r=\"%d\" fill=\"%s\" stroke=\"#333\" stroke-width=\"0.5\"><title>%s</title></circle>\n", x, y, heatmapDot, shade(v), tooltip)
+		}
+	}
+
+	// color scale from the lowest to the highest rate
+	const steps = 20
+	top := heatmapMargin + int(mapHeight) + 20
+	for i := 0; i < steps; i++ {
+		fmt.Fprintf(w, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"12\" fill=\"%s\"/>\n", heatmapMargin+i*15, top, 15, heatmapColor(float64(i)/(steps-1)))
+	}
+	if !math.IsInf(low, 0) {
+		fmt.Fprintf(w, "<text x=\"%d\" y=\"%d\">%.2f%%</text>\n", heatmapMargin, top+28, low)
+		fmt.Fprintf(w, "<text x=\"%d\" y=\"%d\" text-anchor=\"end\">%.2f%%</text>\n", heatmapMargin+steps*15, top+28, high)
+	}
+	_, err = fmt.Fprintln(w, "</svg>")
+	return err
+}
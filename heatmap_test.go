@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// setBoundaries makes loadBoundaries read table like $ZHIQUERY_BOUNDARIES,
+// for the rest of the test.
+func setBoundaries(t *testing.T, table string) {
+	p := filepath.Join(t.TempDir(), "boundaries.csv")
+	if err := ioutil.WriteFile(p, []byte(table), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ZHIQUERY_BOUNDARIES", p)
+	reset := func() { boundariesOnce, boundaries, boundariesErr = sync.Once{}, nil, nil }
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestWriteHeatmapSVG(t *testing.T) {
+	setZips(t, testZips)
+	// a square around 94110, 94103 has only its centroid
+	setBoundaries(t, "Kind,GEOID,Rings\nzip,94110,\"-122.43,37.74 -122.40,37.74 -122.40,37.76 -122.43,37.76 -122.43,37.74\"\n")
+
+	datas := []Data{{ZipCode: 94110, State: "CA", GrowthRate: 3}, {ZipCode: 94103, State: "CA", GrowthRate: 5}}
+	var out strings.Builder
+	if err := writeHeatmapSVG(&out, datas, options{heatmapBy: "zip"}); err != nil {
+		t.Fatal(err)
+	}
+	svg := out.String()
+	if n := strings.Count(svg, "<path "); n != 1 || !strings.Contains(svg, "<title>94110 : 3.00%</title></path>") {
+		t.Errorf("writeHeatmapSVG drew %d paths, want the boundary of 94110:\n%s", n, svg)
+	}
+	if n := strings.Count(svg, "<circle "); n != 1 || !strings.Contains(svg, "<title>94103 : 5.00%</title></circle>") {
+		t.Errorf("writeHeatmapSVG drew %d dots, want the centroid of 94103:\n%s", n, svg)
+	}
+
+	datas = append(datas, Data{ZipCode: 10001, State: "NY"})
+	if err := writeHeatmapSVG(&out, datas, options{heatmapBy: "zip"}); err == nil {
+		t.Error("writeHeatmapSVG mapped two states, want an error")
+	}
+}
+
+func TestLoadBoundariesEmpty(t *testing.T) {
+	setBoundaries(t, "Kind,GEOID,Rings\n")
+
+	_, err := loadBoundaries()
+	if err == nil || !strings.Contains(err.Error(), "$ZHIQUERY_BOUNDARIES") {
+		t.Errorf("loadBoundaries() = %v, want an error naming $ZHIQUERY_BOUNDARIES", err)
+	}
+	if exitCode(err) != exitUsage {
+		t.Errorf("loadBoundaries() exits with %d, want %d", exitCode(err), exitUsage)
+	}
+}
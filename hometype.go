@@ -0,0 +1,113 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// homeTypes names the home types of Zillow's series by the field names of
+// --by-home-type. Datasets naming no home type cover all homes, like
+// Zillow's sfrcondo series.
+var homeTypes = []struct {
+	name  string
+	field string
+}{
+	{"all", "AllHomes"},
+	{"sfr", "SFR"},
+	{"condo", "Condo"},
+	{"new-construction", "NewConstruction"},
+}
+
+// homeTypeOf returns the home type of a dataset by the words of its name,
+// e.g. condo for Zip_zhvi_uc_condo_tier_0.33_0.67_sm_sa_month.csv, and its
+// family, the name without the home type, which is the same for the series
+// of each home type of an export.
+func homeTypeOf(dataset string) (homeType, family string) {
+	name := strings.ToLower(strings.TrimSuffix(dataset, path.Ext(dataset)))
+	name = strings.TrimSuffix(name, ".csv")
+	words := strings.FieldsFunc(name, func(r rune) bool { return r == '_' || r == '-' || r == ' ' })
+
+	homeType = "all"
+	kept := words[:0:0]
+	for i := 0; i < len(words); i++ {
+		switch {
+		case words[i] == "sfrcondo":
+		case words[i] == "sfr":
+			homeType = "sfr"
+		case words[i] == "condo":
+			homeType = "condo"
+		case words[i] == "newcon" || words[i] == "newconstruction":
+			homeType = "new-construction"
+		case words[i] == "new" && i+1 < len(words) && (words[i+1] == "con" || words[i+1] == "construction"):
+			homeType = "new-construction"
+			i++
+		default:
+			kept = append(kept, words[i])
+		}
+	}
+	return homeType, strings.Join(kept, "_")
+}
+
+func filterByHomeType(homeType string) FilterFn {
+	homeType = foldText(homeType)
+	return FilterFn(func(d *Data) bool {
+		return d.HomeType == homeType
+	})
+}
+
+// homeTypeJoins adds the fields of --by-home-type to v, e.g. Condo,
+// CondoGrowthRate, and CondoYoY, and returns a join per home type.
+func homeTypeJoins(v *vocabulary) ([]join, error) {
+	for _, t := range homeTypes {
+		if err := registerJoinFields(t.field, v); err != nil {
+			return nil, err
+		}
+	}
+
+	joins := make([]join, len(homeTypes))
+	for i, t := range homeTypes {
+		joins[i] = join{name: t.field}
+	}
+	return joins, nil
+}
+
+// byHomeType merges the rows of each zip code in the series of every home
+// type of an export into one row, whose Joined fields hold the row of each
+// home type. The row kept is the all homes one, or the first in datas.
+func byHomeType(datas []Data) []Data {
+	type key struct {
+		repository string
+		family     string
+		zipCode    uint64
+	}
+	fields := map[string]string{}
+	for _, t := range homeTypes {
+		fields[t.name] = t.field
+	}
+
+	kept := map[key]int{}
+	var merged []Data
+	for i := range datas {
+		d := datas[i]
+		_, family := homeTypeOf(d.Dataset)
+		k := key{d.Repository, family, d.ZipCode}
+		j, ok := kept[k]
+		if !ok {
+			kept[k] = len(merged)
+			merged = append(merged, d)
+			j = len(merged) - 1
+		} else if d.HomeType == "all" && merged[j].HomeType != "all" {
+			d.Joined = merged[j].Joined
+			merged[j] = d
+		}
+
+		row := &merged[j]
+		joined := make(map[string]*Data, len(row.Joined)+1)
+		for name, other := range row.Joined {
+			joined[name] = other
+		}
+		joined[fields[datas[i].HomeType]] = &datas[i]
+		row.Joined = joined
+	}
+	return merged
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// incomeTable maps a zip code to its median household income.
+type incomeTable map[uint64]float64
+
+// acsIncome is the median household income column of the American
+// Community Survey table B19013 as exported by data.census.gov.
+const acsIncome = "B19013_001E"
+
+// loadIncome reads a ZipCode,Income csv, or a B19013 export of
+// data.census.gov whose NAME column is like ZCTA5 94110. Zip codes without
+// an estimate, like - or 250,000+ in the census exports, are skipped.
+func loadIncome(p string) (incomeTable, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cr := csv.NewReader(f)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid income table %s: %v", p, err)
+	}
+
+	zipColumn, incomeColumn := 0, 1
+	for i, column := range header {
+		switch strings.TrimSpace(column) {
+		case "NAME":
+			zipColumn = i
+		case acsIncome:
+			incomeColumn = i
+		}
+	}
+
+	table := incomeTable{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Invalid income table %s: %v", p, err)
+		}
+		if len(record) <= zipColumn || len(record) <= incomeColumn {
+			continue
+		}
+
+		// census exports prefix the zip code, e.g. ZCTA5 94110
+		fields := strings.Fields(record[zipColumn])
+		if len(fields) == 0 {
+			continue
+		}
+		zipCode, err := strconv.ParseUint(fields[len(fields)-1], 10, 64)
+		if err != nil {
+			continue
+		}
+		income, err := strconv.ParseFloat(strings.TrimSpace(record[incomeColumn]), 64)
+		if err != nil || income <= 0 {
+			continue
+		}
+		table[zipCode] = income
+	}
+
+	if len(table) == 0 {
+		return nil, fmt.Errorf("Empty income table %s", p)
+	}
+	return table, nil
+}
+
+// affordability is the price to income ratio of d, NaN without an income so
+// that comparisons don't match zip codes missing from the income table.
+func affordability(d *Data) float64 {
+	if d.Income == 0 {
+		return math.NaN()
+	}
+	return d.Affordability
+}
+
+// setIncome sets the income and price to income ratio of d from t.
+func (t incomeTable) setIncome(d *Data) {
+	if t == nil {
+		return
+	}
+
+	d.Income = t[d.ZipCode]
+	if d.Income > 0 {
+		d.Affordability = d.Price() / d.Income
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+)
+
+// inspection is the schema and coverage of a dataset file.
+type inspection struct {
+	regionTypes map[string]int
+	months      []string
+	rows        int
+	states      map[string]int
+	values      int
+	missing     int
+	// missingByMonth counts the rows without a value per month.
+	missingByMonth []int
+}
+
+func inspect(p string) (*inspection, error) {
+	f, err := openDataset(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s is empty", p)
+	}
+
+	header := strings.Split(scanner.Text(), ",")
+	l, err := datasetLayout(path.Dir(p), path.Base(p), header)
+	if err != nil {
+		return nil, err
+	}
+	regionType := -1
+	for i, column := range header[:min(l.months, len(header))] {
+		if strings.TrimSpace(column) == "RegionType" {
+			regionType = i
+		}
+	}
+
+	in := &inspection{
+		regionTypes: map[string]int{},
+		months:      parseMonths(header, l),
+		states:      map[string]int{},
+	}
+	in.missingByMonth = make([]int, len(in.months))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ",")
+		in.rows++
+
+		if regionType >= 0 && regionType < len(fields) {
+			in.regionTypes[fields[regionType]]++
+		}
+		if l.state < len(fields) {
+			in.states[normalizeState(fields[l.state])]++
+		}
+		for i := range in.months {
+			in.values++
+			if l.months+i >= len(fields) || strings.TrimSpace(fields[l.months+i]) == "" {
+				in.missing++
+				in.missingByMonth[i]++
+			}
+		}
+	}
+	return in, scanner.Err()
+}
+
+// counted lists the keys of counts by descending count.
+func counted(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+func percentOf(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+func inspectCmd(args []string) {
+	if len(args) != 1 {
+		usage("inspect")
+		os.Exit(exitUsage)
+	}
+
+	in, err := inspect(args[0])
+	must(err)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	var regionTypes []string
+	for _, regionType := range counted(in.regionTypes) {
+		regionTypes = append(regionTypes, fmt.Sprintf("%s (%s)", regionType, humanize.Comma(int64(in.regionTypes[regionType]))))
+	}
+	if len(regionTypes) == 0 {
+		regionTypes = []string{"unknown, no RegionType column"}
+	}
+	fmt.Fprintf(w, "Region Type:\t%s\n", strings.Join(regionTypes, ", "))
+
+	if len(in.months) > 0 {
+		fmt.Fprintf(w, "Months:\t%s to %s (%d)\n", in.months[0], in.months[len(in.months)-1], len(in.months))
+	} else {
+		fmt.Fprintf(w, "Months:\tnone\n")
+	}
+	fmt.Fprintf(w, "Rows:\t%s\n", humanize.Comma(int64(in.rows)))
+
+	var states []string
+	for _, state := range counted(in.states) {
+		states = append(states, fmt.Sprintf("%s (%d)", state, in.states[state]))
+	}
+	fmt.Fprintf(w, "States:\t%d: %s\n", len(in.states), strings.Join(states, ", "))
+	fmt.Fprintf(w, "Missing Values:\t%s\n", formatPercent(percentOf(in.missing, in.values)))
+
+	// The first and last months are where exports are usually sparse
+	if len(in.months) > 0 {
+		first, last := 0, len(in.months)-1
+		fmt.Fprintf(w, "Missing in %s:\t%s\n", in.months[first], formatPercent(percentOf(in.missingByMonth[first], in.rows)))
+		fmt.Fprintf(w, "Missing in %s:\t%s\n", in.months[last], formatPercent(percentOf(in.missingByMonth[last], in.rows)))
+	}
+	must(w.Flush())
+}
@@ -0,0 +1,69 @@
+package main
+
+import "fmt"
+
+// interpolations are the methods of --interpolate filling the missing
+// months of a price history, which are 0 once parsed.
+var interpolations = map[string]func(vs []float64){
+	"none":   func(vs []float64) {},
+	"ffill":  forwardFill,
+	"linear": linearFill,
+}
+
+func validateInterpolation(method string) error {
+	if _, ok := interpolations[method]; !ok {
+		return fmt.Errorf("Couldn't find interpolation %s, expected linear, ffill, or none", method)
+	}
+	return nil
+}
+
+// missingPct is the share of the months of vs without a value, in percent.
+func missingPct(vs []float64) float64 {
+	if len(vs) == 0 {
+		return 0
+	}
+	missing := 0
+	for _, v := range vs {
+		if v == 0 {
+			missing++
+		}
+	}
+	return float64(missing) / float64(len(vs)) * 100
+}
+
+// forwardFill fills every missing month after the first value of vs with
+// the last value before it. The months before the first value stay missing,
+// as the history starts there.
+func forwardFill(vs []float64) {
+	last := 0.0
+	for i, v := range vs {
+		if v == 0 {
+			vs[i] = last
+		} else {
+			last = v
+		}
+	}
+}
+
+// linearFill fills the gaps between two values of vs along the line between
+// them, and the missing months after the last value with it.
+func linearFill(vs []float64) {
+	prev := -1
+	for i, v := range vs {
+		if v == 0 {
+			continue
+		}
+		if prev >= 0 && i-prev > 1 {
+			step := (v - vs[prev]) / float64(i-prev)
+			for j := prev + 1; j < i; j++ {
+				vs[j] = vs[prev] + step*float64(j-prev)
+			}
+		}
+		prev = i
+	}
+	if prev >= 0 {
+		for j := prev + 1; j < len(vs); j++ {
+			vs[j] = vs[prev]
+		}
+	}
+}
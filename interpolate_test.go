@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInterpolations(t *testing.T) {
+	tests := []struct {
+		name   string
+		vs     []float64
+		ffill  []float64
+		linear []float64
+	}{
+		{"empty", []float64{}, []float64{}, []float64{}},
+		{"all missing", []float64{0, 0}, []float64{0, 0}, []float64{0, 0}},
+		{"no gaps", []float64{1, 2, 3}, []float64{1, 2, 3}, []float64{1, 2, 3}},
+		{"leading gap stays missing", []float64{0, 0, 5, 6}, []float64{0, 0, 5, 6}, []float64{0, 0, 5, 6}},
+		{"interior gap", []float64{100, 0, 0, 130}, []float64{100, 100, 100, 130}, []float64{100, 110, 120, 130}},
+		{"trailing gap", []float64{100, 110, 0, 0}, []float64{100, 110, 110, 110}, []float64{100, 110, 110, 110}},
+		{"several gaps", []float64{0, 10, 0, 30, 0, 0, 60, 0}, []float64{0, 10, 10, 30, 30, 30, 60, 60}, []float64{0, 10, 20, 30, 40, 50, 60, 60}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, method := range []struct {
+				name string
+				want []float64
+			}{{"ffill", tt.ffill}, {"linear", tt.linear}, {"none", tt.vs}} {
+				got := append([]float64{}, tt.vs...)
+				interpolations[method.name](got)
+				if !reflect.DeepEqual(got, method.want) {
+					t.Errorf("%s(%v) = %v, want %v", method.name, tt.vs, got, method.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMissingPct(t *testing.T) {
+	tests := []struct {
+		vs   []float64
+		want float64
+	}{
+		{nil, 0},
+		{[]float64{1, 2, 3, 4}, 0},
+		{[]float64{0, 2, 0, 4}, 50},
+		{[]float64{0, 0, 0, 0}, 100},
+	}
+	for _, tt := range tests {
+		if got := missingPct(tt.vs); got != tt.want {
+			t.Errorf("missingPct(%v) = %v, want %v", tt.vs, got, tt.want)
+		}
+	}
+}
+
+func TestValidateInterpolation(t *testing.T) {
+	for _, method := range []string{"linear", "ffill", "none"} {
+		if err := validateInterpolation(method); err != nil {
+			t.Errorf("validateInterpolation(%s) = %v", method, err)
+		}
+	}
+	if err := validateInterpolation("cubic"); err == nil {
+		t.Error("validateInterpolation(cubic) = nil, want an error")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// join adds the fields of the rows of dataset with the same zip code to
+// every row, under name.
+type join struct {
+	name    string
+	dataset string
+}
+
+// joinFields are the fields a join adds, by suffix of its name.
+var joinFields = []struct {
+	suffix string
+	value  func(d *Data) float64
+}{
+	{"", (*Data).Price},
+	{"GrowthRate", func(d *Data) float64 { return d.GrowthRate }},
+	{"YoY", func(d *Data) float64 { return d.YoY }},
+}
+
+// parseJoins parses --join <name>=<dataset> flags and adds the fields of
+// each join to v: <name> for the latest value of the joined dataset, and
+// <name>GrowthRate and <name>YoY, e.g. --join Rent=zori.csv adds Rent,
+// RentGrowthRate, and RentYoY. The fields are 0 for zip codes missing from
+// the joined dataset.
+func parseJoins(joins []string, v *vocabulary) ([]join, error) {
+	var parsed []join
+	for _, spec := range joins {
+		splitted := strings.SplitN(spec, "=", 2)
+		if len(splitted) != 2 || splitted[1] == "" {
+			return nil, fmt.Errorf("--join expects <name>=<dataset>, got %s", spec)
+		}
+
+		name := strings.TrimSpace(splitted[0])
+		if !isIdentifier(name) {
+			return nil, fmt.Errorf("Invalid field name %q", name)
+		}
+		if err := registerJoinFields(name, v); err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, join{name, splitted[1]})
+	}
+	return parsed, nil
+}
+
+// registerJoinFields adds the fields of the rows joined under name to v.
+func registerJoinFields(name string, v *vocabulary) error {
+	for _, field := range joinFields {
+		joinedValue := field.value
+		value := func(d *Data) float64 {
+			if joined := d.Joined[name]; joined != nil {
+				return joinedValue(joined)
+			}
+			return 0
+		}
+		if err := v.add(name+field.suffix, value, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isJoined(joins []join, dataset string) bool {
+	for _, j := range joins {
+		if j.dataset == dataset {
+			return true
+		}
+	}
+	return false
+}
+
+// indexJoined maps the rows of a joined dataset by zip code.
+func indexJoined(datas []Data) map[uint64]*Data {
+	index := make(map[uint64]*Data, len(datas))
+	for i := range datas {
+		// neighborhoods without a zip code
+		if datas[i].ZipCode == 0 {
+			continue
+		}
+		index[datas[i].ZipCode] = &datas[i]
+	}
+	return index
+}
+
+// join points d.Joined to the rows of the joined datasets with the zip code
+// of d.
+func (d *Data) join(joined map[string]map[uint64]*Data) {
+	if len(joined) == 0 {
+		return
+	}
+
+	d.Joined = make(map[string]*Data, len(joined))
+	for name, index := range joined {
+		if row, ok := index[d.ZipCode]; ok {
+			d.Joined[name] = row
+		}
+	}
+}
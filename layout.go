@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// layout locates the columns of a dataset by its header. Zillow exports
+// start with RegionID,SizeRank,RegionName,RegionType,StateName,State,City,
+// Metro,CountyName, and newer ones add StateCodeFIPS and MunicipalCodeFIPS,
+// followed by one column per month. RegionName is a neighborhood name
+// instead of a zip code in the rows whose RegionType is neighborhood.
+type layout struct {
+	regionID   int
+	sizeRank   int
+	zipCode    int
+	regionType int
+	state      int
+	city       int
+	metro      int
+	county     int
+	stateFips  int
+	countyFips int
+	// months is the first month column
+	months int
+	// pattern is the layout of the repositoryConfig the layout comes from,
+	// if any.
+	pattern string
+}
+
+func parseLayout(header []string) layout {
+	l := layout{regionID: 0, sizeRank: 1, zipCode: 2, regionType: -1, state: 5, city: 6, metro: 7, county: 8, stateFips: -1, countyFips: -1, months: 9}
+	index := map[string]*int{
+		"RegionID":          &l.regionID,
+		"SizeRank":          &l.sizeRank,
+		"RegionName":        &l.zipCode,
+		"RegionType":        &l.regionType,
+		"State":             &l.state,
+		"City":              &l.city,
+		"Metro":             &l.metro,
+		"CountyName":        &l.county,
+		"StateCodeFIPS":     &l.stateFips,
+		"MunicipalCodeFIPS": &l.countyFips,
+	}
+
+	for i, column := range header {
+		if len(column) >= 7 {
+			if _, err := time.Parse("2006-01", column[:7]); err == nil {
+				l.months = i
+				break
+			}
+		}
+		if p, ok := index[strings.TrimSpace(column)]; ok {
+			*p = i
+		}
+	}
+	return l
+}
+
+// fips returns the 5 digit county FIPS code of a row, or "" when the
+// dataset has none.
+func (l layout) fips(fields []string) string {
+	if l.stateFips < 0 || l.countyFips < 0 {
+		return ""
+	}
+
+	return normalizeFips(fields[l.stateFips], fields[l.countyFips])
+}
+
+// normalizeFips pads the state and county codes, which exports may write
+// without their leading zeros.
+func normalizeFips(state, county string) string {
+	var s, c uint64
+	if _, err := fmt.Sscan(state, &s); err != nil {
+		return ""
+	}
+	if _, err := fmt.Sscan(county, &c); err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%02d%03d", s, c)
+}
+
+// repositoryConfig is the optional file of a dataset_dir mapping the names
+// of its dataset files to their layout, for older exports and third party
+// files whose header parseLayout can't read, e.g.
+//
+//	{"layouts": [{"pattern": "zips_*.csv", "region": "zip", "state": 1, "city": 2, "county": 3, "months": 4}]}
+//
+// Columns are given by their header or their index from 0. The first
+// pattern matching a dataset wins, the columns it doesn't set are found
+// by parseLayout. Months are still written 2006-01 in the header.
+const repositoryConfig = ".zhiquery.json"
+
+type layoutConfig struct {
+	Pattern    string    `json:"pattern"`
+	Region     columnRef `json:"region"`
+	RegionType columnRef `json:"regionType"`
+	SizeRank   columnRef `json:"sizeRank"`
+	State      columnRef `json:"state"`
+	City       columnRef `json:"city"`
+	Metro      columnRef `json:"metro"`
+	County     columnRef `json:"county"`
+	Months     columnRef `json:"months"`
+}
+
+// columnRef is a column of a layoutConfig, by header or index.
+type columnRef struct {
+	name  string
+	index int
+	set   bool
+}
+
+func (c *columnRef) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &c.index); err == nil {
+		c.set = c.index >= 0
+		if !c.set {
+			return fmt.Errorf("Invalid column %s", b)
+		}
+		return nil
+	}
+	if err := json.Unmarshal(b, &c.name); err != nil {
+		return fmt.Errorf("Invalid column %s, expected a header or an index", b)
+	}
+	c.index, c.set = -1, true
+	return nil
+}
+
+// resolve returns the index of c in header.
+func (c columnRef) resolve(header []string) (int, error) {
+	if c.name == "" {
+		if c.index >= len(header) {
+			return 0, fmt.Errorf("Couldn't find column %d, the header has %d", c.index, len(header))
+		}
+		return c.index, nil
+	}
+	for i, column := range header {
+		if strings.TrimSpace(column) == c.name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("Couldn't find column %s", c.name)
+}
+
+var (
+	layoutConfigsMu sync.Mutex
+	layoutConfigs   = map[string][]layoutConfig{}
+)
+
+// loadLayoutConfigs reads the layouts of the repositoryConfig of
+// repository once per run, nil without one.
+func loadLayoutConfigs(repository string) ([]layoutConfig, error) {
+	layoutConfigsMu.Lock()
+	defer layoutConfigsMu.Unlock()
+	if configs, ok := layoutConfigs[repository]; ok {
+		return configs, nil
+	}
+
+	var configs []layoutConfig
+	if hasRepositoryConfig(repository) {
+		f, err := openRepositoryDataset(repository, repositoryConfig)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		var config struct {
+			Layouts []layoutConfig `json:"layouts"`
+		}
+		if err := json.NewDecoder(f).Decode(&config); err != nil {
+			return nil, fmt.Errorf("Invalid %s of %s: %v", repositoryConfig, repository, err)
+		}
+		for _, c := range config.Layouts {
+			if _, err := path.Match(c.Pattern, ""); err != nil || c.Pattern == "" {
+				return nil, fmt.Errorf("Invalid %s of %s: bad pattern %q", repositoryConfig, repository, c.Pattern)
+			}
+		}
+		configs = config.Layouts
+	}
+	layoutConfigs[repository] = configs
+	return configs, nil
+}
+
+// datasetLayout is the layout of dataset of repository, from the first
+// layout of the repositoryConfig matching its name, or parseLayout. The
+// snapshots of a repository without their own repositoryConfig share the
+// one at its root.
+func datasetLayout(repository, dataset string, header []string) (layout, error) {
+	l := parseLayout(header)
+	configs, err := loadLayoutConfigs(repository)
+	if configs == nil && err == nil && snapshotOf(repository) != "" {
+		configs, err = loadLayoutConfigs(snapshotRoot(repository))
+	}
+	if err != nil {
+		return l, err
+	}
+
+	for _, c := range configs {
+		if matched, _ := path.Match(c.Pattern, dataset); !matched {
+			continue
+		}
+
+		l.pattern = c.Pattern
+		for _, column := range []struct {
+			ref columnRef
+			p   *int
+		}{
+			{c.Region, &l.zipCode},
+			{c.RegionType, &l.regionType},
+			{c.SizeRank, &l.sizeRank},
+			{c.State, &l.state},
+			{c.City, &l.city},
+			{c.Metro, &l.metro},
+			{c.County, &l.county},
+			{c.Months, &l.months},
+		} {
+			if !column.ref.set {
+				continue
+			}
+			i, err := column.ref.resolve(header)
+			if err != nil {
+				return l, fmt.Errorf("Layout %s of %s: %v", c.Pattern, dataset, err)
+			}
+			*column.p = i
+		}
+		// rows are only checked for the columns up to the first month
+		for _, i := range []int{l.zipCode, l.state, l.city, l.county} {
+			if i >= l.months {
+				return l, fmt.Errorf("Layout %s of %s: column %d is past the first month column %d", c.Pattern, dataset, i, l.months)
+			}
+		}
+		break
+	}
+	return l, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// libraryMu serializes the queries of the library builds, as options.prepare
+// sets package variables like accelerationMonths.
+var libraryMu sync.Mutex
+
+// saveGlobals saves the package variables options.prepare sets, and returns
+// the function restoring them, so that flags like --acceleration-months
+// don't leak into the next query of the library builds.
+func saveGlobals() (restore func()) {
+	format, months, log := errorFormat, accelerationMonths, logger
+	return func() {
+		errorFormat, accelerationMonths, logger = format, months, log
+	}
+}
+
+// queryLibrary runs a query of the library builds, args being the arguments
+// of the query command, e.g. ["zhvi", "[", "State:CA", "]", "--sort", "YoY"],
+// and returns the matches as the json format prints them. The errors that
+// would exit the command line are returned instead.
+func queryLibrary(args []string) (out []byte, err error) {
+	libraryMu.Lock()
+	defer libraryMu.Unlock()
+	defer saveGlobals()()
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(embeddedExit)
+			if !ok {
+				panic(r)
+			}
+			out, err = nil, e.err
+		}
+	}()
+
+	var opts options
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Usage = func() {}
+	opts.register(fs)
+	if args, err = parseFlags(fs, args); err != nil {
+		return nil, err
+	}
+	repository := opts.repository("")
+	if repository == "" {
+		if len(args) == 0 {
+			return nil, usageError(fmt.Errorf("Couldn't find a dataset_dir, expected it before the query or with --data"))
+		}
+		repository, args = args[0], args[1:]
+	}
+	if err := opts.prepare(); err != nil {
+		return nil, usageError(err)
+	}
+
+	tokens := tokenize(args)
+	var tree *filterNode
+	if len(tokens) > 0 || opts.zipList == nil {
+		if tree, _, err = parseFilterTree(tokens, opts.vocabulary); err != nil {
+			return nil, &queryError{err, tokens}
+		}
+	}
+	datas, err := matchTree(repository, opts.restrict(tree), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	if err := writeJSON(&b, datas, opts); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// queryLibraryJSON is queryLibrary taking args as a json array, and
+// returning {"results": [...]} or the errorObject of its error, the one
+// entry point of the c-shared library and the WASM module.
+func queryLibraryJSON(argsJSON string) string {
+	var args []string
+	var body []byte
+	err := json.Unmarshal([]byte(argsJSON), &args)
+	if err == nil {
+		body, err = queryLibrary(args)
+	} else {
+		err = usageError(fmt.Errorf("Invalid arguments, expected a json array of strings: %v", err))
+	}
+	if err != nil {
+		b, _ := json.Marshal(newErrorObject(err))
+		return string(b)
+	}
+	b, _ := json.Marshal(map[string]json.RawMessage{"results": body})
+	return string(b)
+}
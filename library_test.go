@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testRepository writes a dataset_dir of a synthetic dataset.
+func testRepository(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "Zip_zhvi.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	spec := testdataSpec{rows: 50, months: 36, start: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), seed: 1}
+	if err := writeTestdata(f, spec); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestQueryLibraryTwice(t *testing.T) {
+	embedded = true
+	defer func() { embedded = false }()
+	repository := testRepository(t)
+
+	args := []string{repository, "[ Double:>-1000 ]", "--define", "Double=growth*2", "--sort", "Double", "--fields", "zip,Double", "--errors", "json", "--acceleration-months", "3"}
+	var first []byte
+	for i := 0; i < 2; i++ {
+		out, err := queryLibrary(args)
+		if err != nil {
+			t.Fatalf("query %d: %v", i+1, err)
+		}
+		var records []map[string]interface{}
+		if err := json.Unmarshal(out, &records); err != nil {
+			t.Fatalf("query %d: %v", i+1, err)
+		}
+		if len(records) == 0 {
+			t.Fatalf("query %d matched no zip code", i+1)
+		}
+		if i == 0 {
+			first = out
+		} else if string(out) != string(first) {
+			t.Errorf("query 2 = %s, want the results of query 1 %s", out, first)
+		}
+	}
+
+	if _, ok := lookupField("Double", nil); ok {
+		t.Error("Double is still a field after the queries")
+	}
+	if errorFormat != "text" || accelerationMonths != 6 {
+		t.Errorf("errorFormat, accelerationMonths = %s, %d after the queries, want text, 6", errorFormat, accelerationMonths)
+	}
+}
+
+func TestQueryLibraryErrors(t *testing.T) {
+	embedded = true
+	defer func() { embedded = false }()
+	repository := testRepository(t)
+
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{repository, "[ State:CA and ]"}, exitUsage},
+		{[]string{repository, "[ State:CA ]", "--bogus"}, exitUsage},
+		{[]string{filepath.Join(repository, "missing"), "[ State:CA ]"}, exitDataset},
+		{[]string{repository, "[ State:CA ]", "--snapshot", "2023-01"}, exitDataset},
+	}
+	for _, tt := range tests {
+		if _, err := queryLibrary(tt.args); err == nil || exitCode(err) != tt.code {
+			t.Errorf("queryLibrary(%q) = %v, want an error with code %d", tt.args, err, tt.code)
+		}
+	}
+}
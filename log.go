@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+var logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+func configureLogger(level slog.Level, format string) error {
+	handlerOptions := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "text":
+		logger = slog.New(slog.NewTextHandler(os.Stderr, handlerOptions))
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(os.Stderr, handlerOptions))
+	default:
+		return fmt.Errorf("Couldn't find log format %s", format)
+	}
+
+	return nil
+}
@@ -2,17 +2,21 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"log/slog"
 	"math"
+	"net/url"
 	"os"
 	"path"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
-
-	"github.com/dustin/go-humanize"
+	"text/template"
+	"time"
+	"unicode/utf8"
 )
 
 const (
@@ -22,23 +26,208 @@ const (
 
 func must(err error) {
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		exit(err)
 	}
 }
 
 type Data struct {
-	ZipCode    uint64
-	City       string
-	State      string
-	County     string
-	ZHIs       []float64
+	// ZipCode is 0 for the neighborhoods of --neighborhood-zips without a
+	// zip code.
+	ZipCode uint64
+	// Neighborhood is the name of the neighborhood of the rows of
+	// neighborhood datasets, and Neighborhoods the neighborhoods rolled up
+	// into a city with --rollup.
+	Neighborhood  string
+	Neighborhoods int
+	// HomeType is the home type of the series of Dataset by its name, all,
+	// sfr, condo, or new-construction, see homeTypeOf.
+	HomeType string
+	City     string
+	State    string
+	County   string
+	// Fips is the 5 digit county FIPS code, only in newer exports.
+	Fips string
+	ZHIs []float64
+	// Months labels ZHIs as YYYY-MM, or YYYY-QN and YYYY once resampled.
+	// It's shared by all rows of a dataset.
+	Months     []string
 	GrowthRate float64
-	Years      float64
-	Dataset    string
+	// RealGrowthRate is the growth rate of ZHIs deflated by the CPI, only
+	// computed with --real.
+	RealGrowthRate float64
+	// Income is the median household income of the zip code and
+	// Affordability the price to income ratio, only set with --income.
+	Income        float64
+	Affordability float64
+	// Payment is the estimated monthly principal and interest payment at
+	// the latest price, only set with --rate, plus the property tax with
+	// --tax.
+	Payment float64
+	// Tax is the estimated yearly property tax at the latest price with the
+	// effective TaxRate of the county, only set with --tax.
+	Tax     float64
+	TaxRate float64
+	// Yield is the gross rental yield of the latest rent and price in
+	// percent, and CapRate what's left of it after --expense-ratio, only set
+	// with --rent, see setYield.
+	Yield   float64
+	CapRate float64
+	// Inventory is the for-sale inventory and DaysOnMarket the median days
+	// to pending of the smallest region holding the zip code, only set with
+	// --inventory and --days-on-market.
+	Inventory    float64
+	DaysOnMarket float64
+	// SizeRank is the rank of the zip code by size in Zillow's datasets, 0
+	// for the largest, and RankDelta the places it climbed since the snapshot
+	// of --rank-since.
+	SizeRank     int
+	RankDelta    int
+	hasSizeRank  bool
+	hasRankDelta bool
+	Years        float64
+	YoY          float64
+	Volatility   float64
+	Drawdown     float64
+	// Acceleration is the change of the yearly growth rate of the last
+	// --acceleration-months, see calculateAcceleration.
+	Acceleration float64
+	// MissingPct is the share of the months of the dataset without a price,
+	// before --interpolate fills them.
+	MissingPct float64
+	// Quality rates how much the metrics of the price history can be
+	// trusted, from 0 to 1, see calculateQuality.
+	Quality   float64
+	RelGrowth float64
+	Benchmark string
+	// GrowthPct and PricePct are the percentile ranks within the rows
+	// matching the query, GrowthStatePct and PriceStatePct within the rows
+	// of the same state. Both are by dataset.
+	GrowthPct      float64
+	PricePct       float64
+	GrowthStatePct float64
+	PriceStatePct  float64
+	// Score is the value of --score.
+	Score float64
+	// Defined holds the fields of --define by name.
+	Defined map[string]float64
+	// Metrics holds the registered metrics by name, see RegisterMetric.
+	Metrics map[string]float64
+	// Joined holds the rows of the datasets of --join with the same zip
+	// code, by join name.
+	Joined  map[string]*Data
+	Dataset string
+	// Repository is the repository of Dataset, only set when querying
+	// several repositories.
+	Repository string
+	// Snapshot is the snapshot directory of Dataset, see resolveSnapshot.
+	Snapshot string
+	// Duplicates are the other datasets with the zip code, only set with
+	// --dedupe.
+	Duplicates []string
+
+	// perYear is the number of ZHIs per year, 12 unless resampled.
+	perYear int
+	// aggregated is set once the metrics depending on the whole result set,
+	// like RelGrowth, have been computed.
+	aggregated bool
+}
+
+func (d *Data) Price() float64 {
+	return d.ZHIs[len(d.ZHIs)-1]
 }
 
 func (d *Data) String() string {
+	return d.text(options{})
+}
+
+func (d *Data) text(opts options) string {
+	var history, realGrowthRate, computed string
+	if !opts.noSparkline {
+		history = fmt.Sprintf("History    : %v\n", sparklineOf(d.ZHIs))
+	}
+	if opts.real {
+		realGrowthRate = fmt.Sprintf("Real Growth: %v\n", opts.float(d.RealGrowthRate))
+	}
+	if opts.scoreExpr != nil {
+		computed = fmt.Sprintf("Score      : %v\n", opts.float(d.Score))
+	}
+	if opts.mortgage != nil {
+		computed += fmt.Sprintf("Payment    : %v/month\n", opts.money(d.Payment, "comma"))
+	}
+	if opts.tax != nil && d.TaxRate > 0 {
+		computed += fmt.Sprintf("Tax        : %v/year at %v%%\n", opts.money(d.Tax, "comma"), d.TaxRate)
+	}
+	if d.Inventory > 0 {
+		computed += fmt.Sprintf("Inventory  : %.0f for sale\n", d.Inventory)
+	}
+	if d.DaysOnMarket > 0 {
+		computed += fmt.Sprintf("Days Listed: %.0f to pending\n", d.DaysOnMarket)
+	}
+	if d.hasRankDelta {
+		computed += fmt.Sprintf("Size Rank  : %v, %+d since --rank-since\n", d.SizeRank, d.RankDelta)
+	}
+	if d.MissingPct > 0 {
+		computed += fmt.Sprintf("Missing    : %.1f%% of months\n", d.MissingPct)
+	}
+	if d.Yield > 0 {
+		computed += fmt.Sprintf("Yield      : %.2f%% gross, %.2f%% cap rate\n", d.Yield, d.CapRate)
+	}
+	if opts.income != nil && d.Income > 0 {
+		computed += fmt.Sprintf("Income     : %v, price %.1fx\n", opts.money(d.Income, "comma"), d.Affordability)
+	}
+	for _, m := range metricProviders {
+		computed += fmt.Sprintf("%-11s: %v\n", m.Name(), opts.float(d.Metrics[m.Name()]))
+	}
+	for _, j := range append(opts.joins, opts.homeTypeJoins...) {
+		if joined := d.Joined[j.name]; joined != nil {
+			computed += fmt.Sprintf("%-11s: %v, growth %v, YoY %v\n", j.name, opts.float(joined.Price()), opts.float(joined.GrowthRate), opts.float(joined.YoY))
+		}
+	}
+	for _, def := range opts.definitions {
+		computed += fmt.Sprintf("%-11s: %v\n", def.name, opts.float(d.Defined[def.name]))
+	}
+	if d.Repository != "" {
+		computed += fmt.Sprintf("Repository : %v\n", d.Repository)
+	}
+	if d.Snapshot != "" {
+		computed += fmt.Sprintf("Snapshot   : %v\n", d.Snapshot)
+	}
+	if len(d.Duplicates) > 0 {
+		computed += fmt.Sprintf("Duplicates : %v\n", strings.Join(d.Duplicates, ", "))
+	}
+
+	if d.HomeType != "" && d.HomeType != "all" {
+		computed += fmt.Sprintf("Home Type  : %v\n", d.HomeType)
+	}
+	if d.Neighborhood != "" {
+		computed += fmt.Sprintf("Nbhd       : %v\n", d.Neighborhood)
+	}
+	if d.Neighborhoods > 0 {
+		computed += fmt.Sprintf("Rolled Up  : %v neighborhoods\n", d.Neighborhoods)
+	}
+
+	county := d.County
+	if d.Fips != "" {
+		county += " (" + d.Fips + ")"
+	}
+
+	// neighborhoods and the cities they're rolled up into are placed by name
+	place := fmt.Sprint(d.ZipCode)
+	if d.Neighborhood != "" || d.Neighborhoods > 0 {
+		names := []string{d.City, d.State}
+		if d.Neighborhood != "" {
+			names = append([]string{d.Neighborhood}, names...)
+		}
+		place = url.PathEscape(strings.Join(names, ", "))
+	}
+
+	growthRate := opts.float(d.GrowthRate)
+	price := opts.money(d.Price(), "comma")
+	if opts.color {
+		growthRate = colorize(growthColor(d.GrowthRate), growthRate)
+		price = colorize(priceColor(d.Price()), price)
+	}
+
 	return fmt.Sprintf(`
 Dataset    : %v
 Zip Code   : %v
@@ -46,19 +235,83 @@ City       : %v
 State      : %v
 County     : %v
 Growth Rate: %v
-Years      : %v
-Price      : $%v
-Google Map : https://www.google.com/maps/place/%v
-`, d.Dataset, d.ZipCode, d.City, d.State, d.County, d.GrowthRate, d.Years, humanize.Comma(int64(d.ZHIs[len(d.ZHIs)-1])), d.ZipCode)
+%vRel Growth : %+.2fpp vs %v
+Growth Pct : %.1f matched, %.1f in state
+Accel      : %+.2fpp over %d months
+%vYears      : %v, quality %.2f
+Price      : %v
+%vGoogle Map : https://www.google.com/maps/place/%v
+`, d.Dataset, d.ZipCode, d.City, d.State, county, growthRate, realGrowthRate, d.RelGrowth, d.Benchmark, d.GrowthPct, d.GrowthStatePct, d.Acceleration, accelerationMonths, computed, d.Years, d.Quality, price, history, place)
 }
 
-type SortableData []Data
+var sortKeys = map[string]func(a, b *Data) bool{
+	"Dataset":        func(a, b *Data) bool { return a.Dataset < b.Dataset },
+	"Repository":     func(a, b *Data) bool { return a.Repository < b.Repository },
+	"Snapshot":       func(a, b *Data) bool { return a.Snapshot < b.Snapshot },
+	"ZipCode":        func(a, b *Data) bool { return a.ZipCode < b.ZipCode },
+	"Neighborhood":   func(a, b *Data) bool { return a.Neighborhood < b.Neighborhood },
+	"HomeType":       func(a, b *Data) bool { return a.HomeType < b.HomeType },
+	"City":           func(a, b *Data) bool { return a.City < b.City },
+	"State":          func(a, b *Data) bool { return a.State < b.State },
+	"County":         func(a, b *Data) bool { return a.County < b.County },
+	"GrowthRate":     func(a, b *Data) bool { return a.GrowthRate < b.GrowthRate },
+	"RealGrowthRate": func(a, b *Data) bool { return a.RealGrowthRate < b.RealGrowthRate },
+	"Income":         func(a, b *Data) bool { return a.Income < b.Income },
+	"Affordability":  func(a, b *Data) bool { return a.Affordability < b.Affordability },
+	"Inventory":      func(a, b *Data) bool { return a.Inventory < b.Inventory },
+	"DaysOnMarket":   func(a, b *Data) bool { return a.DaysOnMarket < b.DaysOnMarket },
+	"Payment":        func(a, b *Data) bool { return a.Payment < b.Payment },
+	"Yield":          func(a, b *Data) bool { return a.Yield < b.Yield },
+	"CapRate":        func(a, b *Data) bool { return a.CapRate < b.CapRate },
+	"Tax":            func(a, b *Data) bool { return a.Tax < b.Tax },
+	"SizeRank":       func(a, b *Data) bool { return a.SizeRank < b.SizeRank },
+	"RankDelta":      func(a, b *Data) bool { return a.RankDelta < b.RankDelta },
+	"Years":          func(a, b *Data) bool { return a.Years < b.Years },
+	"YoY":            func(a, b *Data) bool { return a.YoY < b.YoY },
+	"Volatility":     func(a, b *Data) bool { return a.Volatility < b.Volatility },
+	"Drawdown":       func(a, b *Data) bool { return a.Drawdown < b.Drawdown },
+	"Quality":        func(a, b *Data) bool { return a.Quality < b.Quality },
+	"Acceleration":   func(a, b *Data) bool { return a.Acceleration < b.Acceleration },
+	"MissingPct":     func(a, b *Data) bool { return a.MissingPct < b.MissingPct },
+	"RelGrowth":      func(a, b *Data) bool { return a.RelGrowth < b.RelGrowth },
+	"GrowthPct":      func(a, b *Data) bool { return a.GrowthPct < b.GrowthPct },
+	"PricePct":       func(a, b *Data) bool { return a.PricePct < b.PricePct },
+	"GrowthStatePct": func(a, b *Data) bool { return a.GrowthStatePct < b.GrowthStatePct },
+	"PriceStatePct":  func(a, b *Data) bool { return a.PriceStatePct < b.PriceStatePct },
+	"Score":          func(a, b *Data) bool { return a.Score < b.Score },
+	"Price":          func(a, b *Data) bool { return a.Price() < b.Price() },
+}
 
-func (d SortableData) Len() int           { return len(d) }
-func (d SortableData) Less(i, j int) bool { return d[i].GrowthRate < d[j].GrowthRate }
-func (d SortableData) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+// lookupSortKey returns the sort key key, of every query or of v.
+func lookupSortKey(key string, v *vocabulary) (func(a, b *Data) bool, bool) {
+	if less, ok := sortKeys[key]; ok {
+		return less, true
+	}
+	if less, ok := metricFields.sortKeys[key]; ok {
+		return less, true
+	}
+	if v != nil {
+		less, ok := v.sortKeys[key]
+		return less, ok
+	}
+	return nil, false
+}
+
+func sortDatas(datas []Data, key string, v *vocabulary) error {
+	less, ok := lookupSortKey(key, v)
+	if !ok {
+		return fmt.Errorf("Couldn't find sort key %s", key)
+	}
+
+	sort.SliceStable(datas, func(i, j int) bool {
+		return less(&datas[i], &datas[j])
+	})
+	return nil
+}
 
-func calculateGrowthRate(vs []float64) (float64, float64) {
+// calculateGrowthRate returns the compound annual growth rate in percent of
+// vs, which has perYear values per year, and the number of years used.
+func calculateGrowthRate(vs []float64, perYear int) (float64, float64) {
 	start := 0
 	for i, v := range vs {
 		if v != 0.0 {
@@ -68,16 +321,152 @@ func calculateGrowthRate(vs []float64) (float64, float64) {
 	}
 
 	months := len(vs) - start
-	rem := months % 12
-	start += rem
+	if months == 0 || vs[start] == 0 {
+		return 0, 0
+	}
+
+	// keep whole years, unless there's less than a year of values
+	if rem := months % perYear; rem < months {
+		start += rem
+	}
+	// the whole years may start on a missing month
+	for start < len(vs)-1 && vs[start] == 0 {
+		start++
+	}
+	if vs[start] == 0 {
+		return 0, 0
+	}
 
 	future := vs[len(vs)-1]
 	present := vs[start]
-	years := float64(len(vs)-start) / 12
+	years := float64(len(vs)-start) / float64(perYear)
 
 	return (math.Pow(future/present, 1/years) - 1) * 100, years
 }
 
+func (d *Data) calculateMetrics() {
+	perYear := d.perYear
+	if perYear == 0 {
+		perYear = 12
+	}
+
+	d.GrowthRate, d.Years = calculateGrowthRate(d.ZHIs, perYear)
+	d.YoY = calculateYoY(d.ZHIs, perYear)
+	d.Volatility = calculateVolatility(d.ZHIs, perYear)
+	d.Drawdown = calculateDrawdown(d.ZHIs)
+	d.Quality = calculateQuality(d.ZHIs, perYear)
+	d.Acceleration = calculateAcceleration(d.ZHIs, perYear, accelerationMonths)
+	d.computeMetrics()
+}
+
+// PerYear returns the number of ZHIs per year.
+func (d *Data) PerYear() int {
+	if d.perYear == 0 {
+		return 12
+	}
+	return d.perYear
+}
+
+// calculateYoY returns the price change over the last year in percent.
+func calculateYoY(vs []float64, perYear int) float64 {
+	if len(vs) <= perYear || vs[len(vs)-1-perYear] == 0 {
+		return 0
+	}
+
+	return (vs[len(vs)-1]/vs[len(vs)-1-perYear] - 1) * 100
+}
+
+// calculateVolatility returns the annualized standard deviation of the
+// returns between values in percent.
+func calculateVolatility(vs []float64, perYear int) float64 {
+	var returns []float64
+	for i := 1; i < len(vs); i++ {
+		if vs[i-1] != 0 && vs[i] != 0 {
+			returns = append(returns, vs[i]/vs[i-1]-1)
+		}
+	}
+	if len(returns) < 2 {
+		return 0
+	}
+
+	var mean float64
+	for _, r := range returns {
+		mean += r
+	}
+	mean /= float64(len(returns))
+
+	var variance float64
+	for _, r := range returns {
+		variance += (r - mean) * (r - mean)
+	}
+	variance /= float64(len(returns) - 1)
+
+	return math.Sqrt(variance) * math.Sqrt(float64(perYear)) * 100
+}
+
+// calculateDrawdown returns the largest peak-to-trough decline in percent.
+func calculateDrawdown(vs []float64) float64 {
+	var peak, drawdown float64
+	for _, v := range vs {
+		if v == 0 {
+			continue
+		}
+
+		peak = math.Max(peak, v)
+		drawdown = math.Max(drawdown, (1-v/peak)*100)
+	}
+	return drawdown
+}
+
+// qualityYears is the length of the price history past which its length no
+// longer lowers its quality.
+const qualityYears = 10
+
+// calculateQuality returns the share of qualityYears the values cover,
+// times the share of months since the first value that aren't missing, so
+// that a high growth rate over 18 months or over a history full of gaps
+// rates lower than a 20 year track record.
+func calculateQuality(vs []float64, perYear int) float64 {
+	start := -1
+	missing := 0
+	for i, v := range vs {
+		if v != 0 && start < 0 {
+			start = i
+		} else if v == 0 && start >= 0 {
+			missing++
+		}
+	}
+	if start < 0 {
+		return 0
+	}
+
+	span := len(vs) - start
+	coverage := math.Min(1, float64(span)/float64(perYear)/qualityYears)
+	return coverage * float64(span-missing) / float64(span)
+}
+
+// accelerationMonths is --acceleration-months, set by options.prepare as
+// metrics are computed while parsing.
+var accelerationMonths = 6
+
+// calculateAcceleration returns the yearly growth rate of the last months
+// minus the one of the months before them in percentage points, the second
+// derivative of the prices: positive when the growth speeds up, negative
+// when it slows down, even while YoY still rises. 0 without two periods
+// of values.
+func calculateAcceleration(vs []float64, perYear, months int) float64 {
+	n := max(1, months*perYear/12)
+	end := len(vs) - 1
+	if end < 2*n || vs[end-2*n] == 0 || vs[end-n] == 0 || vs[end] == 0 {
+		return 0
+	}
+
+	years := float64(n) / float64(perYear)
+	recent := (math.Pow(vs[end]/vs[end-n], 1/years) - 1) * 100
+	before := (math.Pow(vs[end-n]/vs[end-2*n], 1/years) - 1) * 100
+	return recent - before
+}
+
 type FilterFn func(*Data) bool
 
 func filterByZipCode(zipCode uint64) FilterFn {
@@ -86,37 +475,93 @@ func filterByZipCode(zipCode uint64) FilterFn {
 	})
 }
 
+// filterByZipPrefix matches the zip codes written with 5 digits starting
+// with prefix, e.g. 021 matches 02134.
+func filterByZipPrefix(prefix string) (FilterFn, error) {
+	if _, err := strconv.ParseUint(prefix, 10, 64); err != nil || len(prefix) > 5 {
+		return nil, fmt.Errorf("ZipPrefix expects 1 to 5 digits, got %s", prefix)
+	}
+
+	return FilterFn(func(d *Data) bool {
+		return strings.HasPrefix(fmt.Sprintf("%05d", d.ZipCode), prefix)
+	}), nil
+}
+
+func filterByFips(fips string) FilterFn {
+	if len(fips) == 4 {
+		fips = "0" + fips
+	}
+	return FilterFn(func(d *Data) bool {
+		return d.Fips == fips
+	})
+}
+
 func filterByDataset(dataset string) FilterFn {
-	dataset = strings.ToLower(dataset)
+	dataset = foldText(dataset)
+	return FilterFn(func(d *Data) bool {
+		return foldText(d.Dataset) == dataset
+	})
+}
+
+func filterByRepository(repository string) FilterFn {
+	repository = path.Clean(repository)
+	return FilterFn(func(d *Data) bool {
+		return path.Clean(d.Repository) == repository
+	})
+}
+
+func filterBySnapshot(snapshot string) FilterFn {
 	return FilterFn(func(d *Data) bool {
-		return strings.ToLower(d.Dataset) == dataset
+		return d.Snapshot == snapshot
 	})
 }
 
 func filterByState(state string) FilterFn {
-	state = strings.ToLower(state)
+	state = foldText(normalizeState(state))
 	return FilterFn(func(d *Data) bool {
-		return strings.ToLower(d.State) == state
+		return foldText(d.State) == state
 	})
 }
 
 func filterByCounty(county string) FilterFn {
-	county = strings.ToLower(county)
+	if strings.HasPrefix(county, fuzzyPrefix) {
+		return filterByFuzzyName(county[len(fuzzyPrefix):], func(d *Data) string { return d.County })
+	}
+	county = foldText(county)
 	return FilterFn(func(d *Data) bool {
-		return strings.ToLower(d.County) == county
+		return foldText(d.County) == county
 	})
 }
 
 func filterByCity(city string) FilterFn {
-	city = strings.ToLower(city)
+	if strings.HasPrefix(city, fuzzyPrefix) {
+		return filterByFuzzyName(city[len(fuzzyPrefix):], func(d *Data) string { return d.City })
+	}
+	city = foldText(city)
 	return FilterFn(func(d *Data) bool {
-		return strings.ToLower(d.City) == city
+		return foldText(d.City) == city
+	})
+}
+
+func filterByNeighborhood(neighborhood string) FilterFn {
+	if strings.HasPrefix(neighborhood, fuzzyPrefix) {
+		return filterByFuzzyName(neighborhood[len(fuzzyPrefix):], func(d *Data) string { return d.Neighborhood })
+	}
+	neighborhood = foldText(neighborhood)
+	return FilterFn(func(d *Data) bool {
+		return foldText(d.Neighborhood) == neighborhood
 	})
 }
 
 func filterByPrice(price float64) FilterFn {
 	return FilterFn(func(d *Data) bool {
-		return d.ZHIs[len(d.ZHIs)-1] <= price
+		return d.Price() <= price
+	})
+}
+
+func filterByPriceMin(price float64) FilterFn {
+	return FilterFn(func(d *Data) bool {
+		return d.Price() >= price
 	})
 }
 
@@ -126,6 +571,16 @@ func filterByGrowthRate(rate float64) FilterFn {
 	})
 }
 
+func filterByComparison(field comparisonField, op string, v float64) FilterFn {
+	compare := comparisonOperators[op]
+	return FilterFn(func(d *Data) bool {
+		if field.aggregate && !d.aggregated {
+			return true
+		}
+		return compare(field.value(d), v)
+	})
+}
+
 func chainByAnd(filters ...FilterFn) FilterFn {
 	return FilterFn(func(d *Data) bool {
 		for _, f := range filters {
@@ -150,17 +605,229 @@ func chainByOr(filters ...FilterFn) FilterFn {
 	})
 }
 
-func parseFilters(tokens []string) (FilterFn, int, error) {
-	var filters []FilterFn
-	var operators []string
-	i := 0
+var stringFilters = map[string]func(string) FilterFn{
+	"Fips":         filterByFips,
+	"Dataset":      filterByDataset,
+	"Repository":   filterByRepository,
+	"Snapshot":     filterBySnapshot,
+	"State":        filterByState,
+	"County":       filterByCounty,
+	"City":         filterByCity,
+	"Neighborhood": filterByNeighborhood,
+	"HomeType":     filterByHomeType,
+}
+
+var floatFilters = map[string]func(float64) FilterFn{
+	"GrowthRate": filterByGrowthRate,
+	"Price":      filterByPrice,
+	"PriceMin":   filterByPriceMin,
+}
+
+var uintFilters = map[string]func(uint64) FilterFn{
+	"ZipCode": filterByZipCode,
+}
+
+type comparisonField struct {
+	value func(d *Data) float64
+	// aggregate marks values that depend on the whole result set. Their
+	// filters match everything until the values are known, see search.
+	aggregate bool
+}
+
+// comparisonFilters take an operator and a number, e.g. RelGrowth:>=1.5
+var comparisonFilters = map[string]comparisonField{
+	"RelGrowth":      {func(d *Data) float64 { return d.RelGrowth }, true},
+	"RealGrowthRate": {func(d *Data) float64 { return d.RealGrowthRate }, false},
+	"Affordability":  {affordability, false},
+	"Payment":        {payment, false},
+	"Yield":          {rentalYield, false},
+	"CapRate":        {capRate, false},
+	"Tax":            {tax, false},
+	"Inventory":      {inventory, false},
+	"DaysOnMarket":   {daysOnMarket, false},
+	"RankDelta":      {rankDelta, false},
+	"Quality":        {func(d *Data) float64 { return d.Quality }, false},
+	"Acceleration":   {func(d *Data) float64 { return d.Acceleration }, false},
+	"MissingPct":     {func(d *Data) float64 { return d.MissingPct }, false},
+	"GrowthPct":      {func(d *Data) float64 { return d.GrowthPct }, true},
+	"PricePct":       {func(d *Data) float64 { return d.PricePct }, true},
+	"GrowthStatePct": {func(d *Data) float64 { return d.GrowthStatePct }, true},
+	"PriceStatePct":  {func(d *Data) float64 { return d.PriceStatePct }, true},
+	"Score":          {func(d *Data) float64 { return d.Score }, true},
+}
+
+// lookupComparison returns the comparison filter kind, of every query or of
+// v.
+func lookupComparison(kind string, v *vocabulary) (comparisonField, bool) {
+	if field, ok := comparisonFilters[kind]; ok {
+		return field, true
+	}
+	if field, ok := metricFields.comparisonFilters[kind]; ok {
+		return field, true
+	}
+	if v != nil {
+		field, ok := v.comparisonFilters[kind]
+		return field, ok
+	}
+	return comparisonField{}, false
+}
+
+var comparisonOperators = map[string]func(a, b float64) bool{
+	">=": func(a, b float64) bool { return a >= b },
+	"<=": func(a, b float64) bool { return a <= b },
+	"!=": func(a, b float64) bool { return a != b },
+	">":  func(a, b float64) bool { return a > b },
+	"<":  func(a, b float64) bool { return a < b },
+	"=":  func(a, b float64) bool { return a == b },
+}
+
+func parseComparison(arg string) (string, float64, error) {
+	for _, op := range []string{">=", "<=", "!=", ">", "<", "="} {
+		if strings.HasPrefix(arg, op) {
+			v, err := strconv.ParseFloat(arg[len(op):], 64)
+			return op, v, err
+		}
+	}
+
+	return "", 0, fmt.Errorf("Invalid comparison %s, expected one of >=, <=, !=, >, <, = followed by a number", arg)
+}
+
+// customFilters parse their own arguments.
+var customFilters = map[string]func(string) (FilterFn, error){
+	"Near":      filterByNear,
+	"BBox":      filterByBBox,
+	"Adjacent":  filterByAdjacent,
+	"ZipPrefix": filterByZipPrefix,
+}
+
+// filterNode is a parsed query. Groups fold their filters left to right,
+// so [ a and b or c ] is or(and(a, b), c).
+type filterNode struct {
+	// op is and or or for a node combining left and right, empty for a
+	// filter.
+	op          string
+	left, right *filterNode
+	token       string
+	description string
+	filter      FilterFn
+	// aggregate is set when the filter or one below depends on the whole
+	// result set.
+	aggregate bool
+}
+
+// floatFilterBounds describe the bound floatFilters check their argument
+// against.
+var floatFilterBounds = map[string]string{
+	"GrowthRate": ">=",
+	"Price":      "<=",
+	"PriceMin":   ">=",
+}
 
+func parseFilter(token string, fields *vocabulary) (*filterNode, error) {
+	splitted := strings.SplitN(token, ":", 2)
+	if len(splitted) != 2 {
+		return nil, fmt.Errorf("Invalid filter %s", token)
+	}
+	kind, arg := splitted[0], splitted[1]
+	node := &filterNode{token: token}
+
+	if f, ok := stringFilters[kind]; ok {
+		node.filter, node.description = f(arg), fmt.Sprintf("%s = %q", kind, arg)
+	} else if f, ok := floatFilters[kind]; ok {
+		v, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s expects a number, got %s", kind, arg)
+		}
+		node.filter, node.description = f(v), fmt.Sprintf("%s %s %v", kind, floatFilterBounds[kind], v)
+	} else if f, ok := uintFilters[kind]; ok {
+		v, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s expects an unsigned integer, got %s", kind, arg)
+		}
+		node.filter, node.description = f(v), fmt.Sprintf("%s = %d", kind, v)
+	} else if field, ok := lookupComparison(kind, fields); ok {
+		op, v, err := parseComparison(arg)
+		if err != nil {
+			return nil, err
+		}
+		node.filter, node.description = filterByComparison(field, op, v), fmt.Sprintf("%s %s %v", kind, op, v)
+		if field.aggregate {
+			node.description += " (once every zip code is loaded)"
+			node.aggregate = true
+		}
+	} else if f, ok := customFilters[kind]; ok {
+		filter, err := f(arg)
+		if err != nil {
+			return nil, err
+		}
+		node.filter, node.description = filter, fmt.Sprintf("%s(%s)", kind, arg)
+	} else if p, ok := filterProviders[kind]; ok {
+		filter, err := parseProvidedFilter(p, arg)
+		if err != nil {
+			return nil, err
+		}
+		node.filter, node.description = filter, fmt.Sprintf("%s(%s)", kind, arg)
+	} else if suggestion := closestKind(kind, fields); suggestion != "" {
+		return nil, fmt.Errorf("Couldn't find filter %s, did you mean %s?", kind, suggestion)
+	} else {
+		return nil, fmt.Errorf("Couldn't find filter %s", kind)
+	}
+	return node, nil
+}
+
+// parseError is a query error at a token.
+type parseError struct {
+	token int
+	msg   string
+}
+
+func (e *parseError) Error() string {
+	return fmt.Sprintf("%s (token %d)", e.msg, e.token+1)
+}
+
+// pointAt renders the tokens with the token of err underlined.
+func (e *parseError) pointAt(tokens []string) string {
+	column, width := 0, 1
+	if e.token > 0 {
+		column = utf8.RuneCountInString(tokensString(tokens[:e.token])) + 1
+	}
+	if e.token < len(tokens) {
+		width = utf8.RuneCountInString(tokensString(tokens[e.token : e.token+1]))
+	}
+	return fmt.Sprintf("%s\n%s%s", tokensString(tokens), strings.Repeat(" ", column), strings.Repeat("^", width))
+}
+
+func parseFilters(tokens []string, v *vocabulary) (FilterFn, int, error) {
+	node, length, err := parseFilterTree(tokens, v)
+	if err != nil {
+		return nil, -1, err
+	}
+	return node.filter, length, nil
+}
+
+func parseFilterTree(tokens []string, v *vocabulary) (*filterNode, int, error) {
 	if len(tokens) == 0 {
 		return nil, -1, fmt.Errorf("No token given")
 	}
 
+	node, length, err := parseGroup(tokens, 0, v)
+	if err != nil {
+		return nil, -1, err
+	}
+	if length < len(tokens) {
+		return nil, -1, &parseError{length, fmt.Sprintf("Unexpected %s after the query", tokens[length])}
+	}
+	return node, length, nil
+}
+
+// parseGroup parses the group starting at tokens[0], the token at offset of
+// the whole query, and returns the number of tokens it spans.
+func parseGroup(tokens []string, offset int, v *vocabulary) (*filterNode, int, error) {
+	var filters []*filterNode
+	var operators []string
+
 	if tokens[0] != tokenGroupStart {
-		return nil, -1, fmt.Errorf("Tokens need to always start with a %s", tokenGroupStart)
+		return nil, -1, &parseError{offset, fmt.Sprintf("Tokens need to always start with a %s", tokenGroupStart)}
 	}
 
 	parseOperator := func(token string) (string, bool) {
@@ -171,181 +838,1506 @@ func parseFilters(tokens []string) (FilterFn, int, error) {
 		return "", false
 	}
 
-	parseFilter := func(token string) (FilterFn, error) {
-		splitted := strings.Split(token, ":")
-		kind, arg := splitted[0], splitted[1]
+	expectFilter := func() bool { return len(filters) == len(operators) }
 
-		stringFilters := map[string]func(string) FilterFn{
-			"Dataset": filterByDataset,
-			"State":   filterByState,
-			"County":  filterByCounty,
-			"City":    filterByCity,
-		}
-		floatFilters := map[string]func(float64) FilterFn{
-			"GrowthRate": filterByGrowthRate,
-			"Price":      filterByPrice,
-		}
-		uintFilters := map[string]func(uint64) FilterFn{
-			"ZipCode": filterByZipCode,
+	for i := 1; i < len(tokens); i++ {
+		token := tokens[i]
+		at := func(format string, args ...interface{}) error {
+			return &parseError{offset + i, fmt.Sprintf(format, args...)}
 		}
 
-		if f, ok := stringFilters[kind]; ok {
-			return f(arg), nil
-		} else if f, ok := floatFilters[kind]; ok {
-			arg, err := strconv.ParseFloat(arg, 64)
-			if err != nil {
-				return nil, err
-			}
-			return f(arg), nil
-		} else if f, ok := uintFilters[kind]; ok {
-			arg, err := strconv.ParseUint(arg, 10, 64)
-			if err != nil {
-				return nil, err
+		if token == tokenGroupEnd {
+			if len(filters) == 0 && len(operators) == 0 {
+				return nil, -1, at("Empty group")
+			} else if expectFilter() {
+				return nil, -1, at("Expected a filter or a group after %s", operators[len(operators)-1])
 			}
-			return f(arg), nil
-		}
 
-		return nil, fmt.Errorf("Couldn't find filter")
-	}
-
-	tokens = tokens[1:]
-
-	for i < len(tokens) {
-		token := tokens[i]
-
-		if token == tokenGroupEnd {
-			f := filters[0]
+			node := filters[0]
 			for i, op := range operators {
-				nextFilter := filters[i+1]
+				next := filters[i+1]
 				if op == "and" {
-					f = chainByAnd(f, nextFilter)
-				} else if op == "or" {
-					f = chainByOr(f, nextFilter)
+					node = &filterNode{op: op, left: node, right: next, filter: chainByAnd(node.filter, next.filter)}
 				} else {
-					return nil, -1, fmt.Errorf("Invalid operator")
+					node = &filterNode{op: op, left: node, right: next, filter: chainByOr(node.filter, next.filter)}
 				}
+				node.aggregate = node.left.aggregate || node.right.aggregate
 			}
 
-			return f, i + 1, nil
+			return node, i + 1, nil
+		}
+
+		if op, ok := parseOperator(token); ok {
+			if expectFilter() {
+				return nil, -1, at("Expected a filter or a group before %s", op)
+			}
+			operators = append(operators, op)
+			continue
+		}
+
+		if !expectFilter() {
+			return nil, -1, at("Expected and or or before %s", token)
 		}
 
 		if token == tokenGroupStart {
-			f, length, err := parseFilters(tokens[i:])
+			node, length, err := parseGroup(tokens[i:], offset+i, v)
 			if err != nil {
 				return nil, -1, err
 			}
 
-			i += length
-			filters = append(filters, f)
-		} else if op, ok := parseOperator(token); ok {
-			operators = append(operators, op)
+			i += length - 1
+			filters = append(filters, node)
 		} else {
-			f, err := parseFilter(token)
+			node, err := parseFilter(token, v)
 			if err != nil {
-				return nil, -1, err
+				return nil, -1, at("%v", err)
 			}
 
-			filters = append(filters, f)
+			filters = append(filters, node)
+		}
+	}
+
+	return nil, -1, &parseError{offset + len(tokens), fmt.Sprintf("Missing %s to close the %s at token %d", tokenGroupEnd, tokenGroupStart, offset+1)}
+}
+
+// explain writes the filter tree, one node per line indented by depth.
+func (n *filterNode) explain(w io.Writer, depth int) {
+	indent := strings.Repeat("  ", depth)
+	if n.op == "" {
+		fmt.Fprintf(w, "%s%s\n", indent, n.description)
+		return
+	}
+
+	fmt.Fprintf(w, "%s%s\n", indent, n.op)
+	n.left.explain(w, depth+1)
+	n.right.explain(w, depth+1)
+}
+
+// tokenize splits query arguments into tokens. Arguments that hold a whole
+// group, e.g. '[ State:CA and County:"San Mateo" ]', are split on whitespace
+// outside of double quotes, other arguments are taken as a single token.
+func tokenize(args []string) []string {
+	var tokens []string
+	for _, arg := range args {
+		if arg == tokenGroupStart || !strings.HasPrefix(arg, tokenGroupStart) {
+			tokens = append(tokens, arg)
+			continue
+		}
+
+		var token strings.Builder
+		quoted := false
+		for _, r := range arg {
+			switch {
+			case r == '"':
+				quoted = !quoted
+			case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+				if token.Len() > 0 {
+					tokens = append(tokens, token.String())
+					token.Reset()
+				}
+			default:
+				token.WriteRune(r)
+			}
+		}
+		if token.Len() > 0 {
+			tokens = append(tokens, token.String())
+		}
+	}
+	return tokens
+}
+
+// tokensString is the inverse of tokenize.
+func tokensString(tokens []string) string {
+	quoted := make([]string, len(tokens))
+	for i, token := range tokens {
+		if strings.ContainsAny(token, " \t\n") {
+			token = `"` + token + `"`
+		}
+		quoted[i] = token
+	}
+	return strings.Join(quoted, " ")
+}
+
+type options struct {
+	sort           string
+	format         string
+	benchmark      string
+	noSparkline    bool
+	template       string
+	noColor        bool
+	quiet          bool
+	verbose        bool
+	veryVerbose    bool
+	logFormat      string
+	asOf           string
+	series         bool
+	resample       string
+	resampleBy     string
+	real           bool
+	cpiPath        string
+	incomePath     string
+	rate           float64
+	down           float64
+	term           int
+	taxPath        string
+	rent           string
+	expenseRatio   float64
+	rankSince      string
+	score          string
+	defines        stringsFlag
+	exportSheet    string
+	sheetTab       string
+	chart          string
+	report         string
+	record         string
+	dedupe         string
+	merge          stringsFlag
+	explain        bool
+	validate       bool
+	fields         string
+	count          bool
+	countBy        string
+	zips           string
+	excludeFile    string
+	data           stringsFlag
+	join           stringsFlag
+	sample         float64
+	seed           int64
+	precision      int
+	errors         string
+	limit          int
+	cpuProfile     string
+	memProfile     string
+	timings        bool
+	priceFormat    string
+	nbhdZipPath    string
+	seasonal       bool
+	accelMonths    int
+	invtPath       string
+	domPath        string
+	rollup         bool
+	includeMissing bool
+	byHomeType     bool
+	heatmapBy      string
+	interpolate    string
+	snapshot       string
+	allSnapshots   bool
+
+	tmpl         *template.Template
+	precise      bool
+	color        bool
+	progress     bool
+	cpi          cpiTable
+	income       incomeTable
+	nbhdZips     neighborhoodZips
+	inventory    supplyTable
+	daysOnMarket supplyTable
+	mortgage     *mortgage
+	tax          *taxTable
+	rankBaseline sizeRanks
+	scoreExpr    expr
+	definitions  []definition
+	// vocabulary holds the fields of --define, --join, and --by-home-type.
+	vocabulary *vocabulary
+	dedupeWins func(a, b *Data) bool
+	merges     map[string]string
+	columns    []column
+	zipList    map[uint64]bool
+	exclusions FilterFn
+	joins      []join
+	// homeTypeJoins are the fields of --by-home-type, filled by byHomeType
+	// rather than by joining datasets.
+	homeTypeJoins []join
+	// joined indexes the rows of the datasets of joins, and tagRepository
+	// is set when querying several repositories, both by loadWith.
+	joined        map[string]map[uint64]*Data
+	tagRepository bool
+	// pushdown is the query the rows of column files are pruned with, set
+	// by matchTree.
+	pushdown *filterNode
+}
+
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.sort, "sort", "", "")
+	fs.StringVar(&o.format, "format", "text", "")
+	fs.StringVar(&o.benchmark, "benchmark", "us", "")
+	fs.BoolVar(&o.noSparkline, "no-sparkline", false, "")
+	fs.StringVar(&o.template, "template", "", "")
+	fs.BoolVar(&o.noColor, "no-color", false, "")
+	fs.BoolVar(&o.quiet, "quiet", false, "")
+	fs.BoolVar(&o.verbose, "v", false, "")
+	fs.BoolVar(&o.veryVerbose, "vv", false, "")
+	fs.StringVar(&o.logFormat, "log-format", "text", "")
+	fs.StringVar(&o.asOf, "as-of", "", "")
+	fs.BoolVar(&o.series, "series", false, "")
+	fs.StringVar(&o.resample, "resample", "", "")
+	fs.StringVar(&o.resampleBy, "resample-by", "last", "")
+	fs.BoolVar(&o.real, "real", false, "")
+	fs.BoolVar(&o.seasonal, "seasonally-adjusted", false, "")
+	fs.IntVar(&o.accelMonths, "acceleration-months", 6, "")
+	fs.StringVar(&o.interpolate, "interpolate", "none", "")
+	fs.StringVar(&o.snapshot, "snapshot", "", "")
+	fs.BoolVar(&o.allSnapshots, "all-snapshots", false, "")
+	fs.StringVar(&o.cpiPath, "cpi", "", "")
+	fs.StringVar(&o.incomePath, "income", "", "")
+	fs.StringVar(&o.nbhdZipPath, "neighborhood-zips", "", "")
+	fs.StringVar(&o.invtPath, "inventory", "", "")
+	fs.StringVar(&o.domPath, "days-on-market", "", "")
+	fs.BoolVar(&o.rollup, "rollup", false, "")
+	fs.BoolVar(&o.includeMissing, "include-missing", false, "")
+	fs.BoolVar(&o.byHomeType, "by-home-type", false, "")
+	fs.StringVar(&o.heatmapBy, "heatmap-by", "zip", "")
+	fs.Float64Var(&o.rate, "rate", 0, "")
+	fs.Float64Var(&o.down, "down", 20, "")
+	fs.IntVar(&o.term, "term", 30, "")
+	fs.StringVar(&o.taxPath, "tax", "", "")
+	fs.StringVar(&o.rent, "rent", "", "")
+	fs.Float64Var(&o.expenseRatio, "expense-ratio", 40, "")
+	fs.StringVar(&o.rankSince, "rank-since", "", "")
+	fs.StringVar(&o.score, "score", "", "")
+	fs.Var(&o.defines, "define", "")
+	fs.StringVar(&o.exportSheet, "export-sheet", "", "")
+	fs.StringVar(&o.sheetTab, "sheet-tab", "Sheet1", "")
+	fs.StringVar(&o.chart, "chart", "", "")
+	fs.StringVar(&o.report, "report", "", "")
+	fs.StringVar(&o.record, "record", "", "")
+	fs.StringVar(&o.dedupe, "dedupe", "all", "")
+	fs.Var(&o.merge, "merge", "")
+	fs.BoolVar(&o.explain, "explain", false, "")
+	fs.BoolVar(&o.validate, "validate", false, "")
+	fs.StringVar(&o.fields, "fields", "", "")
+	fs.BoolVar(&o.count, "count", false, "")
+	fs.StringVar(&o.countBy, "count-by", "", "")
+	fs.StringVar(&o.zips, "zips", "", "")
+	fs.StringVar(&o.excludeFile, "exclude-file", "", "")
+	fs.Var(&o.data, "data", "")
+	fs.Var(&o.join, "join", "")
+	fs.Float64Var(&o.sample, "sample", 0, "")
+	fs.Int64Var(&o.seed, "seed", 0, "")
+	fs.IntVar(&o.precision, "precision", -1, "")
+	fs.StringVar(&o.errors, "errors", "text", "")
+	fs.IntVar(&o.limit, "limit", 0, "")
+	fs.StringVar(&o.cpuProfile, "cpuprofile", "", "")
+	fs.StringVar(&o.memProfile, "memprofile", "", "")
+	fs.BoolVar(&o.timings, "timings", false, "")
+	fs.StringVar(&o.priceFormat, "price-format", "", "")
+}
+
+// prepare validates the flags that can be checked before loading any
+// dataset.
+func (o *options) prepare() error {
+	if o.errors != "text" && o.errors != "json" {
+		return fmt.Errorf("Couldn't find error format %s", o.errors)
+	}
+	errorFormat = o.errors
+	if o.accelMonths < 1 {
+		return fmt.Errorf("--acceleration-months expects a positive number of months, got %d", o.accelMonths)
+	}
+	accelerationMonths = o.accelMonths
+	if err := validateInterpolation(o.interpolate); err != nil {
+		return err
+	}
+	if o.snapshot != "" && !isSnapshot(o.snapshot) {
+		return fmt.Errorf("--snapshot expects a snapshot directory like 2023-06 or 2023-06-15, got %s", o.snapshot)
+	}
+	if o.allSnapshots && (o.snapshot != "" || o.count || o.countBy != "") {
+		return fmt.Errorf("--all-snapshots can't be used with --snapshot, --count, or --count-by")
+	}
+
+	level := slog.LevelWarn
+	if o.veryVerbose {
+		level = slog.LevelDebug
+	} else if o.verbose {
+		level = slog.LevelInfo
+	}
+	if err := configureLogger(level, o.logFormat); err != nil {
+		return err
+	}
+
+	if o.asOf != "" {
+		if _, err := time.Parse("2006-01", o.asOf); err != nil {
+			return fmt.Errorf("Invalid --as-of %s, expected YYYY-MM", o.asOf)
+		}
+	}
+
+	if o.resample != "" && resamplePeriods[o.resample] == nil {
+		return fmt.Errorf("Couldn't find resample period %s", o.resample)
+	}
+	if resampleMethods[o.resampleBy] == nil {
+		return fmt.Errorf("Couldn't find resample method %s", o.resampleBy)
+	}
+
+	if o.real {
+		cpi, err := loadCPI(o.cpiPath)
+		if err != nil {
+			return err
+		}
+		o.cpi = cpi
+	}
+
+	mortgage, err := newMortgage(o.rate, o.down, o.term)
+	if err != nil {
+		return err
+	}
+	o.mortgage = mortgage
+
+	if o.taxPath != "" {
+		tax, err := loadTax(o.taxPath)
+		if err != nil {
+			return err
+		}
+		o.tax = tax
+	}
+
+	if o.rankSince != "" {
+		ranks, err := readSizeRanks(o.rankSince)
+		if err != nil {
+			return err
+		}
+		o.rankBaseline = ranks
+	}
+
+	if o.incomePath != "" {
+		income, err := loadIncome(o.incomePath)
+		if err != nil {
+			return err
+		}
+		o.income = income
+	}
+
+	if o.nbhdZipPath != "" {
+		zips, err := loadNeighborhoodZips(o.nbhdZipPath)
+		if err != nil {
+			return err
+		}
+		o.nbhdZips = zips
+	}
+
+	if o.invtPath != "" {
+		inventory, err := loadSupply(o.invtPath, o.asOf)
+		if err != nil {
+			return err
+		}
+		o.inventory = inventory
+	}
+	if o.domPath != "" {
+		days, err := loadSupply(o.domPath, o.asOf)
+		if err != nil {
+			return err
+		}
+		o.daysOnMarket = days
+	}
+
+	if o.expenseRatio < 0 || o.expenseRatio >= 100 {
+		return fmt.Errorf("--expense-ratio expects a percent from 0 to 100, got %v", o.expenseRatio)
+	}
+	if o.rent != "" {
+		o.join = append(o.join, rentJoin+"="+o.rent)
+	}
+	o.vocabulary = &vocabulary{}
+	joins, err := parseJoins(o.join, o.vocabulary)
+	if err != nil {
+		return err
+	}
+	o.joins = joins
+	if o.byHomeType {
+		joins, err := homeTypeJoins(o.vocabulary)
+		if err != nil {
+			return err
+		}
+		o.homeTypeJoins = joins
+	}
+
+	definitions, err := parseDefinitions(o.defines, o.vocabulary)
+	if err != nil {
+		return err
+	}
+	o.definitions = definitions
+
+	if o.score != "" {
+		scoreExpr, err := parseExpr(o.score, o.vocabulary)
+		if err != nil {
+			return err
+		}
+		o.scoreExpr = scoreExpr
+	}
+	if o.sort == "" {
+		o.sort = "GrowthRate"
+		if o.score != "" {
+			o.sort = "Score"
+		}
+	}
+
+	if o.heatmapBy != "zip" && o.heatmapBy != "county" {
+		return fmt.Errorf("--heatmap-by expects zip or county, got %s", o.heatmapBy)
+	}
+
+	if o.series && seriesFormats[o.format] == nil {
+		return fmt.Errorf("--series can't be combined with --format %s, use csv or json", o.format)
+	}
+
+	if o.fields != "" {
+		if o.template != "" {
+			return fmt.Errorf("--fields can't be combined with --template")
+		}
+
+		columns, err := parseFields(o.fields, o.vocabulary)
+		if err != nil {
+			return err
+		}
+		o.columns = columns
+	}
+
+	if o.zips != "" {
+		zipList, err := readZipList(o.zips)
+		if err != nil {
+			return err
+		}
+		o.zipList = zipList
+	}
+
+	o.precise = o.precision >= 0
+	if o.priceFormat != "" && priceFormats[o.priceFormat] == nil {
+		return fmt.Errorf("Couldn't find price format %s", o.priceFormat)
+	}
+
+	if o.sample < 0 || o.sample > 1 {
+		return fmt.Errorf("Invalid --sample %v, expected a fraction between 0 and 1", o.sample)
+	}
+	if o.seed == 0 {
+		o.seed = time.Now().UnixNano()
+	}
+
+	if o.excludeFile != "" {
+		exclusions, err := readExclusions(o.excludeFile, o.vocabulary)
+		if err != nil {
+			return err
+		}
+		o.exclusions = exclusions
+	}
+
+	merges, err := parseMerges(o.merge)
+	if err != nil {
+		return err
+	}
+	o.merges = merges
+
+	dedupeWins, err := parseDedupe(o.dedupe)
+	if err != nil {
+		return err
+	}
+	o.dedupeWins = dedupeWins
+
+	if o.exportSheet != "" && o.series {
+		return fmt.Errorf("--export-sheet can't be combined with --series")
+	}
+
+	if o.template != "" {
+		if o.format != "text" {
+			return fmt.Errorf("--template can't be combined with --format %s", o.format)
 		}
 
-		i++
+		tmpl, err := template.New("template").Parse(o.template)
+		if err != nil {
+			return err
+		}
+		o.tmpl = tmpl
 	}
 
-	return nil, -1, fmt.Errorf("Unfinished tokens")
+	o.color = !o.noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)
+	o.progress = !o.quiet && isTerminal(os.Stderr)
+	return nil
+}
+
+// parseArgs parses flags that may appear anywhere between the positional
+// arguments and returns the positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	fs.Usage = func() { usage(fs.Name()) }
+	positional, err := parseFlags(fs, args)
+	must(err)
+	return positional
+}
+
+// parseFlags is parseArgs returning the error of a bad flag.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, usageError(err)
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
 }
 
 func help() {
+	fmt.Println()
+	for i, c := range commands {
+		if !c.hidden {
+			printUsage(c, i == 0)
+		}
+	}
 	fmt.Printf(`
-Usage: ./zhiquery <dataset_dir> [ <kind_1>:<arg_1> or/and <kind_2>:<arg_2> or/and [ <kind_n>:<arg_n> ... ]]
+Every file of a dataset_dir is a Zillow csv export, optionally compressed
+with gzip or zstd, e.g. 3-bedrooms.csv.gz. A dataset_dir can also be an
+http(s) directory listing or an s3 prefix, e.g. s3://bucket/zillow. S3
+requests are signed with $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY, and
+$AWS_SESSION_TOKEN in $AWS_REGION, $AWS_ENDPOINT_URL points to S3
+compatible stores. Remote files are streamed, or kept in $ZHIQUERY_CACHE
+and only downloaded again once they change. $ZHIQUERY_REPOSITORY can list
+several dataset_dirs separated by colons, like --data.
+
+Files of other schemas, e.g. older exports or third party csvs, are read
+with the layouts of an optional .zhiquery.json in the dataset_dir, which
+map file name patterns to their region, regionType, sizeRank, state, city,
+metro, county, and first month columns, by header or index from 0, e.g.
+{"layouts": [{"pattern": "zips_*.csv", "region": "zip", "state": 1,
+"months": 4}]}. Their months are still written like 2006-01.
+
+Near, BBox, Adjacent, and the geojson and heatmap-svg formats locate zip
+codes with the census tables of geodata/, written by go generate. They're
+empty unless generated before building, then $ZHIQUERY_ZIPS must name a
+zip,lat,lng csv of zip code centroids, or these filters and formats fail, and
+$ZHIQUERY_BOUNDARIES a Kind,GEOID,Rings csv of boundaries, or heatmap-svg
+fails.
+
+A local dataset_dir can also keep dated snapshots of the exports in
+subdirectories named by month or day, e.g. zhvi/2023-01/ and zhvi/2023-06/.
+Queries read the latest snapshot unless --snapshot picks another one, or
+--all-snapshots tracks the zip codes across all of them. The snapshots
+share the .zhiquery.json of the dataset_dir unless they have their own.
 
+Repeated queries of a large local dataset_dir are faster once ingest has
+converted its files to Parquet files, see ingest.
+
+Commands:
+`)
+	for _, c := range commands {
+		if !c.hidden {
+			fmt.Printf("  * %s\n%s\n", c.name, c.description)
+		}
+	}
+	fmt.Printf(`
 Kinds and Arguments:
+  The names of Dataset, State, County, City, and Neighborhood match whatever
+  their case, accents, and spacing, e.g. County:'Dona Ana County' matches
+  Doña  Ana County
 	* Dataset:
 	  * arg_1: exact match dataset (string)
+	* Repository:
+	  * arg_1: exact match dataset_dir of --data (string)
+  * Snapshot:
+    * arg_1: exact match snapshot directory (string), e.g. Snapshot:2023-06
+      with --all-snapshots
   * State:
-    * arg_1: exact match state (string)
+    * arg_1: exact match state (string), as its abbreviation or name in any
+      case, e.g. CA, California, or Calif. Datasets using either form match,
+      states are printed as their abbreviation
   * County
-    * arg_1: exact match county (string)
+    * arg_1: exact match county (string), or ~= followed by a county to
+      match similar names, e.g. County:~=Alamda. Names match whatever their
+      case, punctuation, County suffix, and abbreviations like St. for
+      Saint, with about one typo every 5 letters
+  * Fips
+    * arg_1: exact match 5 digit county FIPS code, e.g. Fips:06075. Only
+      newer exports with StateCodeFIPS and MunicipalCodeFIPS columns have
+      them
   * City
-    * arg_1: exact match city (string)
+    * arg_1: exact match city (string), or ~= followed by a city to match
+      similar names like County, e.g. City:~=Pittsburg. When a City,
+      County, or Neighborhood filter matches no zip code, the closest names
+      are suggested
+  * Neighborhood
+    * arg_1: exact match neighborhood (string), or ~= followed by a
+      neighborhood to match similar names like County. Only the rows of
+      neighborhood datasets have one
+  * HomeType
+    * arg_1: exact match home type of the dataset (string): sfr, condo, or
+      new-construction when its name has the words sfr, condo, or new_con
+      like Zillow's exports, e.g. Zip_zhvi_uc_condo_month.csv, and all
+      otherwise
   * GrowthRate
     * arg_1: lower bound growth rate (float)
   * Price
     * arg_1: upper bound price (float)
+  * PriceMin
+    * arg_1: lower bound price (float), e.g. PriceMin:100000 to leave out
+      the cheapest markets
   * ZipCode
     * arg_1: exact match zip code (unsigned integer)
+  * ZipPrefix
+    * arg_1: leading digits of the 5 digit zip code, e.g. ZipPrefix:945 for
+      the USPS sectional center of the East Bay
+  * Near
+    * arg_1: reference zip code (unsigned integer)
+    * arg_2: distance from the reference zip code centroid, in miles by
+      default or suffixed with mi or km, e.g. Near:94110:25mi
+  * BBox
+    * arg_1: min latitude,min longitude,max latitude,max longitude of the
+      zip code centroid, e.g. BBox:37.2,-122.6,38.0,-121.7
+  * Adjacent
+    * arg_1: reference zip code (unsigned integer)
+    * arg_2: optional number of neighbor rings to include (default: 1), the
+      neighbors of a zip code are its 6 nearest zip code centroids
+  * RelGrowth
+    * arg_1: comparison operator (>=, <=, !=, >, <, =) followed by the
+      growth rate relative to the benchmark in percentage points (float),
+      e.g. RelGrowth:>0
+  * RealGrowthRate
+    * arg_1: comparison operator followed by the growth rate deflated by the
+      CPI (float), needs --real, e.g. RealGrowthRate:>=2
+  * Affordability
+    * arg_1: comparison operator followed by the price to the median
+      household income ratio (float), needs --income, e.g.
+      Affordability:<=4. Zip codes without an income never match
+  * Payment
+    * arg_1: comparison operator followed by the estimated monthly payment
+      (float), needs --rate, e.g. Payment:<=2500
+  * Yield, CapRate
+    * arg_1: comparison operator followed by the gross rental yield, the
+      yearly rent over the price, or the cap rate, the yield left after
+      --expense-ratio, in percent (float), needs --rent, e.g. Yield:>=6.
+      Zip codes without a rent never match
+  * Tax
+    * arg_1: comparison operator followed by the estimated yearly property
+      tax (float), needs --tax, e.g. Tax:<=6000. Zip codes of counties
+      without a rate never match
+  * Inventory
+    * arg_1: comparison operator followed by the for-sale inventory (float)
+      of the smallest region of --inventory holding the zip code, e.g.
+      Inventory:<=500
+  * DaysOnMarket
+    * arg_1: comparison operator followed by the median days to pending
+      (float) of the smallest region of --days-on-market holding the zip
+      code, e.g. DaysOnMarket:<=30. Zip codes without a region in the table
+      never match either
+  * RankDelta
+    * arg_1: comparison operator followed by the places the zip code climbed
+      in SizeRank since --rank-since (int), e.g. RankDelta:>=50. Negative
+      when it fell, zip codes without an older rank never match
+  * Quality
+    * arg_1: comparison operator followed by the data quality of the price
+      history (float), from 0 to 1, e.g. Quality:>=0.8. The share of 10
+      years the history covers times the share of its months since its
+      first value that aren't missing, so that a high growth rate computed
+      from 18 months of prices isn't mistaken for a long track record
+  * Acceleration
+    * arg_1: comparison operator followed by the yearly growth rate of the
+      last 6 months minus the one of the 6 months before (float), in
+      percentage points, e.g. Acceleration:>=2 for zip codes whose growth
+      speeds up. It catches inflection points months before YoY turns, 0
+      with less than a year of prices, see --acceleration-months
+  * MissingPct
+    * arg_1: comparison operator followed by the share of the months of the
+      dataset without a price (float), in percent, e.g. MissingPct:<=10.
+      Counted before --interpolate fills them, including the months before
+      the history starts
+  * Growth5Y, Growth10Y
+    * arg_1: comparison operator followed by the yearly growth rate over
+      the last 5 or 10 years (float), e.g. Growth5Y:>=6
+  * GrowthPct, PricePct, GrowthStatePct, PriceStatePct
+    * arg_1: comparison operator followed by the percentile rank (0-100) of
+      the growth rate or price within the zip codes matching the query
+      without percentile filters, or within the zip codes of the same state,
+      e.g. GrowthStatePct:>=90 for the top decile of each state
+  * Score
+    * arg_1: comparison operator followed by the --score value (float)
+
+Flags:
+  * --sort <kind>
+    * sort results ascending by Dataset, Repository, Snapshot, ZipCode,
+      Neighborhood, HomeType, City, State, County, GrowthRate, RealGrowthRate,
+      Income, Affordability, Payment, Yield, CapRate, Tax, Inventory,
+      DaysOnMarket, SizeRank, RankDelta, Years, YoY, Volatility, Drawdown,
+      Quality, Acceleration, MissingPct, RelGrowth, GrowthPct, PricePct,
+      GrowthStatePct, PriceStatePct, Score, Growth5Y, Growth10Y, or Price
+      (default: Score with --score, GrowthRate otherwise)
+  * --limit <n>
+    * only print the first n results in the order of --sort. Unless the
+      query, --sort, --score, --define, or --dedupe depend on the whole
+      result set, only the first n matches of each dataset are kept while
+      parsing, instead of every match
+  * --format <format>
+    * text, json, csv, geojson, or heatmap-svg (default: text). geojson
+      emits one point per zip code centroid, taken from $ZHIQUERY_ZIPS
+      (zip,lat,lng csv), which must be set unless go generate filled
+      geodata/zips.csv before building. heatmap-svg
+      draws a map of the matches of a single state, select it with State,
+      colored from red to green by GrowthRate. The maps take their shapes
+      from $ZHIQUERY_BOUNDARIES (Kind,GEOID,Rings csv, see
+      gen_boundaries.go), which must be set unless go generate filled
+      geodata/boundaries.csv before building, and draw the zip codes without
+      a boundary as dots at their centroid
+  * --heatmap-by <zip|county>
+    * the regions of --format heatmap-svg, counties are colored by the mean
+      GrowthRate of their zip codes (default: zip)
+  * --precision <digits>
+    * print decimal numbers with that many digits after the point, e.g.
+      --precision 2 prints GrowthRate 3.28 instead of 3.2835034601119473
+      (default: as many as needed to be exact)
+  * --price-format <format>
+    * raw (350000), comma (350,000), or short (350k, 1.2M), for Price,
+      Income, Payment, and Tax (default: comma in the text output without
+      --fields, raw otherwise). Formatted prices are strings in json
+  * --benchmark <benchmark>
+    * average growth rate RelGrowth is relative to: us (all zip codes of the
+      same dataset), state (all zip codes of the same dataset and state), or
+      matched (the zip codes matching the query without RelGrowth filters)
+      (default: us)
+  * --no-sparkline
+    * don't draw the price history sparkline in the text output
+  * --template <template>
+    * print each result with a text/template over its fields instead, e.g.
+      --template '{{.ZipCode}} {{.City}} {{.GrowthRate}} {{.Price}}'
+  * --no-color
+    * don't color the text output. Growth rates are green from 5, yellow from
+      2, and red below, prices are green below 300,000, yellow below 700,000,
+      and red above. Colors are also disabled when the output isn't a
+      terminal or $NO_COLOR is set
+  * --quiet
+    * don't report the parsing progress on stderr. Progress is only reported
+      when stderr is a terminal
+  * -v, -vv
+    * log parsing progress, timings, and match counts (-v), and debugging
+      details (-vv) on stderr. Only warnings are logged by default
+  * --log-format <format>
+    * text or json (default: text)
+  * --timings
+    * print on stderr how long parsing each dataset, filtering rows,
+      computing the metrics depending on the whole result set, sorting, and
+      printing took. Datasets are parsed in parallel, so the stages can add
+      up to more than the total
+  * --cpuprofile <file>, --memprofile <file>
+    * write a CPU profile of the query, or a heap profile once it's done, to
+      the file, e.g. for go tool pprof. Also apply to batch
+  * --as-of <YYYY-MM>
+    * compute the price and every metric as if the datasets ended that month
+  * --series
+    * print the monthly price history of the matching zip codes instead, one
+      Dataset,ZipCode,Month,Value record per month with a value. Needs
+      --format csv or json
+  * --resample <period>
+    * quarterly or yearly, downsample the price histories before computing
+      the metrics and printing --series
+  * --resample-by <method>
+    * last (the last value of each period) or mean (default: last)
+  * --interpolate <method>
+    * fill the missing months of each price history before computing the
+      metrics and printing --series: linear (along the line between the
+      prices around each gap), ffill (with the last price before each gap),
+      or none (default: none). Both carry the last price forward over the
+      missing months at the end of a history, and leave the ones before its
+      first price missing. Without filling, Volatility and Drawdown skip the
+      gaps, YoY is 0 when the price of a year before is missing, and a
+      history missing its latest price loses all of its growth rate. Quality
+      counts the filled months as prices
+  * --acceleration-months <n>
+    * the months of each of the two periods Acceleration compares (default:
+      6), rounded down to whole quarters once resampled by quarter
+  * --seasonally-adjusted
+    * divide the prices by the seasonal factor of their month, or quarter
+      once resampled, before computing the metrics, so that the spring and
+      summer bumps don't show up in YoY, Volatility, or the growth of the
+      last years. The factors are the mean ratios of the prices to their
+      centered yearly moving average, histories shorter than 3 years aren't
+      adjusted. Price, History, and --series are adjusted too
+  * --real
+    * also compute RealGrowthRate, the growth rate of the price histories
+      deflated by the CPI. The embedded CPI table holds the annual averages
+      of CPI-U, the latest year is used for months past its end
+  * --cpi <file>
+    * read the CPI table for --real from a Month,CPI csv instead, where
+      Month is YYYY-MM or YYYY
+  * --income <file>
+    * read the median household income per zip code from a ZipCode,Income
+      csv, or from an export of the census table B19013 on data.census.gov,
+      and compute Income and Affordability, the price to income ratio
+  * --neighborhood-zips <file>
+    * read the zip code of each neighborhood from a RegionID,ZipCode csv.
+      The rows of neighborhood datasets, whose RegionType is neighborhood,
+      are named by their RegionName and match the City, State, and County
+      filters of their parent city. Their ZipCode is 0 without the table
+  * --include-missing
+    * keep the zip codes whose latest price is missing, which are left out
+      of the results otherwise. Their Price is 0
+  * --rollup
+    * roll the matching neighborhoods of each city up into one row per
+      dataset, whose prices are the mean of theirs month by month
+  * --by-home-type
+    * merge the results of the same zip code in the series of each home
+      type of an export, whose dataset names only differ by their home
+      type, into one row with the fields AllHomes, SFR, Condo, and
+      NewConstruction for the latest price of each home type, suffixed
+      with GrowthRate and YoY for their growth rate and YoY, e.g.
+      --by-home-type --fields zip,AllHomes,Condo,CondoGrowthRate. The row
+      is the all homes one when matched. The fields can be sorted by and
+      printed, but not filtered on
+  * --rate <percent>
+    * estimate Payment, the monthly principal and interest payment of a
+      fixed rate mortgage at the yearly interest rate for the latest price,
+      with --down <percent> (default: 20) down and a --term <years>
+      (default: 30) loan, e.g. --rate 6.5 '[ Payment:<=2500 ]'. Includes
+      the property tax with --tax
+  * --rent <dataset>
+    * join the rents of dataset of the dataset_dir, e.g. a ZORI export of
+      Zillow's typical monthly rents, like --join Rent=<dataset>, and
+      compute Yield and CapRate from its latest rent and the latest price.
+      A --join named Rent computes them too
+  * --expense-ratio <percent>
+    * the share of the rent taken by taxes, insurance, maintenance, and
+      vacancies, which CapRate takes out of Yield (default: 40)
+  * --tax <file>
+    * read the effective yearly property tax rate in percent per county from
+      a csv with a Rate column and a Fips column, or State and County
+      columns, and estimate Tax, the yearly tax at the latest price, and
+      TaxRate. County names match with or without their County suffix
+  * --inventory <file>
+    * read the for-sale inventory from a Zillow inventory export, e.g.
+      Metro_invt_fs_uc_sfrcondo_month.csv, and set Inventory to the latest
+      value up to --as-of of the smallest region holding each zip code: its
+      zip code, city, county, metro (matched by its first principal city
+      and state), state, or the country
+  * --days-on-market <file>
+    * likewise read DaysOnMarket from a Zillow median days to pending
+      export, e.g. Metro_med_doz_pending_uc_sfrcondo_month.csv
+  * --rank-since <dataset_dir>
+    * compute RankDelta, the places each zip code climbed in Zillow's
+      SizeRank since an older snapshot of the datasets, matched by dataset
+      name first. Positive when the zip code grew relative to the others
+  * --score <expression>
+    * compute a Score per zip code by combining numbers and the numeric fields
+      (ZipCode, GrowthRate or growth, RealGrowthRate, Income, Affordability,
+      Payment, Yield, CapRate, Tax, TaxRate, Inventory, DaysOnMarket,
+      SizeRank, RankDelta, Years, YoY, Volatility, Drawdown, Quality,
+      Acceleration, MissingPct, RelGrowth, GrowthPct, PricePct,
+      GrowthStatePct, PriceStatePct, Growth5Y, Growth10Y, Price) with + - * /
+      and parentheses, and sort by it, e.g.
+      --score 'growth*0.5 + yoy*0.3 - volatility*0.2'
+  * --define <name>=<expression>
+    * compute a field with the same expressions as --score, it can be used
+      by later definitions and --score, as a filter kind taking a comparison
+      operator, as a sort key, in the output, and in --template as
+      {{.Defined.<name>}}. Can be repeated, e.g.
+      --define 'momentum=yoy-growth' [ momentum:>0 ] --sort momentum
+  * --dedupe <policy>
+    * keep one row per zip code when it's in several datasets: latest (the
+      dataset ending last, then the first by name), prefer:<dataset> (the
+      dataset if it has the zip code, latest otherwise), or all (default:
+      all). The other datasets are listed as Duplicates
+  * --merge <older_dataset>,<newer_dataset>
+    * compute the metrics over the price histories of both datasets as one
+      continuous series named after the newer dataset, for exports covering
+      different, possibly overlapping, months. The newer values are kept
+      where both have one, the older values are scaled to meet the newer
+      ones in the first month both have a value, since Zillow rebases its
+      series between exports, and months between them are missing. Zip
+      codes only in the older dataset are dropped. Can be repeated
+  * --join <name>=<dataset>
+    * add the latest value, growth rate, and YoY of the row of dataset with
+      the same zip code to every row as the fields <name>, <name>GrowthRate,
+      and <name>YoY, 0 when the zip code is missing from dataset, e.g.
+      --join Rent=zori.csv '[ RentYoY:>=5 ]' with ZORI rents next to ZHVI
+      exports. They can be filtered with a comparison, sorted by, used in
+      --score and --define, and printed. The joined dataset isn't queried on
+      its own. Can be repeated
+  * --explain
+    * print how the query was parsed instead of running it, one filter or
+      operator per line, indented under the operator combining it. The
+      filters of a group are combined left to right, so
+      [ State:CA and GrowthRate:5 or Price:300000 ] matches zip codes in CA
+      with a growth rate of at least 5, or costing at most $300,000 in any
+      state
+  * --validate
+    * check the query and the flags without running it. Unknown filter
+      kinds, invalid arguments, missing operators, and unbalanced brackets
+      are reported with the position of the token at fault, and exit with 2
+  * --fields <field_1>,<field_2>,...
+    * only output these columns, in this order, matched case insensitively,
+      with zip for ZipCode and growth for GrowthRate, e.g.
+      --fields zip,city,growth,price. The text format prints a table with
+      one line per zip code instead
+  * --count
+    * only print the number of matching zip codes
+  * --count-by <field>
+    * print the number of matching zip codes per value of the field instead,
+      e.g. --count-by Dataset or --count-by State
+  * --zips <file>
+    * only match the zip codes listed in the file, one per line, or read
+      from stdin with -. The query is optional with --zips, e.g.
+      cut -d, -f3 mls.csv | ./zhiquery dataset --zips - [ GrowthRate:5 ]
+  * --exclude-file <file>
+    * always drop the zip codes matching a line of the file, whatever the
+      query. Each line is a zip code or a filter, e.g. State:CA or
+      County:San Mateo County, filters on values computed from the whole
+      result set like RelGrowth aren't supported. Blank lines and lines
+      starting with # are skipped
+  * --data <dataset_dir>
+    * query the datasets of every dataset_dir instead of a single one, can
+      be repeated or list dataset_dirs separated by colons, e.g.
+      --data zhvi/2024-01:zori/2024-01. Results are tagged with their
+      dataset_dir in the Repository field. Replaces the dataset_dir argument,
+      and $ZHIQUERY_REPOSITORY for run, alert, and batch
+  * --snapshot <yyyy-mm>
+    * query the snapshot directory of every dataset_dir, e.g. 2023-06 or
+      2023-06-15, instead of the latest one. dataset_dirs without snapshots
+      can't be queried with it
+  * --all-snapshots
+    * query every snapshot the dataset_dirs share, from the oldest to the
+      latest, and print the rows of each zip code together in the order of
+      their snapshots, with its metrics as of each snapshot. The zip codes
+      are in the order of --sort of their latest row, and --limit counts
+      zip codes. Can't be used with --count or --count-by
+  * --sample <fraction>
+    * only parse a random fraction of the rows of every dataset, e.g.
+      --sample 0.1, for quick approximate answers on huge dataset_dirs.
+      --count and --count-by scale their counts to the whole datasets, and
+      percentiles and benchmarks are computed over the sample. Datasets of
+      --join are always parsed whole
+  * --seed <int>
+    * seed of --sample, the same seed samples the same rows (default:
+      random)
+  * --export-sheet <spreadsheet_id>
+    * replace the content of a tab of the Google Sheet with the columns of
+      the csv format instead of printing the results. Authenticates with the
+      OAuth access token in $ZHIQUERY_SHEETS_TOKEN, e.g. from
+      gcloud auth print-access-token, or with the service account key file
+      in $GOOGLE_APPLICATION_CREDENTIALS, which needs edit access to the
+      spreadsheet
+  * --sheet-tab <name>
+    * tab of --export-sheet to write to (default: Sheet1)
+  * --chart <file>
+    * draw the price histories of the results as a line chart to an .svg or
+      .png file instead of printing the results, e.g.
+      --chart austin.svg --limit 5. Only the first 10 results in the order
+      of --sort are charted. PNG charts have no title and their legend only
+      shows the zip codes
+  * --report <file>
+    * write a self-contained HTML report of the results instead of printing
+      them: the query, summary statistics, a table of the --fields (default:
+      ZipCode, City, State, County, Dataset, GrowthRate, YoY, Years, Price)
+      sortable by clicking its headers, and the price history chart of each
+      of the first 5 results
+  * --record <file>
+    * also append the run to a SQLite database, created on the first run,
+      to follow how the results of a query evolve as datasets are
+      refreshed: a row in the runs table with the time of the run, the
+      query, --sort, and the number of matches, and a row per result in
+      the matches table with its rank, zip code, names, main metrics, and
+      price. Runs the sqlite3 command line shell, or $ZHIQUERY_SQLITE
+  * --errors <format>
+    * text or json (default: text). json prints errors on stderr as
+      {"error": <message>, "kind": <kind>, "code": <exit code>} objects,
+      with the 1-based "token" of the query for query errors
+
+Exit Codes:
+  * 0: the query matched zip codes, or the command succeeded
+  * 1 (error): any other error
+  * 2 (usage): an invalid query, flag, or argument
+  * 3 (no_matches): the query matched no zip code
+  * 4 (dataset): a dataset_dir or dataset file couldn't be read
 `)
 }
 
-func main() {
-	if len(os.Args) < 2 {
-		help()
-		return
+// parseMonths turns the date columns of a dataset header into YYYY-MM.
+func parseMonths(header []string, l layout) []string {
+	if len(header) <= l.months {
+		return nil
 	}
 
-	repository := os.Args[1]
-	datasets, err := ioutil.ReadDir(repository)
-	must(err)
+	months := make([]string, len(header)-l.months)
+	for i, column := range header[l.months:] {
+		if len(column) > 7 {
+			column = column[:7]
+		}
+		months[i] = column
+	}
+	return months
+}
 
-	filter, _, err := parseFilters(os.Args[2:])
-	must(err)
+func load(repository string, filter FilterFn) []Data {
+	return loadWith(repository, filter, options{}, nil)
+}
 
+// loadWith is like load but also passes every parsed row, matching or not,
+// to observe. observe is called concurrently.
+func loadWith(repository string, filter FilterFn, opts options, observe func(*Data)) []Data {
+	datasets, opts, err := listQueried(repository, opts)
+	must(err)
+	loaded, err := loadDatasets(datasets, filter, opts, observe)
+	must(err)
 	var datas []Data
-	var mu sync.Mutex
+	for _, datasetDatas := range loaded {
+		datas = append(datas, datasetDatas...)
+	}
+	return datas
+}
+
+// repositoryDataset is a dataset file of one of the repositories.
+type repositoryDataset struct {
+	repository string
+	name       string
+}
+
+// path is the key of the dataset in the fingerprints of its repository.
+func (d repositoryDataset) path() string {
+	return d.repository + "/" + d.name
+}
+
+// listQueried lists the datasets of repository a query runs against, and
+// returns opts with the rows of its joins.
+func listQueried(repository string, opts options) ([]repositoryDataset, options, error) {
+	repositories, err := queriedRepositories(repository, opts.snapshot)
+	if err != nil {
+		return nil, opts, datasetError(err)
+	}
+	// older datasets of --merge and datasets of --join aren't queried
+	skipped, names := map[string]bool{}, map[string]bool{}
+	for _, older := range opts.merges {
+		skipped[older] = true
+	}
+	joins := map[string]repositoryDataset{}
+	for _, j := range opts.joins {
+		skipped[j.dataset] = true
+	}
+
+	var datasets []repositoryDataset
+	for _, repository := range repositories {
+		listed, err := listDatasets(repository)
+		if err != nil {
+			return nil, opts, datasetError(err)
+		}
+
+		for _, dataset := range listed {
+			names[dataset] = true
+			if _, ok := joins[dataset]; !ok && isJoined(opts.joins, dataset) {
+				joins[dataset] = repositoryDataset{repository, dataset}
+			}
+			if !skipped[dataset] {
+				datasets = append(datasets, repositoryDataset{repository, dataset})
+			}
+		}
+	}
+	for newer := range opts.merges {
+		if !names[newer] {
+			return nil, opts, datasetError(fmt.Errorf("Couldn't find dataset %s to merge", newer))
+		}
+	}
+	joined := map[string]map[uint64]*Data{}
+	// joined datasets are never sampled, every row may be looked up
+	joinOpts := opts
+	joinOpts.sample = 0
+	joinOpts.pushdown = nil
+	for _, j := range opts.joins {
+		found, ok := joins[j.dataset]
+		if !ok {
+			return nil, opts, datasetError(fmt.Errorf("Couldn't find dataset %s to join", j.dataset))
+		}
+		rows, err := loadDataset(found.repository, found.name, matchAll, joinOpts, nil, newProgress(0, false))
+		if err != nil {
+			return nil, opts, err
+		}
+		joined[j.name] = indexJoined(rows)
+	}
+	opts.joined = joined
+	opts.tagRepository = len(repositories) > 1
+	return datasets, opts, nil
+}
+
+// loadDatasets parses the datasets concurrently, returning the rows of
+// each dataset in the order of datasets, or the error of the first dataset
+// that couldn't be parsed. Errors are returned rather than exiting from the
+// goroutines, where the library builds couldn't recover them.
+func loadDatasets(datasets []repositoryDataset, filter FilterFn, opts options, observe func(*Data)) ([][]Data, error) {
+	p := newProgress(len(datasets), opts.progress)
+	defer p.stop()
+
+	datas := make([][]Data, len(datasets))
+	errs := make([]error, len(datasets))
 	var wg sync.WaitGroup
 
 	wg.Add(len(datasets))
-	for _, dataset := range datasets {
-		dataset := dataset
+	for i, dataset := range datasets {
+		i, repository, dataset := i, dataset.repository, dataset.name
 		go func() {
-			var datasetDatas []Data
-			f, err := os.Open(path.Join(repository, dataset.Name()))
-			must(err)
-			defer f.Close()
-
-			scanner := bufio.NewScanner(f)
-			// ignore header
-			scanner.Scan()
-
-			for scanner.Scan() {
-				var data Data
-
-				line := scanner.Text()
-
-				// RegionID,SizeRank,RegionName,RegionType,StateName,State,City,Metro,CountyName,...
-				fields := strings.Split(line, ",")
-
-				data.Dataset = dataset.Name()
-				data.City = fields[6]
-				data.State = fields[5]
-				data.County = fields[8]
-				zipCode, err := strconv.ParseUint(fields[2], 10, 64)
-				must(err)
-				data.ZipCode = zipCode
-
-				zhis := fields[9:]
-				for _, zhi := range zhis {
-					v, _ := strconv.ParseFloat(zhi, 64)
-					data.ZHIs = append(data.ZHIs, v)
+			datas[i], errs[i] = loadDataset(repository, dataset, filter, opts, observe, p)
+			wg.Done()
+		}()
+	}
+
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return datas, nil
+}
+
+// loadDataset parses the rows of a dataset file of repository, see
+// loadWith.
+func loadDataset(repository, dataset string, filter FilterFn, opts options, observe func(*Data), p *progress) ([]Data, error) {
+	var datasetDatas []Data
+	var scanner *bufio.Scanner
+	var header []string
+	cols := readColumns(repository, dataset)
+	if cols != nil {
+		defer cols.Close()
+		header = cols.header
+	} else {
+		f, err := openRepositoryDataset(repository, dataset)
+		if err != nil {
+			return nil, datasetError(err)
+		}
+		defer f.Close()
+		scanner = bufio.NewScanner(f)
+		scanner.Scan()
+		header = strings.Split(scanner.Text(), ",")
+	}
+
+	logger.Debug("Parsing dataset", "repository", repository, "dataset", dataset, "columnar", cols != nil)
+	start := time.Now()
+	rows, invalid, pruned := 0, 0, 0
+	snapshot := snapshotOf(repository)
+	// filtering is the time spent in filter, out of the parsing time
+	var filtering time.Duration
+	l, err := datasetLayout(repository, dataset, header)
+	if err != nil {
+		return nil, datasetError(err)
+	}
+	months := parseMonths(header, l)
+	var older *history
+	if olderName, ok := opts.merges[dataset]; ok {
+		older, err = readHistory(repository, olderName)
+		if err != nil {
+			return nil, datasetError(err)
+		}
+		months, err = older.splice(months)
+		if err != nil {
+			return nil, datasetError(err)
+		}
+		logger.Debug("Merging dataset", "dataset", dataset, "older", olderName, "months", len(months))
+	}
+	end := len(months)
+	if opts.asOf != "" {
+		end = sort.Search(len(months), func(i int) bool { return months[i] > opts.asOf })
+		if end == 0 {
+			logger.Warn("Skipping dataset starting after --as-of", "dataset", dataset, "as_of", opts.asOf)
+		}
+	}
+	months = months[:end]
+	resampler := newResampler(months, opts)
+	var deflators []float64
+	if opts.real {
+		deflators = opts.cpi.deflators(months)
+	}
+	sampled := newSampler(dataset, opts)
+	homeType, _ := homeTypeOf(dataset)
+
+	// add builds the row of fields, the columns before the months, with the
+	// prices of parse.
+	add := func(fields []string, parse func() []float64) {
+		var data Data
+
+		data.Dataset = dataset
+		data.HomeType = homeType
+		data.Snapshot = snapshot
+		if opts.tagRepository {
+			data.Repository = repository
+		}
+		data.City = fields[l.city]
+		data.State = normalizeState(fields[l.state])
+		data.County = fields[l.county]
+		data.Fips = l.fips(fields)
+		if l.sizeRank >= 0 && l.sizeRank < len(fields) {
+			rank, err := strconv.Atoi(fields[l.sizeRank])
+			data.SizeRank, data.hasSizeRank = rank, err == nil
+		}
+		if isNeighborhood(l, fields) {
+			data.Neighborhood = fields[l.zipCode]
+			data.ZipCode = opts.nbhdZips.zipCode(l, fields)
+		} else {
+			zipCode, err := strconv.ParseUint(fields[l.zipCode], 10, 64)
+			if err != nil {
+				logger.Warn("Skipping row with invalid zip code", "dataset", dataset, "row", rows, "zip_code", fields[l.zipCode])
+				return
+			}
+			data.ZipCode = zipCode
+		}
+
+		data.ZHIs = parse()
+		if older != nil {
+			data.ZHIs = older.spliceValues(data.ZipCode, data.ZHIs)
+		}
+		if len(data.ZHIs) > end {
+			data.ZHIs = data.ZHIs[:end]
+		}
+		data.MissingPct = missingPct(data.ZHIs)
+		// commands loading without options don't interpolate
+		if fill := interpolations[opts.interpolate]; fill != nil {
+			fill(data.ZHIs)
+		}
+		if opts.real {
+			real := make([]float64, len(data.ZHIs))
+			for i, v := range data.ZHIs {
+				real[i] = v * deflators[i]
+			}
+			data.RealGrowthRate, _ = calculateGrowthRate(resampler.resample(real), resampler.perYear)
+		}
+		data.ZHIs = resampler.resample(data.ZHIs)
+		if opts.seasonal {
+			data.ZHIs = seasonallyAdjust(data.ZHIs, resampler.perYear)
+		}
+		data.Months = resampler.labels
+		data.perYear = resampler.perYear
+		data.calculateMetrics()
+		opts.income.setIncome(&data)
+		opts.mortgage.setPayment(&data)
+		opts.tax.setTax(&data)
+		opts.rankBaseline.setRankDelta(&data)
+		if opts.inventory != nil || opts.daysOnMarket != nil {
+			var metro string
+			if l.metro < len(fields) {
+				metro = fields[l.metro]
+			}
+			data.Inventory = opts.inventory.lookup(&data, metro)
+			data.DaysOnMarket = opts.daysOnMarket.lookup(&data, metro)
+		}
+		data.join(opts.joined)
+		data.setYield(opts.expenseRatio)
+
+		if observe != nil {
+			observe(&data)
+		}
+		filterStart := time.Now()
+		matched := filter(&data)
+		filtering += time.Since(filterStart)
+		if matched {
+			datasetDatas = append(datasetDatas, data)
+		}
+		p.row(matched)
+	}
+
+	if cols != nil && end > 0 {
+		// the growth rates of column files are those of the plain prices,
+		// which the rows of observed datasets are only pruned with
+		var tree *filterNode
+		prices := plainPrices(dataset, opts)
+		if observe == nil || prices {
+			tree = opts.pushdown
+		}
+		read, err := cols.readRows(l, tree, prices, observe != nil)
+		if err != nil {
+			return nil, datasetError(err)
+		}
+		next := 0
+		for line := 1; line <= cols.lines; line++ {
+			rows++
+			var row *columnRow
+			if next < len(read) && read[next].line == line {
+				row = &read[next]
+				next++
+			}
+			if sampled != nil && !sampled() || row == nil {
+				continue
+			}
+
+			if row.pruned {
+				if observe != nil {
+					row.data.Dataset = dataset
+					observe(&row.data)
 				}
-				data.GrowthRate, data.Years = calculateGrowthRate(data.ZHIs)
+				pruned++
+				p.row(false)
+				continue
+			}
+			add(row.fields, func() []float64 { return row.values })
+		}
+		logger.Debug("Pruned rows of column file", "dataset", dataset, "rows", pruned)
+	}
+	for scanner != nil && end > 0 && scanner.Scan() {
+		line := scanner.Text()
+		rows++
+		if sampled != nil && !sampled() {
+			continue
+		}
 
-				if filter(&data) {
-					datasetDatas = append(datasetDatas, data)
+		fields := strings.Split(line, ",")
+		if len(fields) <= l.months {
+			logger.Warn("Skipping row with missing columns", "dataset", dataset, "row", rows, "columns", len(fields))
+			continue
+		}
+		add(fields[:l.months], func() []float64 {
+			var values []float64
+			for _, zhi := range fields[l.months:] {
+				v, err := strconv.ParseFloat(zhi, 64)
+				if err != nil && zhi != "" {
+					invalid++
 				}
+				values = append(values, v)
 			}
+			return values
+		})
+	}
+	if scanner != nil {
+		if err := scanner.Err(); err != nil {
+			logger.Warn("Stopped reading dataset", "dataset", dataset, "row", rows, "error", err)
+		}
+	}
+	if invalid > 0 {
+		logger.Warn("Treated invalid values as missing", "dataset", dataset, "values", invalid)
+	}
+	p.file()
+	logger.Info("Parsed dataset", "dataset", dataset, "rows", rows, "matches", len(datasetDatas), "duration", time.Since(start))
+	timings.add("parse "+dataset, time.Since(start)-filtering)
+	timings.add("filter", filtering)
 
-			mu.Lock()
-			datas = append(datas, datasetDatas...)
-			mu.Unlock()
-			wg.Done()
-		}()
+	return datasetDatas, nil
+}
+
+// matchTree returns the rows of repository matching tree in the order of
+// --sort, up to --limit.
+func matchTree(repository string, tree *filterNode, opts options) ([]Data, error) {
+	opts.pushdown = tree
+	if opts.allSnapshots {
+		return searchSnapshots(repository, tree.filter, opts)
+	}
+	if canSearchTop(tree, opts) {
+		return searchTop(repository, tree, opts)
 	}
 
-	wg.Wait()
-	sort.Sort(SortableData(datas))
-	for _, data := range datas {
-		fmt.Println(&data)
+	matched, err := search(repository, tree.filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.byHomeType {
+		matched = byHomeType(matched)
+	}
+	start := time.Now()
+	if err := sortDatas(matched, opts.sort, opts.vocabulary); err != nil {
+		return nil, err
+	}
+	timings.add("sort", time.Since(start))
+	if opts.limit > 0 && len(matched) > opts.limit {
+		matched = matched[:opts.limit]
+	}
+	return matched, nil
+}
+
+func query(repository string, tokens []string, opts options) {
+	must(usageError(opts.prepare()))
+	stopProfiling := startProfiling(opts)
+	defer stopProfiling()
+	var tree *filterNode
+	if len(tokens) > 0 || opts.zipList == nil {
+		var err error
+		tree, _, err = parseFilterTree(tokens, opts.vocabulary)
+		if err != nil {
+			must(&queryError{err, tokens})
+		}
+	}
+	tree = opts.restrict(tree)
+	logger.Debug("Parsed query", "query", tokensString(tokens))
+
+	if opts.validate {
+		fmt.Println("Valid query")
+		return
+	}
+
+	if opts.explain {
+		tree.explain(os.Stdout, 0)
+		return
+	}
+	if opts.count || opts.countBy != "" {
+		must(count(os.Stdout, repository, tree, opts))
+		return
+	}
+
+	format, ok := formats[opts.format]
+	if opts.series {
+		format = seriesFormats[opts.format]
+	}
+	if !ok {
+		must(usageError(fmt.Errorf("Couldn't find format %s", opts.format)))
+	}
+
+	datas, err := matchTree(repository, tree, opts)
+	must(err)
+	logger.Info("Matched zip codes", "matches", len(datas))
+	if len(datas) == 0 {
+		suggestNames(os.Stderr, repository, tree, opts)
+	}
+	if opts.record != "" {
+		must(record(opts.record, tokensString(tokens), datas, opts))
+	}
+	if opts.report != "" {
+		must(writeReportFile(opts.report, tokensString(tokens), datas, opts))
+		fmt.Fprintf(os.Stderr, "Wrote the report of %d zip codes to %s\n", len(datas), opts.report)
+		return
+	}
+	if opts.chart != "" {
+		must(writeChart(opts.chart, datas))
+		fmt.Fprintf(os.Stderr, "Wrote the price history of %d zip codes to %s\n", min(len(datas), maxChartSeries), opts.chart)
+		return
+	}
+	if opts.exportSheet != "" {
+		must(exportSheet(opts.exportSheet, opts.sheetTab, datas, opts.outputColumns()))
+		fmt.Fprintf(os.Stderr, "Wrote %d zip codes to %s of spreadsheet %s\n", len(datas), opts.sheetTab, opts.exportSheet)
+		return
+	}
+	start := time.Now()
+	must(format(os.Stdout, datas, opts))
+	timings.add("output", time.Since(start))
+	stopProfiling()
+	if len(datas) == 0 {
+		os.Exit(noMatches())
+	}
+}
+
+// libraryMain replaces the command line in the WASM module, see wasm.go.
+var libraryMain func()
+
+func main() {
+	if libraryMain != nil {
+		libraryMain()
+		return
+	}
+	if len(os.Args) < 2 {
+		help()
+		return
+	}
+
+	c, ok := findCommand(os.Args[1])
+	if !ok {
+		queryCmd(os.Args[1:])
+		return
+	}
+	if len(os.Args) > 2 && isHelp(os.Args[2]) && !c.hidden {
+		usage(c.name)
+		return
+	}
+	c.run(os.Args[2:])
+}
+
+// queryCmd runs the query of args against the dataset_dir of args, or of
+// --data.
+func queryCmd(args []string) {
+	var opts options
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	opts.register(fs)
+	args = parseArgs(fs, args)
+	if len(opts.data) > 0 {
+		query(opts.repository(""), tokenize(args), opts)
+		return
+	}
+	if len(args) < 1 {
+		help()
+		return
 	}
 
-	fmt.Println("Total zip codes:", len(datas))
+	query(args[0], tokenize(args[1:]), opts)
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCalculateGrowthRate(t *testing.T) {
+	// 13 months doubling over the last year, the first month is dropped to
+	// keep a whole year
+	doubling := []float64{50, 100, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 200}
+	for i := 3; i < 12; i++ {
+		doubling[i] = 100 + float64(i-1)*100/11
+	}
+
+	tests := []struct {
+		name   string
+		vs     []float64
+		growth float64
+		years  float64
+	}{
+		{"empty", nil, 0, 0},
+		{"all missing", []float64{0, 0, 0}, 0, 0},
+		{"flat", []float64{100, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100}, 0, 1},
+		{"whole year", doubling, 100, 1},
+		{"leading missing", append([]float64{0, 0}, doubling[1:]...), 100, 1},
+		{"whole year starts on a missing month", []float64{100, 105, 0, 110, 110, 110, 110, 110, 110, 110, 110, 110, 110, 121}, (math.Pow(121.0/110, 12.0/11) - 1) * 100, 11.0 / 12},
+		{"less than a year", []float64{100, 0, 0, 0, 0, 100}, 0, 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			growth, years := calculateGrowthRate(tt.vs, 12)
+			if math.IsNaN(growth) || math.IsInf(growth, 0) {
+				t.Fatalf("growth = %v, want a finite growth rate", growth)
+			}
+			if math.Abs(growth-tt.growth) > 1e-9 || math.Abs(years-tt.years) > 1e-9 {
+				t.Errorf("calculateGrowthRate(%v) = %v, %v, want %v, %v", tt.vs, growth, years, tt.growth, tt.years)
+			}
+		})
+	}
+}
+
+func TestMarshalRecordNonFinite(t *testing.T) {
+	d := Data{ZipCode: 94536, GrowthRate: math.Inf(1), YoY: math.NaN()}
+	columns := []column{
+		{"ZipCode", func(d *Data) interface{} { return d.ZipCode }},
+		{"GrowthRate", func(d *Data) interface{} { return d.GrowthRate }},
+		{"YoY", func(d *Data) interface{} { return d.YoY }},
+	}
+	b, err := marshalRecord(&d, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"ZipCode":94536,"GrowthRate":null,"YoY":null}`; string(b) != want {
+		t.Errorf("marshalRecord = %s, want %s", b, want)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseMerges maps the newer dataset of each --merge <older>,<newer> to
+// the older one.
+func parseMerges(merges []string) (map[string]string, error) {
+	merged := map[string]string{}
+	for _, merge := range merges {
+		splitted := strings.Split(merge, ",")
+		if len(splitted) != 2 || splitted[0] == "" || splitted[1] == "" || splitted[0] == splitted[1] {
+			return nil, fmt.Errorf("--merge expects <older_dataset>,<newer_dataset>, got %s", merge)
+		}
+		if _, ok := merged[splitted[1]]; ok {
+			return nil, fmt.Errorf("Dataset %s is merged twice", splitted[1])
+		}
+		merged[splitted[1]] = splitted[0]
+	}
+
+	for newer, older := range merged {
+		if _, ok := merged[older]; ok {
+			return nil, fmt.Errorf("Dataset %s is merged into %s, it can't be merged into too", older, newer)
+		}
+	}
+	return merged, nil
+}
+
+// history is the price histories of an older dataset, spliced before the
+// histories of a newer one.
+type history struct {
+	months []string
+	values map[uint64][]float64
+	// prefix maps the months spliced before the newer dataset, from the
+	// first month of the older dataset to the month before the newer one
+	// starts, to the index of their values in the older dataset or -1.
+	prefix []int
+	// overlap maps the months of the newer dataset to the index of their
+	// values in the older dataset or -1.
+	overlap []int
+}
+
+func readHistory(repository, dataset string) (*history, error) {
+	f, err := openRepositoryDataset(repository, dataset)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := &history{values: map[uint64][]float64{}}
+	scanner := bufio.NewScanner(f)
+	scanner.Scan()
+	header := strings.Split(scanner.Text(), ",")
+	l, err := datasetLayout(repository, dataset, header)
+	if err != nil {
+		return nil, err
+	}
+	h.months = parseMonths(header, l)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ",")
+		if len(fields) <= l.months {
+			continue
+		}
+
+		zipCode, err := strconv.ParseUint(fields[l.zipCode], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		values := make([]float64, len(fields)-l.months)
+		for i, v := range fields[l.months:] {
+			values[i], _ = strconv.ParseFloat(v, 64)
+		}
+		h.values[zipCode] = values
+	}
+	return h, scanner.Err()
+}
+
+// splice returns the months of the merged dataset, and prepares splicing
+// the rows of a newer dataset starting with months.
+func (h *history) splice(months []string) ([]string, error) {
+	index := map[string]int{}
+	for i, month := range h.months {
+		index[month] = i
+	}
+	lookup := func(month string) int {
+		if i, ok := index[month]; ok {
+			return i
+		}
+		return -1
+	}
+
+	h.prefix, h.overlap = nil, make([]int, len(months))
+	for i, month := range months {
+		h.overlap[i] = lookup(month)
+	}
+	if len(h.months) == 0 || len(months) == 0 {
+		return months, nil
+	}
+
+	month, err := time.Parse("2006-01", h.months[0])
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't merge dataset starting with month %s", h.months[0])
+	}
+
+	var spliced []string
+	for label := month.Format("2006-01"); label < months[0]; label = month.Format("2006-01") {
+		spliced = append(spliced, label)
+		h.prefix = append(h.prefix, lookup(label))
+		month = month.AddDate(0, 1, 0)
+	}
+	return append(spliced, months...), nil
+}
+
+// spliceValues prepends the older history of zipCode to values. Zillow
+// rebases its series between exports, so the older history is scaled to
+// meet values in the first month both have a value.
+func (h *history) spliceValues(zipCode uint64, values []float64) []float64 {
+	older := h.values[zipCode]
+	at := func(i int) float64 {
+		if i < 0 || i >= len(older) {
+			return 0
+		}
+		return older[i]
+	}
+
+	ratio := 1.0
+	for i, v := range values {
+		if i < len(h.overlap) && v != 0 && at(h.overlap[i]) != 0 {
+			ratio = v / at(h.overlap[i])
+			break
+		}
+	}
+
+	spliced := make([]float64, len(h.prefix), len(h.prefix)+len(values))
+	for i, j := range h.prefix {
+		spliced[i] = at(j) * ratio
+	}
+	return append(spliced, values...)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplice(t *testing.T) {
+	h := &history{
+		months: []string{"2020-01", "2020-02", "2020-03", "2020-04"},
+		values: map[uint64][]float64{1: {100, 110, 120, 130}, 2: {0, 50, 0, 60}},
+	}
+	months, err := h.splice([]string{"2020-03", "2020-04", "2020-05"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"2020-01", "2020-02", "2020-03", "2020-04", "2020-05"}; !reflect.DeepEqual(months, want) {
+		t.Errorf("splice = %v, want %v", months, want)
+	}
+
+	tests := []struct {
+		name    string
+		zipCode uint64
+		values  []float64
+		want    []float64
+	}{
+		// rebased to twice the older values in 2020-03
+		{"scaled", 1, []float64{240, 260, 280}, []float64{200, 220, 240, 260, 280}},
+		// 2020-03 is missing from the older history, 2020-04 meets
+		{"first common month", 2, []float64{100, 120, 140}, []float64{0, 100, 100, 120, 140}},
+		{"zip code not in the older history", 3, []float64{10, 20, 30}, []float64{0, 0, 10, 20, 30}},
+	}
+	for _, test := range tests {
+		if got := h.spliceValues(test.zipCode, test.values); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: spliceValues = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseMerges(t *testing.T) {
+	merged, err := parseMerges([]string{"old.csv,new.csv"})
+	if err != nil || !reflect.DeepEqual(merged, map[string]string{"new.csv": "old.csv"}) {
+		t.Errorf("parseMerges = %v, %v", merged, err)
+	}
+
+	for _, merges := range [][]string{
+		{"old.csv"},
+		{"same.csv,same.csv"},
+		{"a.csv,new.csv", "b.csv,new.csv"},
+		{"a.csv,b.csv", "b.csv,c.csv"},
+	} {
+		if _, err := parseMerges(merges); err == nil {
+			t.Errorf("parseMerges(%v) succeeded, want an error", merges)
+		}
+	}
+}
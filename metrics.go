@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type metric struct {
+	name string
+	help string
+	kind string
+	// values by rendered labels, e.g. query="cheap"
+	values map[string]float64
+}
+
+// metrics holds the daemon metrics in the Prometheus text format.
+type metrics struct {
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+// daemonMetrics are the name, kind, and help of the metrics of daemon.
+var daemonMetrics = [][3]string{
+	{"zhiquery_query_evaluations_total", "counter", "Evaluations of saved queries by result."},
+	{"zhiquery_query_duration_seconds", "summary", "Time spent evaluating saved queries."},
+	{"zhiquery_query_matches", "gauge", "Zip codes matched by the last evaluation of saved queries."},
+	{"zhiquery_dataset_rows", "gauge", "Rows of the dataset files."},
+	{"zhiquery_dataset_refreshes_total", "counter", "Downloads of the dataset files by result."},
+	{"zhiquery_dataset_last_refresh_timestamp_seconds", "gauge", "Time of the last successful download of the dataset files."},
+}
+
+// serverMetrics are the name, kind, and help of the metrics of serve.
+var serverMetrics = [][3]string{
+	{"zhiquery_requests_total", "counter", "Queries of /api/query by result."},
+	{"zhiquery_request_duration_seconds", "summary", "Time spent answering the queries of /api/query."},
+	{"zhiquery_request_matches", "summary", "Zip codes matched by the queries of /api/query."},
+	{"zhiquery_dataset_rows", "gauge", "Rows loaded of the dataset files."},
+	{"zhiquery_dataset_reloads_total", "counter", "Loads of the changed dataset files by result."},
+	{"zhiquery_dataset_last_reload_timestamp_seconds", "gauge", "Time of the last successful load of the dataset files."},
+}
+
+func newMetrics(descs [][3]string) *metrics {
+	m := &metrics{}
+	for _, desc := range descs {
+		m.metrics = append(m.metrics, &metric{desc[0], desc[2], desc[1], map[string]float64{}})
+	}
+	return m
+}
+
+func labels(pairs ...string) string {
+	var rendered []string
+	for i := 0; i < len(pairs); i += 2 {
+		rendered = append(rendered, fmt.Sprintf("%s=%q", pairs[i], pairs[i+1]))
+	}
+	return strings.Join(rendered, ",")
+}
+
+func (m *metrics) lookup(name string) *metric {
+	for _, metric := range m.metrics {
+		if metric.name == name {
+			return metric
+		}
+	}
+	panic("unknown metric " + name)
+}
+
+func (m *metrics) add(name, labels string, v float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lookup(name).values[labels] += v
+}
+
+func (m *metrics) set(name, labels string, v float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lookup(name).values[labels] = v
+}
+
+// reset drops the values of name, e.g. those of the dataset files gone
+// since they were set.
+func (m *metrics) reset(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lookup(name).values = map[string]float64{}
+}
+
+// observe records a summary observation, without quantiles.
+func (m *metrics) observe(name, labels string, v float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	metric := m.lookup(name)
+	metric.values["sum\x00"+labels] += v
+	metric.values["count\x00"+labels]++
+}
+
+func (m *metrics) write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, metric := range m.metrics {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind)
+
+		var keys []string
+		for key := range metric.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			name, labels := metric.name, key
+			if metric.kind == "summary" {
+				splitted := strings.SplitN(key, "\x00", 2)
+				name, labels = name+"_"+splitted[0], splitted[1]
+			}
+			if labels != "" {
+				labels = "{" + labels + "}"
+			}
+			fmt.Fprintf(&buf, "%s%s %s\n", name, labels, strconv.FormatFloat(metric.values[key], 'f', -1, 64))
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := m.write(w); err != nil {
+		logger.Warn("Couldn't write metrics", "error", err)
+	}
+}
+
+func countRows(p string) (int, error) {
+	f, err := openDataset(p)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	rows := -1 // header
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		rows++
+	}
+	if rows < 0 {
+		rows = 0
+	}
+	return rows, scanner.Err()
+}
+
+func (m *metrics) countDatasetRows(repository string) {
+	datasets, err := ioutil.ReadDir(repository)
+	if err != nil {
+		logger.Warn("Couldn't count dataset rows", "error", err)
+		return
+	}
+
+	for _, dataset := range datasets {
+		rows, err := countRows(path.Join(repository, dataset.Name()))
+		if err != nil {
+			logger.Warn("Couldn't count dataset rows", "dataset", dataset.Name(), "error", err)
+			continue
+		}
+		m.set("zhiquery_dataset_rows", labels("dataset", dataset.Name()), float64(rows))
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// mortgage estimates the monthly principal and interest payment of a fixed
+// rate loan.
+type mortgage struct {
+	// rate is the yearly interest rate and down the down payment, in
+	// percent.
+	rate, down float64
+	// term is in years.
+	term int
+}
+
+func newMortgage(rate, down float64, term int) (*mortgage, error) {
+	if rate <= 0 {
+		return nil, nil
+	}
+	if down < 0 || down >= 100 {
+		return nil, fmt.Errorf("Invalid --down %v, expected a percentage from 0 to 100", down)
+	}
+	if term <= 0 {
+		return nil, fmt.Errorf("Invalid --term %d, expected a number of years", term)
+	}
+	return &mortgage{rate, down, term}, nil
+}
+
+func (m *mortgage) payment(price float64) float64 {
+	principal := price * (1 - m.down/100)
+	r, n := m.rate/100/12, float64(m.term*12)
+	return principal * r / (1 - math.Pow(1+r, -n))
+}
+
+// setPayment sets the payment of d for its latest price.
+func (m *mortgage) setPayment(d *Data) {
+	if m == nil {
+		return
+	}
+	d.Payment = m.payment(d.Price())
+}
+
+// payment is the monthly payment of d, NaN without --rate so that
+// comparisons don't match.
+func payment(d *Data) float64 {
+	if d.Payment == 0 {
+		return math.NaN()
+	}
+	return d.Payment
+}
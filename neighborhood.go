@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// isNeighborhood reports whether a row of a dataset is a neighborhood, whose
+// RegionName is its name rather than a zip code.
+func isNeighborhood(l layout, fields []string) bool {
+	return l.regionType >= 0 && l.regionType < len(fields) && strings.EqualFold(strings.TrimSpace(fields[l.regionType]), "neighborhood")
+}
+
+// neighborhoodZips maps the RegionID of a neighborhood to the zip code it
+// lies in.
+type neighborhoodZips map[uint64]uint64
+
+// loadNeighborhoodZips reads a RegionID,ZipCode csv.
+func loadNeighborhoodZips(p string) (neighborhoodZips, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cr := csv.NewReader(f)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid neighborhood table %s: %v", p, err)
+	}
+
+	idColumn, zipColumn := 0, 1
+	for i, column := range header {
+		switch strings.TrimSpace(column) {
+		case "RegionID":
+			idColumn = i
+		case "ZipCode":
+			zipColumn = i
+		}
+	}
+
+	zips := neighborhoodZips{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Invalid neighborhood table %s: %v", p, err)
+		}
+		if len(record) <= idColumn || len(record) <= zipColumn {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSpace(record[idColumn]), 10, 64)
+		if err != nil {
+			continue
+		}
+		zipCode, err := strconv.ParseUint(strings.TrimSpace(record[zipColumn]), 10, 64)
+		if err != nil {
+			continue
+		}
+		zips[id] = zipCode
+	}
+	return zips, nil
+}
+
+// zipCode returns the zip code of the neighborhood of a row, or 0 when
+// unknown, e.g. without --neighborhood-zips.
+func (z neighborhoodZips) zipCode(l layout, fields []string) uint64 {
+	if z == nil || l.regionID < 0 || l.regionID >= len(fields) {
+		return 0
+	}
+
+	id, err := strconv.ParseUint(strings.TrimSpace(fields[l.regionID]), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return z[id]
+}
+
+// rollupNeighborhoods replaces the neighborhood rows of each city by one
+// row whose prices are the mean of theirs, month by month, and whose
+// Neighborhoods counts them. Other rows are kept as they are.
+func rollupNeighborhoods(datas []Data, opts options) []Data {
+	type city struct{ dataset, repository, state, city string }
+	var cities []city
+	rows := map[city][]*Data{}
+	rolled := datas[:0:0]
+	for i := range datas {
+		d := &datas[i]
+		if d.Neighborhood == "" {
+			rolled = append(rolled, *d)
+			continue
+		}
+
+		c := city{d.Dataset, d.Repository, d.State, foldText(d.City)}
+		if rows[c] == nil {
+			cities = append(cities, c)
+		}
+		rows[c] = append(rows[c], d)
+	}
+
+	for _, c := range cities {
+		neighborhoods := rows[c]
+		first := neighborhoods[0]
+		data := Data{
+			City:          first.City,
+			State:         first.State,
+			County:        first.County,
+			Fips:          first.Fips,
+			Months:        first.Months,
+			Dataset:       first.Dataset,
+			Repository:    first.Repository,
+			Neighborhoods: len(neighborhoods),
+			perYear:       first.perYear,
+		}
+
+		sums := make([]float64, len(first.ZHIs))
+		counts := make([]int, len(first.ZHIs))
+		for _, n := range neighborhoods {
+			for j, v := range n.ZHIs {
+				if j < len(sums) && v > 0 {
+					sums[j] += v
+					counts[j]++
+				}
+			}
+		}
+		data.ZHIs = make([]float64, len(sums))
+		for j := range sums {
+			if counts[j] > 0 {
+				data.ZHIs[j] = sums[j] / float64(counts[j])
+			}
+		}
+
+		data.calculateMetrics()
+		opts.mortgage.setPayment(&data)
+		opts.tax.setTax(&data)
+		rolled = append(rolled, data)
+	}
+
+	return rolled
+}
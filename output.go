@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+type column struct {
+	name  string
+	value func(d *Data) interface{}
+}
+
+var columns = []column{
+	{"Dataset", func(d *Data) interface{} { return d.Dataset }},
+	{"Repository", func(d *Data) interface{} { return d.Repository }},
+	{"Snapshot", func(d *Data) interface{} { return d.Snapshot }},
+	{"ZipCode", func(d *Data) interface{} { return d.ZipCode }},
+	{"Neighborhood", func(d *Data) interface{} { return d.Neighborhood }},
+	{"HomeType", func(d *Data) interface{} { return d.HomeType }},
+	{"City", func(d *Data) interface{} { return d.City }},
+	{"State", func(d *Data) interface{} { return d.State }},
+	{"County", func(d *Data) interface{} { return d.County }},
+	{"Fips", func(d *Data) interface{} { return d.Fips }},
+	{"GrowthRate", func(d *Data) interface{} { return d.GrowthRate }},
+	{"RealGrowthRate", func(d *Data) interface{} { return d.RealGrowthRate }},
+	{"Income", func(d *Data) interface{} { return d.Income }},
+	{"Affordability", func(d *Data) interface{} { return d.Affordability }},
+	{"Payment", func(d *Data) interface{} { return d.Payment }},
+	{"Yield", func(d *Data) interface{} { return d.Yield }},
+	{"CapRate", func(d *Data) interface{} { return d.CapRate }},
+	{"Tax", func(d *Data) interface{} { return d.Tax }},
+	{"TaxRate", func(d *Data) interface{} { return d.TaxRate }},
+	{"Inventory", func(d *Data) interface{} { return d.Inventory }},
+	{"DaysOnMarket", func(d *Data) interface{} { return d.DaysOnMarket }},
+	{"SizeRank", func(d *Data) interface{} { return d.SizeRank }},
+	{"RankDelta", func(d *Data) interface{} { return d.RankDelta }},
+	{"Years", func(d *Data) interface{} { return d.Years }},
+	{"YoY", func(d *Data) interface{} { return d.YoY }},
+	{"Volatility", func(d *Data) interface{} { return d.Volatility }},
+	{"Drawdown", func(d *Data) interface{} { return d.Drawdown }},
+	{"Quality", func(d *Data) interface{} { return d.Quality }},
+	{"Acceleration", func(d *Data) interface{} { return d.Acceleration }},
+	{"MissingPct", func(d *Data) interface{} { return d.MissingPct }},
+	{"RelGrowth", func(d *Data) interface{} { return d.RelGrowth }},
+	{"Benchmark", func(d *Data) interface{} { return d.Benchmark }},
+	{"GrowthPct", func(d *Data) interface{} { return d.GrowthPct }},
+	{"PricePct", func(d *Data) interface{} { return d.PricePct }},
+	{"GrowthStatePct", func(d *Data) interface{} { return d.GrowthStatePct }},
+	{"PriceStatePct", func(d *Data) interface{} { return d.PriceStatePct }},
+	{"Score", func(d *Data) interface{} { return d.Score }},
+	{"Price", func(d *Data) interface{} { return d.Price() }},
+	{"Duplicates", func(d *Data) interface{} { return strings.Join(d.Duplicates, ";") }},
+}
+
+var formats = map[string]func(w io.Writer, datas []Data, opts options) error{
+	"text":        writeText,
+	"json":        writeJSON,
+	"csv":         writeCSV,
+	"geojson":     writeGeoJSON,
+	"heatmap-svg": writeHeatmapSVG,
+}
+
+var seriesFormats = map[string]func(w io.Writer, datas []Data, opts options) error{
+	"json": writeSeriesJSON,
+	"csv":  writeSeriesCSV,
+}
+
+type seriesRecord struct {
+	Dataset string
+	ZipCode uint64
+	Month   string
+	Value   float64
+}
+
+// eachSeriesRecord calls f with every month that has a value.
+func eachSeriesRecord(datas []Data, f func(r seriesRecord) error) error {
+	for i := range datas {
+		d := &datas[i]
+		for j, v := range d.ZHIs {
+			if v == 0 || j >= len(d.Months) {
+				continue
+			}
+
+			if err := f(seriesRecord{d.Dataset, d.ZipCode, d.Months[j], v}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeSeriesJSON(w io.Writer, datas []Data, _ options) error {
+	records := []seriesRecord{}
+	eachSeriesRecord(datas, func(r seriesRecord) error {
+		records = append(records, r)
+		return nil
+	})
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+func writeSeriesCSV(w io.Writer, datas []Data, _ options) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"Dataset", "ZipCode", "Month", "Value"}); err != nil {
+		return err
+	}
+
+	err := eachSeriesRecord(datas, func(r seriesRecord) error {
+		return cw.Write([]string{r.Dataset, strconv.FormatUint(r.ZipCode, 10), r.Month, formatValue(r.Value)})
+	})
+	if err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeText(w io.Writer, datas []Data, opts options) error {
+	if opts.tmpl != nil {
+		for i := range datas {
+			if err := opts.tmpl.Execute(w, &datas[i]); err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if opts.columns != nil {
+		if err := writeTable(w, datas, opts.outputColumns()); err != nil {
+			return err
+		}
+	} else {
+		for _, data := range datas {
+			if _, err := fmt.Fprintln(w, data.text(opts)); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := fmt.Fprintln(w, "Total zip codes:", len(datas))
+	return err
+}
+
+// marshalRecord encodes the columns of d as a JSON object, keeping the
+// column order.
+func marshalRecord(d *Data, columns []column) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, c := range columns {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		name, _ := json.Marshal(c.name)
+		v := c.value(d)
+		// a single row dividing by zero can't fail the whole result set
+		if f, ok := v.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+			v = nil
+		}
+		value, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func writeJSON(w io.Writer, datas []Data, opts options) error {
+	records := make([]json.RawMessage, len(datas))
+	for i := range datas {
+		record, err := marshalRecord(&datas[i], opts.outputColumns())
+		if err != nil {
+			return err
+		}
+		records[i] = record
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+func writeCSV(w io.Writer, datas []Data, opts options) error {
+	cw := csv.NewWriter(w)
+	columns := opts.outputColumns()
+
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = c.name
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for i := range datas {
+		row := make([]string, len(columns))
+		for j, c := range columns {
+			row[j] = formatValue(c.value(&datas[i]))
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+type geoJSONFeature struct {
+	Type     string `json:"type"`
+	Geometry struct {
+		Type        string     `json:"type"`
+		Coordinates [2]float64 `json:"coordinates"`
+	} `json:"geometry"`
+	Properties json.RawMessage `json:"properties"`
+}
+
+func writeGeoJSON(w io.Writer, datas []Data, opts options) error {
+	zips, err := loadZips()
+	if err != nil {
+		return err
+	}
+
+	collection := struct {
+		Type     string           `json:"type"`
+		Features []geoJSONFeature `json:"features"`
+	}{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+
+	skipped := 0
+	for i := range datas {
+		c, ok := zips[datas[i].ZipCode]
+		if !ok {
+			skipped++
+			continue
+		}
+
+		properties, err := marshalRecord(&datas[i], opts.outputColumns())
+		if err != nil {
+			return err
+		}
+
+		feature := geoJSONFeature{Type: "Feature", Properties: properties}
+		feature.Geometry.Type = "Point"
+		feature.Geometry.Coordinates = [2]float64{c.lng, c.lat}
+		collection.Features = append(collection.Features, feature)
+	}
+
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "Skipped %d zip codes without coordinates\n", skipped)
+	}
+
+	return json.NewEncoder(w).Encode(collection)
+}
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+const sparklineWidth = 24
+
+// sparklineOf draws vs, without the missing leading months, in at most
+// sparklineWidth bars. Each bar is the average of the months it covers.
+func sparklineOf(vs []float64) string {
+	for len(vs) > 0 && vs[0] == 0 {
+		vs = vs[1:]
+	}
+	if len(vs) == 0 {
+		return ""
+	}
+
+	width := sparklineWidth
+	if len(vs) < width {
+		width = len(vs)
+	}
+
+	bars := make([]float64, width)
+	for i := range bars {
+		start, end := i*len(vs)/width, (i+1)*len(vs)/width
+		var a average
+		for _, v := range vs[start:end] {
+			if v != 0 {
+				a.add(v)
+			}
+		}
+		bars[i] = a.value()
+	}
+
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, v := range bars {
+		min, max = math.Min(min, v), math.Max(max, v)
+	}
+
+	var b strings.Builder
+	for _, v := range bars {
+		level := 0
+		if max > min {
+			level = int((v - min) / (max - min) * float64(len(sparks)-1))
+		}
+		b.WriteRune(sparks[level])
+	}
+	return b.String()
+}
+
+// outputColumns are the columns of --fields, or every column.
+func (o options) outputColumns() []column {
+	if o.columns != nil {
+		return o.formatColumns(o.columns)
+	}
+	return o.formatColumns(queryColumns(o.vocabulary))
+}
+
+// queryColumns are the columns of every query followed by those of v.
+func queryColumns(v *vocabulary) []column {
+	all := append(append([]column(nil), columns...), metricFields.columns...)
+	if v != nil {
+		all = append(all, v.columns...)
+	}
+	return all
+}
+
+func parseFields(fields string, v *vocabulary) ([]column, error) {
+	var selected []column
+	for _, field := range strings.Split(fields, ",") {
+		name := strings.TrimSpace(field)
+		if alias, ok := fieldAliases[strings.ToLower(name)]; ok {
+			name = alias
+		}
+
+		found := false
+		for _, c := range queryColumns(v) {
+			if strings.EqualFold(c.name, name) {
+				selected = append(selected, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Couldn't find field %s", strings.TrimSpace(field))
+		}
+	}
+	return selected, nil
+}
+
+func writeTable(w io.Writer, datas []Data, columns []column) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for i, c := range columns {
+		if i > 0 {
+			fmt.Fprint(tw, "\t")
+		}
+		fmt.Fprint(tw, c.name)
+	}
+	fmt.Fprintln(tw)
+
+	for i := range datas {
+		for j, c := range columns {
+			if j > 0 {
+				fmt.Fprint(tw, "\t")
+			}
+			fmt.Fprint(tw, formatValue(c.value(&datas[i])))
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// holding is a zip code of a portfolio, owned when bought for Price, or
+// watched since Date otherwise.
+type holding struct {
+	Name    string
+	ZipCode uint64
+	// Dataset is the dataset valuing the holding, the dataset of the
+	// portfolio by default.
+	Dataset string
+	Price   float64
+	// Date is the YYYY-MM of the purchase, or the start of the watch.
+	Date string
+}
+
+type portfolio struct {
+	dataset  string
+	holdings []holding
+}
+
+// parsePortfolio reads the subset of YAML portfolios use: an optional
+// top-level dataset, and a list of holdings, at the top level or under
+// holdings, whose keys are zip, name, dataset, price, and date, e.g.
+//
+//	dataset: 3-bedrooms.csv
+//	holdings:
+//	  - zip: 94110
+//	    name: Mission condo
+//	    price: 850000
+//	    date: 2019-06
+//	  - zip: 78704 # watched
+//	    date: 2022-01
+func parsePortfolio(r io.Reader) (portfolio, error) {
+	var p portfolio
+	var h *holding
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		item := strings.HasPrefix(trimmed, "- ")
+		if item {
+			p.holdings = append(p.holdings, holding{})
+			h = &p.holdings[len(p.holdings)-1]
+			trimmed = strings.TrimSpace(trimmed[2:])
+		}
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return p, fmt.Errorf("Invalid portfolio line %d: expected key: value", n)
+		}
+		key, value = strings.TrimSpace(key), strings.Trim(strings.TrimSpace(value), `"'`)
+
+		// top-level keys aren't indented
+		if !item && line == strings.TrimLeft(line, " \t") {
+			h = nil
+			switch key {
+			case "dataset":
+				p.dataset = value
+			case "holdings":
+			default:
+				return p, fmt.Errorf("Invalid portfolio line %d: unknown key %s", n, key)
+			}
+			continue
+		}
+		if h == nil {
+			return p, fmt.Errorf("Invalid portfolio line %d: expected a holding starting with -", n)
+		}
+
+		var err error
+		switch key {
+		case "zip":
+			h.ZipCode, err = strconv.ParseUint(value, 10, 64)
+		case "name":
+			h.Name = value
+		case "dataset":
+			h.Dataset = value
+		case "price":
+			h.Price, err = strconv.ParseFloat(strings.ReplaceAll(value, ",", ""), 64)
+		case "date":
+			if len(value) >= 7 {
+				value = value[:7]
+			}
+			h.Date = value
+			_, err = time.Parse("2006-01", value)
+		default:
+			err = fmt.Errorf("unknown key %s", key)
+		}
+		if err != nil {
+			return p, fmt.Errorf("Invalid portfolio line %d: %v", n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return p, err
+	}
+
+	for i, h := range p.holdings {
+		if h.ZipCode == 0 {
+			return p, fmt.Errorf("Invalid portfolio: holding %d has no zip", i+1)
+		}
+		if h.Price > 0 && h.Date == "" {
+			return p, fmt.Errorf("Invalid portfolio: holding %d has a price but no date", i+1)
+		}
+	}
+	return p, nil
+}
+
+// valueAt returns the ZHI of d at month, or the first one after it when
+// missing, and the month it's from.
+func valueAt(d *Data, month string) (float64, string) {
+	start := sort.SearchStrings(d.Months, month)
+	for i := start; i < len(d.ZHIs) && i < len(d.Months); i++ {
+		if d.ZHIs[i] != 0 {
+			return d.ZHIs[i], d.Months[i]
+		}
+	}
+	return 0, ""
+}
+
+// valuation is the estimated value of a holding: its price grown like the
+// ZHI of its zip code since its date, or the ZHI itself for watched zip
+// codes.
+type valuation struct {
+	holding
+	dataset string
+	cost    float64
+	value   float64
+	// change is the change since Date in percent, and cagr its yearly rate.
+	change float64
+	cagr   float64
+	// since is the month the ZHI at Date is from.
+	since string
+}
+
+func valueHolding(h holding, d *Data) (valuation, error) {
+	v := valuation{holding: h, dataset: d.Dataset, cost: h.Price, value: d.Price(), change: math.NaN(), cagr: math.NaN()}
+	if h.Date == "" {
+		return v, nil
+	}
+
+	then, since := valueAt(d, h.Date)
+	if then == 0 {
+		return v, fmt.Errorf("Couldn't find a price of %d in %s since %s", h.ZipCode, d.Dataset, h.Date)
+	}
+	v.since = since
+	ratio := d.Price() / then
+	v.change = (ratio - 1) * 100
+	i := sort.SearchStrings(d.Months, since)
+	if years := float64(len(d.Months)-1-i) / float64(d.PerYear()); years > 0 {
+		v.cagr = (math.Pow(ratio, 1/years) - 1) * 100
+	}
+	if h.Price > 0 {
+		v.value = h.Price * ratio
+	}
+	return v, nil
+}
+
+func portfolioCmd(args []string) {
+	repo := repository()
+	if len(args) == 2 {
+		repo, args = args[0], args[1:]
+	}
+	if len(args) != 1 {
+		usage("portfolio")
+		os.Exit(exitUsage)
+	}
+
+	f, err := os.Open(args[0])
+	must(err)
+	p, err := parsePortfolio(f)
+	f.Close()
+	must(usageError(err))
+
+	zips := map[uint64]bool{}
+	for _, h := range p.holdings {
+		zips[h.ZipCode] = true
+	}
+	datas := load(repo, func(d *Data) bool { return zips[d.ZipCode] })
+	byZip := map[uint64]map[string]*Data{}
+	for i := range datas {
+		d := &datas[i]
+		if byZip[d.ZipCode] == nil {
+			byZip[d.ZipCode] = map[string]*Data{}
+		}
+		byZip[d.ZipCode][d.Dataset] = d
+	}
+
+	var valuations []valuation
+	for _, h := range p.holdings {
+		dataset := h.Dataset
+		if dataset == "" {
+			dataset = p.dataset
+		}
+		rows := byZip[h.ZipCode]
+		if dataset == "" && len(rows) > 0 {
+			// the first dataset by name when the portfolio doesn't say
+			dataset = sortedDatasets(rows)[0]
+		}
+		d, ok := rows[dataset]
+		if !ok {
+			must(datasetError(fmt.Errorf("Couldn't find %d in dataset %s", h.ZipCode, dataset)))
+		}
+
+		v, err := valueHolding(h, d)
+		must(err)
+		valuations = append(valuations, v)
+	}
+	must(writePortfolio(os.Stdout, valuations))
+}
+
+// writePortfolio prints a line per holding and the totals of the owned
+// ones.
+func writePortfolio(out io.Writer, valuations []valuation) error {
+	percent := func(v float64) string {
+		if math.IsNaN(v) {
+			return "-"
+		}
+		return fmt.Sprintf("%+.1f%%", v)
+	}
+	dollars := func(v float64) string {
+		if v < 0 {
+			return "-$" + humanize.Comma(int64(math.Round(-v)))
+		}
+		return "$" + humanize.Comma(int64(math.Round(v)))
+	}
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "Holding\tDataset\tSince\tCost\tValue\tChange\tCAGR\t")
+	var cost, worth float64
+	for _, v := range valuations {
+		name := strconv.FormatUint(v.ZipCode, 10)
+		if v.Name != "" {
+			name = fmt.Sprintf("%s (%d)", v.Name, v.ZipCode)
+		}
+		since, paid := v.since, "watched"
+		if since == "" {
+			since = "-"
+		}
+		if v.cost > 0 {
+			paid = dollars(v.cost)
+			cost += v.cost
+			worth += v.value
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", name, v.dataset, since, paid, dollars(v.value), percent(v.change), percent(v.cagr))
+	}
+	if cost > 0 {
+		fmt.Fprintf(w, "Total\t\t\t%s\t%s\t%s\t\t\n", dollars(cost), dollars(worth), percent((worth/cost-1)*100))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if cost > 0 {
+		_, err := fmt.Fprintf(out, "\nGain: %s on %s invested\n", dollars(worth-cost), dollars(cost))
+		return err
+	}
+	return nil
+}
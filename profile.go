@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"time"
+)
+
+// stageTimings records how long each stage of a query took for --timings.
+// A nil *stageTimings records nothing.
+type stageTimings struct {
+	mu     sync.Mutex
+	start  time.Time
+	stages []stageTiming
+}
+
+type stageTiming struct {
+	name     string
+	duration time.Duration
+}
+
+// timings is set by startProfiling with --timings, as datasets are parsed
+// far from the command they're parsed for.
+var timings *stageTimings
+
+// add adds d to the time of the stage name.
+func (t *stageTimings) add(name string, d time.Duration) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.stages {
+		if t.stages[i].name == name {
+			t.stages[i].duration += d
+			return
+		}
+	}
+	t.stages = append(t.stages, stageTiming{name, d})
+}
+
+// write prints every stage in the order they were first recorded, and the
+// total time since the timings started.
+func (t *stageTimings) write(w io.Writer) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stages := append(t.stages, stageTiming{"total", time.Since(t.start)})
+	width := 0
+	for _, s := range stages {
+		width = max(width, len(s.name))
+	}
+
+	if _, err := fmt.Fprintln(w, "Timings:"); err != nil {
+		return err
+	}
+	for _, s := range stages {
+		if _, err := fmt.Fprintf(w, "  %-*s  %10v\n", width, s.name, s.duration.Round(time.Microsecond)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startProfiling starts the CPU profile of --cpuprofile and the timings of
+// --timings, and returns a function stopping them, writing the heap
+// profile of --memprofile, and printing the timings on stderr. Only its
+// first call does anything, so it can be deferred and called before
+// os.Exit.
+func startProfiling(opts options) func() {
+	var cpu *os.File
+	if opts.cpuProfile != "" {
+		f, err := os.Create(opts.cpuProfile)
+		must(err)
+		must(pprof.StartCPUProfile(f))
+		cpu = f
+	}
+	if opts.timings {
+		timings = &stageTimings{start: time.Now()}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if cpu != nil {
+				pprof.StopCPUProfile()
+				must(cpu.Close())
+			}
+			if opts.memProfile != "" {
+				f, err := os.Create(opts.memProfile)
+				must(err)
+				// up to date statistics of the live heap
+				runtime.GC()
+				must(pprof.WriteHeapProfile(f))
+				must(f.Close())
+			}
+			if timings != nil {
+				must(timings.write(os.Stderr))
+				timings = nil
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+const progressInterval = 100 * time.Millisecond
+
+// progress reports the parsing progress on a single stderr line. A disabled
+// progress only counts.
+type progress struct {
+	files, filesDone int64
+	rows, matches    int64
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newProgress(files int, enabled bool) *progress {
+	p := &progress{files: int64(files)}
+	if !enabled {
+		return p
+	}
+
+	p.done = make(chan struct{})
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.print()
+			case <-p.done:
+				p.print()
+				fmt.Fprintln(os.Stderr)
+				return
+			}
+		}
+	}()
+	return p
+}
+
+func (p *progress) row(matched bool) {
+	atomic.AddInt64(&p.rows, 1)
+	if matched {
+		atomic.AddInt64(&p.matches, 1)
+	}
+}
+
+func (p *progress) file() {
+	atomic.AddInt64(&p.filesDone, 1)
+}
+
+func (p *progress) print() {
+	fmt.Fprintf(os.Stderr, "\rParsed %d/%d datasets, %s rows scanned, %s matches",
+		atomic.LoadInt64(&p.filesDone), p.files,
+		humanize.Comma(atomic.LoadInt64(&p.rows)), humanize.Comma(atomic.LoadInt64(&p.matches)))
+}
+
+func (p *progress) stop() {
+	if p.done == nil {
+		return
+	}
+
+	close(p.done)
+	p.wg.Wait()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// FilterProvider adds a filter kind to queries without touching the
+// built-in filter maps, e.g. a FloodZone:<zone> filter backed by the FEMA
+// flood maps. A build registers its providers from the init function of a
+// file added to the package:
+//
+//	func init() {
+//		RegisterFilter(floodZones{})
+//	}
+type FilterProvider interface {
+	// Name is the kind of the filter in queries, e.g. FloodZone.
+	Name() string
+	// ParseArg checks the argument of a token, the part after the colon,
+	// and converts it into the value passed to Filter.
+	ParseArg(arg string) (interface{}, error)
+	// Filter returns the filter matching the rows for a parsed argument.
+	Filter(arg interface{}) FilterFn
+}
+
+var filterProviders = map[string]FilterProvider{}
+
+// RegisterFilter makes the filter of p available to queries, --explain, and
+// the shell completion. It panics when the name is already a filter kind,
+// as registering happens on startup.
+func RegisterFilter(p FilterProvider) {
+	name := p.Name()
+	if !isIdentifier(name) {
+		panic(fmt.Sprintf("Invalid filter name %s", name))
+	}
+	for _, kind := range filterKinds(nil) {
+		if kind == name {
+			panic(fmt.Sprintf("Filter %s is already registered", name))
+		}
+	}
+	filterProviders[name] = p
+}
+
+func providerKinds() []string {
+	var kinds []string
+	for kind := range filterProviders {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func parseProvidedFilter(p FilterProvider, arg string) (FilterFn, error) {
+	v, err := p.ParseArg(arg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", p.Name(), err)
+	}
+	return p.Filter(v), nil
+}
+
+// Metric adds a per zip code metric, e.g. the growth rate over the last few
+// years. A registered metric is computed for every row and is available to
+// filters as a comparison, e.g. Growth5Y:>=6, to --sort, --score, --define,
+// --fields, and the output formats.
+type Metric interface {
+	// Name is the field name of the metric, e.g. Growth5Y.
+	Name() string
+	// Compute returns the metric of d from its series, the ZHIs as resampled
+	// by --resample with d.PerYear() values per year.
+	Compute(series []float64, d *Data) float64
+}
+
+var metricProviders []Metric
+
+// metricFields are the fields of the registered metrics, which every query
+// has.
+var metricFields vocabulary
+
+// RegisterMetric makes m a field like GrowthRate. It panics when the name
+// is already a field.
+func RegisterMetric(m Metric) {
+	name := m.Name()
+	if !isIdentifier(name) {
+		panic(fmt.Sprintf("Invalid metric name %s", name))
+	}
+	if err := metricFields.add(name, func(d *Data) float64 { return d.Metrics[name] }, false); err != nil {
+		panic(fmt.Sprintf("Field %s is already registered", name))
+	}
+	metricProviders = append(metricProviders, m)
+}
+
+// computeMetrics fills d.Metrics with the registered metrics.
+func (d *Data) computeMetrics() {
+	if len(metricProviders) == 0 {
+		return
+	}
+
+	d.Metrics = make(map[string]float64, len(metricProviders))
+	for _, m := range metricProviders {
+		d.Metrics[m.Name()] = m.Compute(d.ZHIs, d)
+	}
+}
+
+// trailingGrowth is the yearly growth rate over the last years.
+type trailingGrowth int
+
+func (years trailingGrowth) Name() string { return fmt.Sprintf("Growth%dY", years) }
+
+func (years trailingGrowth) Compute(series []float64, d *Data) float64 {
+	n := int(years) * d.PerYear()
+	if len(series) <= n || series[len(series)-1-n] == 0 {
+		return 0
+	}
+	return (math.Pow(series[len(series)-1]/series[len(series)-1-n], 1/float64(years)) - 1) * 100
+}
+
+func init() {
+	RegisterMetric(trailingGrowth(5))
+	RegisterMetric(trailingGrowth(10))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// distribution collects values to rank other values against. The values
+// are sorted by the first percentile, which the rows ranked concurrently
+// against the population of serve may all ask for at once.
+type distribution struct {
+	mu     sync.Mutex
+	values []float64
+	sorted bool
+}
+
+func (d *distribution) add(v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+
+	d.values = append(d.values, v)
+	d.sorted = false
+}
+
+// percentile returns the percentage of values less than or equal to v.
+func (d *distribution) percentile(v float64) float64 {
+	if d == nil || len(d.values) == 0 {
+		return 0
+	}
+	d.mu.Lock()
+	if !d.sorted {
+		sort.Float64s(d.values)
+		d.sorted = true
+	}
+	d.mu.Unlock()
+
+	n := sort.Search(len(d.values), func(i int) bool { return d.values[i] > v })
+	return float64(n) / float64(len(d.values)) * 100
+}
+
+// distributions collects growth rates and prices by key.
+type distributions struct {
+	mu      sync.Mutex
+	growths map[[2]string]*distribution
+	prices  map[[2]string]*distribution
+}
+
+func newDistributions() *distributions {
+	return &distributions{
+		growths: map[[2]string]*distribution{},
+		prices:  map[[2]string]*distribution{},
+	}
+}
+
+func (ds *distributions) add(key [2]string, d *Data) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if ds.growths[key] == nil {
+		ds.growths[key] = &distribution{}
+		ds.prices[key] = &distribution{}
+	}
+	ds.growths[key].add(d.GrowthRate)
+	ds.prices[key].add(d.Price())
+}
+
+func matchedKey(d *Data) [2]string { return [2]string{d.Dataset} }
+func stateKey(d *Data) [2]string   { return [2]string{d.Dataset, d.State} }
+
+// rank sets the percentile ranks of d within matched, the rows matching the
+// query, and within states, every row of the same state.
+func rank(d *Data, matched, states *distributions) {
+	d.GrowthPct = matched.growths[matchedKey(d)].percentile(d.GrowthRate)
+	d.PricePct = matched.prices[matchedKey(d)].percentile(d.Price())
+	d.GrowthStatePct = states.growths[stateKey(d)].percentile(d.GrowthRate)
+	d.PriceStatePct = states.prices[stateKey(d)].percentile(d.Price())
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestDistributionPercentileConcurrent(t *testing.T) {
+	d := &distribution{}
+	for _, v := range []float64{5, 1, 4, 2, 3} {
+		d.add(v)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if p := d.percentile(3); p != 60 {
+				t.Errorf("percentile(3) = %v, want 60", p)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sizeRanks maps the zip codes of a dataset snapshot to their SizeRank, by
+// dataset.
+type sizeRanks map[string]map[uint64]int
+
+// readSizeRanks reads the SizeRank of every zip code of repository, an
+// older snapshot of the queried datasets.
+func readSizeRanks(repository string) (sizeRanks, error) {
+	datasets, err := listDatasets(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	ranks := sizeRanks{}
+	for _, dataset := range datasets {
+		f, err := openRepositoryDataset(repository, dataset)
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Scan()
+		l, err := datasetLayout(repository, dataset, strings.Split(scanner.Text(), ","))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if l.sizeRank < 0 {
+			f.Close()
+			continue
+		}
+
+		ranks[dataset] = map[uint64]int{}
+		for scanner.Scan() {
+			fields := strings.Split(scanner.Text(), ",")
+			if len(fields) <= l.zipCode || len(fields) <= l.sizeRank {
+				continue
+			}
+			zipCode, err := strconv.ParseUint(fields[l.zipCode], 10, 64)
+			if err != nil {
+				continue
+			}
+			if rank, err := strconv.Atoi(fields[l.sizeRank]); err == nil {
+				ranks[dataset][zipCode] = rank
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ranks, nil
+}
+
+// setRankDelta sets how many places d climbed in SizeRank since the older
+// snapshot, looking the zip code up in the dataset with the same name
+// first. Smaller ranks are bigger regions, so climbing is positive.
+func (r sizeRanks) setRankDelta(d *Data) {
+	if r == nil || !d.hasSizeRank {
+		return
+	}
+
+	older, ok := r[d.Dataset][d.ZipCode]
+	if !ok {
+		for _, ranks := range r {
+			if older, ok = ranks[d.ZipCode]; ok {
+				break
+			}
+		}
+	}
+	if ok {
+		d.RankDelta = older - d.SizeRank
+		d.hasRankDelta = true
+	}
+}
+
+// rankDelta is the SizeRank change of d, NaN without an older rank so that
+// comparisons don't match.
+func rankDelta(d *Data) float64 {
+	if !d.hasRankDelta {
+		return math.NaN()
+	}
+	return float64(d.RankDelta)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recordSchema creates the tables of --record: one row per run, and one row
+// per match of a run in the order of --sort.
+const recordSchema = `CREATE TABLE IF NOT EXISTS runs (
+  id INTEGER PRIMARY KEY,
+  run_at TEXT NOT NULL,
+  query TEXT NOT NULL,
+  sort TEXT NOT NULL,
+  matches INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS matches (
+  run_id INTEGER NOT NULL REFERENCES runs(id),
+  rank INTEGER NOT NULL,
+  dataset TEXT NOT NULL,
+  repository TEXT,
+  zip_code INTEGER NOT NULL,
+  neighborhood TEXT,
+  city TEXT,
+  state TEXT,
+  county TEXT,
+  growth_rate REAL,
+  yoy REAL,
+  volatility REAL,
+  drawdown REAL,
+  years REAL,
+  price REAL
+);
+CREATE INDEX IF NOT EXISTS matches_zip_code ON matches (zip_code, run_id);
+`
+
+// sqliteCommand returns the sqlite3 command line shell --record runs,
+// $ZHIQUERY_SQLITE or sqlite3 from $PATH.
+func sqliteCommand() string {
+	if command := os.Getenv("ZHIQUERY_SQLITE"); command != "" {
+		return command
+	}
+	return "sqlite3"
+}
+
+// sqlString quotes s as an SQL string literal.
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// sqlFloat is v as an SQL number, NULL when it isn't a number.
+func sqlFloat(v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return "NULL"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// recordScript returns the SQL appending a run of query and its matches.
+func recordScript(query string, datas []Data, opts options, at time.Time) string {
+	var b strings.Builder
+	b.WriteString("BEGIN;\n")
+	b.WriteString(recordSchema)
+	fmt.Fprintf(&b, "INSERT INTO runs (run_at, query, sort, matches) VALUES (%s, %s, %s, %d);\n",
+		sqlString(at.UTC().Format(time.RFC3339)), sqlString(query), sqlString(opts.sort), len(datas))
+	// every match belongs to the run inserted by this script
+	for i := range datas {
+		d := &datas[i]
+		fmt.Fprintf(&b, "INSERT INTO matches VALUES ((SELECT max(id) FROM runs), %d, %s, %s, %d, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s);\n",
+			i+1, sqlString(d.Dataset), sqlString(d.Repository), d.ZipCode, sqlString(d.Neighborhood), sqlString(d.City), sqlString(d.State), sqlString(d.County),
+			sqlFloat(d.GrowthRate), sqlFloat(d.YoY), sqlFloat(d.Volatility), sqlFloat(d.Drawdown), sqlFloat(d.Years), sqlFloat(d.Price()))
+	}
+	b.WriteString("COMMIT;\n")
+	return b.String()
+}
+
+// record appends a run of query and its matches to the SQLite database db
+// through the sqlite3 shell, which creates db on the first run.
+func record(db, query string, datas []Data, opts options) error {
+	command := sqliteCommand()
+	if _, err := exec.LookPath(command); err != nil {
+		return fmt.Errorf("Couldn't find %s for --record, install the sqlite3 command line shell or set $ZHIQUERY_SQLITE", command)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(command, "-bail", db)
+	cmd.Stdin = strings.NewReader(recordScript(query, datas, opts, time.Now()))
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Couldn't record the run to %s: %v %s", db, err, strings.TrimSpace(stderr.String()))
+	}
+	logger.Debug("Recorded run", "database", db, "matches", len(datas))
+	return nil
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+func isRemote(repository string) bool {
+	return strings.HasPrefix(repository, "https://") || strings.HasPrefix(repository, "http://") || strings.HasPrefix(repository, "s3://")
+}
+
+// listDatasets returns the dataset file names of a local directory, an
+// http(s) directory listing, or an s3 prefix. Dotfiles aren't datasets, e.g.
+// the repositoryConfig or the partial downloads of the daemon, and neither
+// are the subdirectories of a local directory.
+func listDatasets(repository string) ([]string, error) {
+	if !isRemote(repository) {
+		infos, err := ioutil.ReadDir(repository)
+		if err != nil {
+			return nil, err
+		}
+
+		var names []string
+		for _, info := range infos {
+			if !info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
+				names = append(names, info.Name())
+			}
+		}
+		return names, nil
+	}
+
+	files, err := cachedListing(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range files {
+		if !strings.HasPrefix(name, ".") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// hasRepositoryConfig reports whether repository has a repositoryConfig.
+func hasRepositoryConfig(repository string) bool {
+	if !isRemote(repository) {
+		_, err := os.Stat(path.Join(repository, repositoryConfig))
+		return err == nil
+	}
+
+	files, err := cachedListing(repository)
+	_, ok := files[repositoryConfig]
+	return err == nil && ok
+}
+
+// openRepositoryDataset opens the dataset name of repository, decompressed.
+func openRepositoryDataset(repository, name string) (io.ReadCloser, error) {
+	if !isRemote(repository) {
+		return openDataset(path.Join(repository, name))
+	}
+
+	files, err := cachedListing(repository)
+	if err != nil {
+		return nil, err
+	}
+	u, ok := files[name]
+	if !ok {
+		return nil, fmt.Errorf("Couldn't find dataset %s in %s", name, repository)
+	}
+
+	body, err := fetch(u, repository)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(body)
+}
+
+var listings = struct {
+	sync.Mutex
+	files map[string]map[string]string
+}{files: map[string]map[string]string{}}
+
+// cachedListing lists a remote repository once per run.
+func cachedListing(repository string) (map[string]string, error) {
+	listings.Lock()
+	defer listings.Unlock()
+
+	if files, ok := listings.files[repository]; ok {
+		return files, nil
+	}
+	files, err := listRemote(repository)
+	if err != nil {
+		return nil, err
+	}
+	listings.files[repository] = files
+	return files, nil
+}
+
+// datasetLink matches the links of directory listings, like the ones of
+// nginx autoindex or python -m http.server.
+var datasetLink = regexp.MustCompile(`href="([^"?#]+\.csv(?:\.gz|\.zst)?)"`)
+
+// listRemote maps the dataset names of a remote repository to their url.
+func listRemote(repository string) (map[string]string, error) {
+	if strings.HasPrefix(repository, "s3://") {
+		return listS3(repository)
+	}
+
+	base, err := url.Parse(strings.TrimSuffix(repository, "/") + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Get(base.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s responded %s", base, resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]string{}
+	for _, match := range datasetLink.FindAllStringSubmatch(string(b), -1) {
+		link, err := base.Parse(match[1])
+		if err != nil || path.Dir(link.Path) != path.Clean(base.Path) {
+			continue
+		}
+		files[path.Base(link.Path)] = link.String()
+	}
+	return files, nil
+}
+
+// s3Endpoint returns the url of key in bucket, from $AWS_ENDPOINT_URL for
+// S3 compatible stores.
+func s3Endpoint(bucket, key string) string {
+	escaped := (&url.URL{Path: "/" + key}).EscapedPath()
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		return strings.TrimSuffix(endpoint, "/") + "/" + bucket + escaped
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com%s", bucket, s3Region(), escaped)
+}
+
+func s3Region() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	if region := os.Getenv("AWS_DEFAULT_REGION"); region != "" {
+		return region
+	}
+	return "us-east-1"
+}
+
+func listS3(repository string) (map[string]string, error) {
+	bucket, prefix := strings.TrimPrefix(repository, "s3://"), ""
+	if i := strings.IndexByte(bucket, '/'); i >= 0 {
+		bucket, prefix = bucket[:i], strings.TrimSuffix(bucket[i+1:], "/")
+	}
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	files := map[string]string{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {"/"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		req, err := http.NewRequest("GET", s3Endpoint(bucket, "")+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := httpClient.Do(signS3(req))
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return nil, fmt.Errorf("%s responded %s", repository, resp.Status)
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			if name := strings.TrimPrefix(object.Key, prefix); name != "" {
+				files[name] = s3Endpoint(bucket, object.Key)
+			}
+		}
+
+		if !result.IsTruncated {
+			return files, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// signS3 signs req with AWS Signature Version 4 using $AWS_ACCESS_KEY_ID,
+// $AWS_SECRET_ACCESS_KEY, and $AWS_SESSION_TOKEN. Requests to public
+// buckets are sent unsigned when no credentials are set.
+func signS3(req *http.Request) *http.Request {
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return req
+	}
+
+	now := time.Now().UTC()
+	amzDate, date := now.Format("20060102T150405Z"), now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+
+	signed := []string{"host"}
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			signed = append(signed, lower)
+		}
+	}
+	sort.Strings(signed)
+
+	var headers strings.Builder
+	for _, name := range signed {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		fmt.Fprintf(&headers, "%s:%s\n", name, strings.TrimSpace(value))
+	}
+
+	// url.Values.Encode sorts by key but escapes spaces as +
+	query := strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20")
+	canonical := strings.Join([]string{
+		req.Method, req.URL.EscapedPath(), query, headers.String(), strings.Join(signed, ";"), emptySHA256,
+	}, "\n")
+
+	hash := sha256.Sum256([]byte(canonical))
+	scope := date + "/" + s3Region() + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	key := mac([]byte("AWS4"+secretKey), date)
+	for _, part := range []string{s3Region(), "s3", "aws4_request"} {
+		key = mac(key, part)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, strings.Join(signed, ";"), hex.EncodeToString(mac(key, toSign))))
+	return req
+}
+
+// fetch streams the remote file u. With $ZHIQUERY_CACHE set, files are
+// kept in that directory and only downloaded again once their ETag changes.
+func fetch(u, repository string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := os.Getenv("ZHIQUERY_CACHE")
+	var cached string
+	if cache != "" {
+		sum := sha256.Sum256([]byte(u))
+		cached = path.Join(cache, hex.EncodeToString(sum[:8])+"-"+path.Base(req.URL.Path))
+		if _, err := os.Stat(cached); err == nil {
+			if etag, err := ioutil.ReadFile(cached + ".etag"); err == nil {
+				req.Header.Set("If-None-Match", string(etag))
+			}
+		}
+	}
+
+	if strings.HasPrefix(repository, "s3://") {
+		req = signS3(req)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != "" {
+		resp.Body.Close()
+		logger.Debug("Using cached dataset", "url", u, "path", cached)
+		return os.Open(cached)
+	}
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s responded %s", u, resp.Status)
+	}
+	if cached == "" {
+		return resp.Body, nil
+	}
+	defer resp.Body.Close()
+
+	if err := os.MkdirAll(cache, 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(cache, ".download")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), cached); err != nil {
+		return nil, err
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		if err := ioutil.WriteFile(cached+".etag", []byte(etag), 0644); err != nil {
+			return nil, err
+		}
+	}
+	return os.Open(cached)
+}
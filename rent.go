@@ -0,0 +1,37 @@
+package main
+
+import "math"
+
+// rentJoin is the join holding the rents of a zip code, --rent or a --join
+// named Rent, e.g. a ZORI export.
+const rentJoin = "Rent"
+
+// setYield sets the gross rental yield of d, its yearly rent over its
+// price, and its cap rate, the yield left once expenseRatio percent of the
+// rent goes to taxes, insurance, maintenance, and vacancies. Both stay 0
+// without a rent.
+func (d *Data) setYield(expenseRatio float64) {
+	rent := d.Joined[rentJoin]
+	if rent == nil || rent.Price() == 0 || d.Price() == 0 {
+		return
+	}
+
+	d.Yield = rent.Price() * 12 / d.Price() * 100
+	d.CapRate = d.Yield * (1 - expenseRatio/100)
+}
+
+// rentalYield and capRate are NaN without a rent so that comparisons don't
+// match zip codes missing from the rents.
+func rentalYield(d *Data) float64 {
+	if d.Yield == 0 {
+		return math.NaN()
+	}
+	return d.Yield
+}
+
+func capRate(d *Data) float64 {
+	if d.Yield == 0 {
+		return math.NaN()
+	}
+	return d.CapRate
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// reportCharts is the number of top results getting their own chart in a
+// --report.
+const reportCharts = 5
+
+// reportFields are the columns of the table of a --report without
+// --fields.
+const reportFields = "ZipCode,City,State,County,Dataset,GrowthRate,YoY,Years,Price"
+
+type reportStat struct {
+	Name  string
+	Value string
+}
+
+type reportCell struct {
+	Text string
+	// Sort is the value the column sorts by, numbers sort numerically.
+	Sort    string
+	Numeric bool
+}
+
+type reportChart struct {
+	Title string
+	SVG   template.HTML
+}
+
+type reportPage struct {
+	Query     string
+	Sort      string
+	Generated string
+	Stats     []reportStat
+	Columns   []string
+	Rows      [][]reportCell
+	Charts    []reportChart
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>zhiquery report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #333; }
+code { background: #f3f3f3; padding: 0.2em 0.4em; }
+.stats { display: flex; flex-wrap: wrap; gap: 1em; margin: 1em 0; }
+.stat { border: 1px solid #ddd; padding: 0.5em 1em; }
+.stat b { display: block; font-size: 1.4em; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border-bottom: 1px solid #ddd; padding: 0.3em 0.8em; text-align: left; }
+th { cursor: pointer; background: #f3f3f3; }
+td.number { text-align: right; }
+.chart svg { width: 100%; max-width: 960px; height: auto; }
+</style>
+</head>
+<body>
+<h1>zhiquery report</h1>
+<p>Query <code>{{.Query}}</code> sorted by {{.Sort}}, generated {{.Generated}}.</p>
+<div class="stats">
+{{range .Stats}}<div class="stat">{{.Name}}<b>{{.Value}}</b></div>
+{{end}}</div>
+<table id="results">
+<thead><tr>{{range $i, $c := .Columns}}<th onclick="sortBy({{$i}})">{{$c}}</th>{{end}}</tr></thead>
+<tbody>
+{{range .Rows}}<tr>{{range .}}<td{{if .Numeric}} class="number"{{end}} data-sort="{{.Sort}}">{{.Text}}</td>{{end}}</tr>
+{{end}}</tbody>
+</table>
+{{range .Charts}}<h2>{{.Title}}</h2>
+<div class="chart">{{.SVG}}</div>
+{{end}}<script>
+var descending = {};
+function sortBy(column) {
+  var body = document.querySelector("#results tbody");
+  var rows = Array.prototype.slice.call(body.rows);
+  var desc = descending[column] = !descending[column];
+  var numeric = rows.length > 0 && rows[0].cells[column].classList.contains("number");
+  rows.sort(function (a, b) {
+    var x = a.cells[column].dataset.sort, y = b.cells[column].dataset.sort;
+    var order = numeric ? parseFloat(x) - parseFloat(y) : x.localeCompare(y);
+    return desc ? -order : order;
+  });
+  rows.forEach(function (row) { body.appendChild(row); });
+}
+</script>
+</body>
+</html>
+`))
+
+// writeReport writes datas as a self-contained HTML page: the query, summary
+// statistics, a table sortable by clicking its headers, and the price
+// history charts of the first results.
+func writeReport(w io.Writer, query string, datas []Data, opts options) error {
+	raw := opts.columns
+	if raw == nil {
+		selected, err := parseFields(reportFields, opts.vocabulary)
+		if err != nil {
+			return err
+		}
+		raw = selected
+	}
+	columns := opts.formatColumns(raw)
+	if query == "" {
+		query = "every zip code"
+	}
+
+	page := reportPage{
+		Query:     query,
+		Sort:      opts.sort,
+		Generated: time.Now().Format("2006-01-02 15:04"),
+		Stats:     reportStats(datas, opts),
+	}
+	for _, c := range columns {
+		page.Columns = append(page.Columns, c.name)
+	}
+	for i := range datas {
+		row := make([]reportCell, len(columns))
+		for j, c := range columns {
+			// sort by the values before --price-format and --precision
+			v := raw[j].value(&datas[i])
+			row[j] = reportCell{Text: formatValue(c.value(&datas[i])), Sort: fmt.Sprint(v)}
+			switch v := v.(type) {
+			case float64:
+				row[j].Numeric = true
+				if !opts.precise && opts.priceFormat == "" {
+					row[j].Text = strconv.FormatFloat(v, 'f', 2, 64)
+				}
+			case int, uint64:
+				row[j].Numeric = true
+			}
+		}
+		page.Rows = append(page.Rows, row)
+	}
+	for i := range datas[:min(len(datas), reportCharts)] {
+		d := &datas[i]
+		var svg bytes.Buffer
+		writeSVGChart(&svg, datas[i:i+1])
+		page.Charts = append(page.Charts, reportChart{
+			Title: fmt.Sprintf("%d %s, %s (%s)", d.ZipCode, d.City, d.State, d.Dataset),
+			SVG:   template.HTML(svg.String()),
+		})
+	}
+	return reportTemplate.Execute(w, page)
+}
+
+// reportStats summarizes the prices and growth rates of datas.
+func reportStats(datas []Data, opts options) []reportStat {
+	var prices, growths, yoys []float64
+	datasets := map[string]bool{}
+	for i := range datas {
+		prices = append(prices, datas[i].Price())
+		growths = append(growths, datas[i].GrowthRate)
+		yoys = append(yoys, datas[i].YoY)
+		datasets[datas[i].Dataset] = true
+	}
+
+	percent := func(v float64) string {
+		if math.IsNaN(v) {
+			return "-"
+		}
+		return fmt.Sprintf("%.2f%%", v)
+	}
+	money := func(v float64) string {
+		if math.IsNaN(v) {
+			return "-"
+		}
+		return opts.money(v, "comma")
+	}
+	return []reportStat{
+		{"Zip codes", fmt.Sprint(len(datas))},
+		{"Datasets", fmt.Sprint(len(datasets))},
+		{"Median price", money(median(prices))},
+		{"Price range", money(minOf(prices)) + " to " + money(maxOf(prices))},
+		{"Median growth rate", percent(median(growths))},
+		{"Median YoY", percent(median(yoys))},
+	}
+}
+
+// median returns the median of vs, NaN when empty. vs is sorted.
+func median(vs []float64) float64 {
+	if len(vs) == 0 {
+		return math.NaN()
+	}
+	sort.Float64s(vs)
+	if len(vs)%2 == 1 {
+		return vs[len(vs)/2]
+	}
+	return (vs[len(vs)/2-1] + vs[len(vs)/2]) / 2
+}
+
+func minOf(vs []float64) float64 {
+	m := math.NaN()
+	for _, v := range vs {
+		if math.IsNaN(m) || v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+func maxOf(vs []float64) float64 {
+	m := math.NaN()
+	for _, v := range vs {
+		if math.IsNaN(m) || v > m {
+			m = v
+		}
+	}
+	return m
+}
+
+// writeReportFile writes the report of writeReport to p.
+func writeReportFile(p, query string, datas []Data, opts options) error {
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := writeReport(f, query, datas, opts); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,94 @@
+package main
+
+// resamplePeriods map a YYYY-MM month to the label of its period.
+var resamplePeriods = map[string]func(month string) string{
+	"quarterly": func(month string) string {
+		if len(month) != 7 {
+			return month
+		}
+		quarter := (int(month[5]-'0')*10+int(month[6]-'0')-1)/3 + 1
+		return month[:4] + "-Q" + string(rune('0'+quarter))
+	},
+	"yearly": func(month string) string {
+		if len(month) < 4 {
+			return month
+		}
+		return month[:4]
+	},
+}
+
+var resamplePerYear = map[string]int{
+	"quarterly": 4,
+	"yearly":    1,
+}
+
+// resampleMethods reduce the values of a period, 0 being a missing value.
+var resampleMethods = map[string]func(vs []float64) float64{
+	"last": func(vs []float64) float64 {
+		for i := len(vs) - 1; i >= 0; i-- {
+			if vs[i] != 0 {
+				return vs[i]
+			}
+		}
+		return 0
+	},
+	"mean": func(vs []float64) float64 {
+		var a average
+		for _, v := range vs {
+			if v != 0 {
+				a.add(v)
+			}
+		}
+		return a.value()
+	},
+}
+
+// resampler downsamples the series of a dataset, whose periods are the same
+// for every row.
+type resampler struct {
+	labels  []string
+	perYear int
+	// starts holds the index of the first month of each period
+	starts []int
+	reduce func(vs []float64) float64
+}
+
+func newResampler(months []string, opts options) *resampler {
+	period := resamplePeriods[opts.resample]
+	if period == nil {
+		return &resampler{labels: months, perYear: 12}
+	}
+
+	r := &resampler{perYear: resamplePerYear[opts.resample], reduce: resampleMethods[opts.resampleBy]}
+	for i, month := range months {
+		label := period(month)
+		if len(r.labels) == 0 || r.labels[len(r.labels)-1] != label {
+			r.labels = append(r.labels, label)
+			r.starts = append(r.starts, i)
+		}
+	}
+	return r
+}
+
+func (r *resampler) resample(vs []float64) []float64 {
+	if r.reduce == nil {
+		return vs
+	}
+
+	resampled := make([]float64, len(r.starts))
+	for i, start := range r.starts {
+		end := len(vs)
+		if i+1 < len(r.starts) {
+			end = r.starts[i+1]
+		}
+		if start >= len(vs) {
+			break
+		}
+		if end > len(vs) {
+			end = len(vs)
+		}
+
+		resampled[i] = r.reduce(vs[start:end])
+	}
+	return resampled
+}
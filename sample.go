@@ -0,0 +1,29 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand"
+)
+
+// newSampler returns whether to parse the next row of dataset, keeping a
+// random --sample fraction of its rows, or nil without --sample. Each
+// dataset gets its own source seeded from --seed and its name, so samples
+// don't depend on the order the datasets are parsed in.
+func newSampler(dataset string, opts options) func() bool {
+	if opts.sample <= 0 || opts.sample >= 1 {
+		return nil
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(dataset))
+	r := rand.New(rand.NewSource(opts.seed ^ int64(h.Sum64())))
+	return func() bool { return r.Float64() < opts.sample }
+}
+
+// estimate scales a count of sampled rows to the whole datasets.
+func estimate(n int, opts options) int {
+	if opts.sample <= 0 || opts.sample >= 1 {
+		return n
+	}
+	return int(float64(n)/opts.sample + 0.5)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const defaultRepository = "dataset"
+
+func repository() string {
+	if repository := os.Getenv("ZHIQUERY_REPOSITORY"); repository != "" {
+		return repository
+	}
+
+	return defaultRepository
+}
+
+var repositoryList = regexp.MustCompile(`(?:https?|s3)://[^/:]*(?::[0-9]+)?[^:]*|[^:]+`)
+
+// splitRepositories splits a colon separated list of repositories, like
+// $PATH, keeping the colons of http(s) and s3 urls.
+func splitRepositories(repositories string) []string {
+	return repositoryList.FindAllString(repositories, -1)
+}
+
+// repository returns the repositories of --data as a list for loadWith,
+// or fallback without --data.
+func (o *options) repository(fallback string) string {
+	if len(o.data) == 0 {
+		return fallback
+	}
+	return strings.Join(o.data, ":")
+}
+
+func configDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(dir, "zhiquery"), nil
+}
+
+func savedQueriesPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(dir, "queries.json"), nil
+}
+
+func loadSavedQueries() (map[string][]string, error) {
+	p, err := savedQueriesPath()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return map[string][]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	queries := map[string][]string{}
+	if err := json.Unmarshal(b, &queries); err != nil {
+		return nil, fmt.Errorf("Invalid saved queries in %s: %v", p, err)
+	}
+	return queries, nil
+}
+
+func storeSavedQueries(queries map[string][]string) error {
+	p, err := savedQueriesPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(queries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, b, 0644)
+}
+
+// composeQuery narrows a saved query with extra ad-hoc tokens:
+// [ <saved> and [ <extra> ] ]
+func composeQuery(saved, extra []string) []string {
+	if len(extra) == 0 {
+		return saved
+	}
+
+	tokens := []string{tokenGroupStart}
+	tokens = append(tokens, saved...)
+	tokens = append(tokens, "and", tokenGroupStart)
+	tokens = append(tokens, extra...)
+	return append(tokens, tokenGroupEnd, tokenGroupEnd)
+}
+
+func saveCmd(args []string) {
+	if len(args) < 2 {
+		usage("save")
+		os.Exit(exitUsage)
+	}
+
+	name, tokens := args[0], tokenize(args[1:])
+	_, _, err := parseFilters(tokens, nil)
+	must(err)
+
+	queries, err := loadSavedQueries()
+	must(err)
+	queries[name] = tokens
+	must(storeSavedQueries(queries))
+}
+
+func runCmd(args []string) {
+	var opts options
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	opts.register(fs)
+	args = parseArgs(fs, args)
+
+	queries, err := loadSavedQueries()
+	must(err)
+
+	if len(args) == 0 {
+		var names []string
+		for name := range queries {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Println(name, tokensString(queries[name]))
+		}
+		return
+	}
+
+	saved, ok := queries[args[0]]
+	if !ok {
+		must(fmt.Errorf("Couldn't find saved query %s", args[0]))
+	}
+
+	query(opts.repository(repository()), composeQuery(saved, tokenize(args[1:])), opts)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"sort"
+)
+
+// fuzzyKinds are the string filters that also take ~= followed by a name to
+// match similar names.
+var fuzzyKinds = map[string]bool{"County": true, "City": true, "Neighborhood": true}
+
+// customArgs describe the arguments of customFilters.
+var customArgs = map[string]string{
+	"Near":      "<zip_code>:<distance>",
+	"BBox":      "<min_lat>,<min_lng>,<max_lat>,<max_lng>",
+	"Adjacent":  "<zip_code>",
+	"ZipPrefix": "<digits>",
+}
+
+// schemaFilter is a filter kind of a schema. Type is the type of the
+// argument: string, float and uint filters take a value they compare the
+// field to with Bound, comparison filters take one of Operators followed by
+// a number, and custom filters parse their own argument, see Arg.
+type schemaFilter struct {
+	Kind      string   `json:"kind"`
+	Type      string   `json:"type"`
+	Bound     string   `json:"bound,omitempty"`
+	Operators []string `json:"operators,omitempty"`
+	// Fuzzy marks string filters taking ~= followed by a name.
+	Fuzzy bool   `json:"fuzzy,omitempty"`
+	Arg   string `json:"arg,omitempty"`
+	// Aggregate marks filters on values that depend on the whole result
+	// set, see comparisonField.
+	Aggregate bool `json:"aggregate,omitempty"`
+}
+
+// schema is the vocabulary of queries, for the UIs and completion scripts
+// generated from it.
+type schema struct {
+	Query struct {
+		GroupStart string   `json:"groupStart"`
+		GroupEnd   string   `json:"groupEnd"`
+		Operators  []string `json:"operators"`
+	} `json:"query"`
+	Filters []schemaFilter `json:"filters"`
+	// Fields are the numeric fields of --score and --define expressions,
+	// and Aliases their other names.
+	Fields        []string          `json:"fields"`
+	Aliases       map[string]string `json:"aliases"`
+	SortKeys      []string          `json:"sortKeys"`
+	Columns       []string          `json:"columns"`
+	Formats       []string          `json:"formats"`
+	SeriesFormats []string          `json:"seriesFormats"`
+}
+
+// sortedNames returns the keys of m in order.
+func sortedNames[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// querySchema describes the filters and fields of every query, the
+// registered metrics included, and those v adds with --join and --define.
+func querySchema(v *vocabulary) schema {
+	var s schema
+	s.Query.GroupStart, s.Query.GroupEnd = tokenGroupStart, tokenGroupEnd
+	s.Query.Operators = []string{"and", "or"}
+
+	for _, kind := range sortedNames(stringFilters) {
+		s.Filters = append(s.Filters, schemaFilter{Kind: kind, Type: "string", Bound: "=", Fuzzy: fuzzyKinds[kind]})
+	}
+	for _, kind := range sortedNames(floatFilters) {
+		s.Filters = append(s.Filters, schemaFilter{Kind: kind, Type: "float", Bound: floatFilterBounds[kind]})
+	}
+	for _, kind := range sortedNames(uintFilters) {
+		s.Filters = append(s.Filters, schemaFilter{Kind: kind, Type: "uint", Bound: "="})
+	}
+	fields := allFields(v)
+	for _, kind := range sortedNames(fields.comparisonFilters) {
+		s.Filters = append(s.Filters, schemaFilter{Kind: kind, Type: "comparison", Operators: sortedNames(comparisonOperators), Aggregate: fields.comparisonFilters[kind].aggregate})
+	}
+	for _, kind := range sortedNames(customFilters) {
+		s.Filters = append(s.Filters, schemaFilter{Kind: kind, Type: "custom", Arg: customArgs[kind]})
+	}
+	for _, kind := range providerKinds() {
+		s.Filters = append(s.Filters, schemaFilter{Kind: kind, Type: "custom"})
+	}
+	sort.SliceStable(s.Filters, func(i, j int) bool { return s.Filters[i].Kind < s.Filters[j].Kind })
+
+	s.Fields = sortedNames(fields.numericFields)
+	s.Aliases = fieldAliases
+	s.SortKeys = sortedNames(fields.sortKeys)
+	for _, c := range fields.columns {
+		s.Columns = append(s.Columns, c.name)
+	}
+	s.Formats = sortedNames(formats)
+	s.SeriesFormats = sortedNames(seriesFormats)
+	return s
+}
+
+func schemaCmd(args []string) {
+	var opts options
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	opts.register(fs)
+	if args = parseArgs(fs, args); len(args) != 0 {
+		usage("schema")
+		os.Exit(exitUsage)
+	}
+	must(usageError(opts.prepare()))
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	must(enc.Encode(querySchema(opts.vocabulary)))
+}
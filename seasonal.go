@@ -0,0 +1,62 @@
+package main
+
+// seasonallyAdjust divides the values of vs by the seasonal factor of their
+// period of the year, the mean ratio of the values of that period to their
+// centered yearly moving average, like a single pass of X-11. Series shorter
+// than 3 years, or yearly ones, have no seasonality to remove and are
+// returned as they are, and so are missing values.
+func seasonallyAdjust(vs []float64, perYear int) []float64 {
+	if perYear < 2 || len(vs) < 3*perYear {
+		return vs
+	}
+
+	ratios := make([]average, perYear)
+	half := perYear / 2
+	for i := half; i < len(vs)-half; i++ {
+		if vs[i] == 0 {
+			continue
+		}
+		if ma := centeredAverage(vs, i, perYear); ma > 0 {
+			ratios[i%perYear].add(vs[i] / ma)
+		}
+	}
+
+	// normalize the factors so that they don't change the yearly level
+	factors := make([]float64, perYear)
+	var mean average
+	for i := range ratios {
+		factors[i] = ratios[i].value()
+		if factors[i] == 0 {
+			return vs
+		}
+		mean.add(factors[i])
+	}
+
+	adjusted := make([]float64, len(vs))
+	for i, v := range vs {
+		if v != 0 {
+			adjusted[i] = v / (factors[i%perYear] / mean.value())
+		}
+	}
+	return adjusted
+}
+
+// centeredAverage returns the moving average of a year of values centered on
+// i, the 2x12 average of monthly series whose first and last months weigh
+// half, or 0 when a value is missing.
+func centeredAverage(vs []float64, i, perYear int) float64 {
+	half := perYear / 2
+	var sum, weights float64
+	for j := i - half; j <= i+half; j++ {
+		if vs[j] == 0 {
+			return 0
+		}
+		w := 1.0
+		if perYear%2 == 0 && (j == i-half || j == i+half) {
+			w = 0.5
+		}
+		sum += w * vs[j]
+		weights += w
+	}
+	return sum / weights
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"embed"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed web
+var webAssets embed.FS
+
+// maxResults bounds the rows of a response unless the request sets limit.
+const maxResults = 500
+
+// server answers queries from the rows of a repository loaded in memory,
+// and serves the dashboard in web/. The rows of a dataset file are loaded
+// again once its fingerprint changes, e.g. after a refresh of the daemon,
+// and the results of queries are cached until then.
+type server struct {
+	repository string
+	opts       options
+	cache      *resultCache
+	metrics    *metrics
+
+	// mu guards the loaded rows, which reloads swap at once so that queries
+	// never see a partial reload. reloading serializes reloads.
+	mu          sync.RWMutex
+	reloading   sync.Mutex
+	p           *population
+	datas       []Data
+	fingerprint string
+
+	// files and rows are the fingerprints and rows of each dataset file as
+	// of the last reload, by repositoryDataset.path, and failed the
+	// fingerprint of the last reload that failed, all guarded by reloading.
+	files  map[string]string
+	rows   map[string][]Data
+	failed string
+}
+
+// newServer loads the rows of repository, failing unlike the reloads, which
+// keep the rows loaded before.
+func newServer(repository string, opts options, ttl time.Duration) (*server, error) {
+	s := &server{repository: repository, opts: opts, cache: newResultCache(ttl), metrics: newMetrics(serverMetrics)}
+	files, err := fileFingerprints(repository, opts.snapshot)
+	if err != nil {
+		return nil, datasetError(err)
+	}
+	if err := s.load(files); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load parses the dataset files whose fingerprint isn't the one in
+// s.files, reusing the rows of the others. Joined and merged datasets feed
+// the rows of other files, so every file is parsed again when the server
+// has any. The loaded rows are only replaced when every file is parsed.
+func (s *server) load(files map[string]string) error {
+	start := time.Now()
+	datasets, opts, err := listQueried(s.repository, s.opts)
+	if err != nil {
+		return err
+	}
+	incremental := len(s.opts.joins) == 0 && len(s.opts.merges) == 0
+
+	rows := make(map[string][]Data, len(datasets))
+	var changed []repositoryDataset
+	for _, dataset := range datasets {
+		p := dataset.path()
+		previous, ok := s.rows[p]
+		if incremental && ok && s.files[p] == files[p] {
+			rows[p] = previous
+			continue
+		}
+		changed = append(changed, dataset)
+	}
+	loaded, err := loadDatasets(changed, matchAll, opts, nil)
+	if err != nil {
+		return err
+	}
+	for i, datasetDatas := range loaded {
+		rows[changed[i].path()] = datasetDatas
+	}
+
+	// the population covers every row, reused or not
+	p := newPopulation()
+	var datas []Data
+	for _, dataset := range datasets {
+		datasetDatas := rows[dataset.path()]
+		for i := range datasetDatas {
+			p.observe(&datasetDatas[i])
+		}
+		datas = append(datas, datasetDatas...)
+	}
+
+	s.mu.Lock()
+	s.p, s.datas, s.fingerprint = p, datas, fingerprintOf(files)
+	s.mu.Unlock()
+	s.files, s.rows = files, rows
+	s.cache.clear()
+
+	s.metrics.reset("zhiquery_dataset_rows")
+	for _, dataset := range datasets {
+		s.metrics.set("zhiquery_dataset_rows", labels("repository", dataset.repository, "dataset", dataset.name), float64(len(rows[dataset.path()])))
+	}
+	s.metrics.add("zhiquery_dataset_reloads_total", labels("result", "ok"), 1)
+	s.metrics.set("zhiquery_dataset_last_reload_timestamp_seconds", "", float64(time.Now().Unix()))
+	logger.Info("Loaded datasets", "repository", s.repository, "rows", len(datas), "parsed", len(changed), "reused", len(datasets)-len(changed), "duration", time.Since(start))
+	return nil
+}
+
+// reloadIfChanged loads the files whose fingerprint changed since they
+// were loaded. A reload that fails keeps the rows loaded before, and isn't
+// tried again until the files change again.
+func (s *server) reloadIfChanged() {
+	files, err := fileFingerprints(s.repository, s.opts.snapshot)
+	if err != nil {
+		logger.Warn("Couldn't fingerprint datasets", "repository", s.repository, "error", err)
+		return
+	}
+
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+	fp := fingerprintOf(files)
+	if _, _, current := s.snapshot(); current != fp && s.failed != fp {
+		logger.Info("Reloading changed datasets", "repository", s.repository)
+		if err := s.load(files); err != nil {
+			s.failed = fp
+			s.metrics.add("zhiquery_dataset_reloads_total", labels("result", "error"), 1)
+			logger.Error("Couldn't reload datasets, serving the rows loaded before", "repository", s.repository, "error", err)
+		}
+	}
+}
+
+func (s *server) snapshot() (*population, []Data, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.p, s.datas, s.fingerprint
+}
+
+// query runs q, a query like on the command line with or without the
+// surrounding brackets, over the loaded rows.
+func (s *server) query(q, sortKey string) ([]Data, error) {
+	q = strings.TrimSpace(q)
+	if q == "" {
+		q = "[ ]"
+	} else if !strings.HasPrefix(q, tokenGroupStart) {
+		q = tokenGroupStart + " " + q + " " + tokenGroupEnd
+	}
+	if sortKey == "" {
+		sortKey = s.opts.sort
+	}
+
+	s.reloadIfChanged()
+	p, datas, fp := s.snapshot()
+	tokens := tokenize([]string{q})
+	key := cacheKey{tokensString(tokens), sortKey, fp}
+	if results, ok := s.cache.get(key); ok {
+		logger.Debug("Answered query from the cache", "query", key.query, "sort", sortKey)
+		return results, nil
+	}
+
+	var tree *filterNode
+	if q != "[ ]" {
+		parsed, _, err := parseFilterTree(tokens, s.opts.vocabulary)
+		if err != nil {
+			return nil, err
+		}
+		tree = parsed
+	}
+	tree = s.opts.restrict(tree)
+	filter := FilterFn(func(*Data) bool { return true })
+	if tree != nil {
+		filter = tree.filter
+	}
+
+	var matched []Data
+	for i := range datas {
+		if filter(&datas[i]) {
+			matched = append(matched, datas[i])
+		}
+	}
+
+	results, err := p.aggregate(matched, filter, s.opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := sortDatas(results, sortKey, s.opts.vocabulary); err != nil {
+		return nil, err
+	}
+	s.cache.put(key, results)
+	return results, nil
+}
+
+func writeJSONResponse(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.Warn("Couldn't write response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSONResponse(w, status, map[string]string{"error": err.Error()})
+}
+
+// pageCursor is where the next page of the results of a query starts,
+// handed to clients as an opaque string so that they page through the
+// results without repeating the query, its sort, or its order.
+type pageCursor struct {
+	Query  string `json:"q"`
+	Sort   string `json:"s,omitempty"`
+	Desc   bool   `json:"d,omitempty"`
+	Offset int    `json:"o"`
+}
+
+func (c pageCursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func parseCursor(s string) (pageCursor, error) {
+	var c pageCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(b, &c)
+	}
+	if err != nil || c.Offset < 0 {
+		return c, fmt.Errorf("Invalid cursor %s", s)
+	}
+	return c, nil
+}
+
+// handleQuery answers GET /api/query?q=<query>&sort=<key>&desc=1&limit=<n>,
+// or GET /api/query?cursor=<next>&limit=<n> for the following pages, with
+// {"total": <matches>, "offset": <n>, "columns": [...], "results": [<json
+// records>], "next": <cursor>}, next being left out on the last page.
+func (s *server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	begin := time.Now()
+	fail := func(status int, err error) {
+		s.metrics.add("zhiquery_requests_total", labels("result", "error"), 1)
+		writeError(w, status, err)
+	}
+
+	params := r.URL.Query()
+	limit := maxResults
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			fail(http.StatusBadRequest, fmt.Errorf("limit expects a positive integer, got %s", v))
+			return
+		}
+		limit = n
+	}
+
+	// a cursor replaces the query, its sort, and its order
+	c := pageCursor{Query: params.Get("q"), Sort: params.Get("sort"), Desc: params.Get("desc") != ""}
+	if v := params.Get("cursor"); v != "" {
+		var err error
+		if c, err = parseCursor(v); err != nil {
+			fail(http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	// results are a copy of the cached ones, only the page is marshaled
+	results, err := s.query(c.Query, c.Sort)
+	if err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+	if c.Desc {
+		for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+			results[i], results[j] = results[j], results[i]
+		}
+	}
+	start := min(c.Offset, len(results))
+	page := results[start:min(start+limit, len(results))]
+
+	columns := s.opts.outputColumns()
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.name
+	}
+
+	records := make([]json.RawMessage, 0, len(page))
+	for i := range page {
+		record, err := marshalRecord(&page[i], columns)
+		if err != nil {
+			fail(http.StatusInternalServerError, err)
+			return
+		}
+		records = append(records, record)
+	}
+
+	body := map[string]interface{}{
+		"total":   len(results),
+		"offset":  start,
+		"columns": names,
+		"results": records,
+	}
+	if end := start + len(page); len(page) > 0 && end < len(results) {
+		c.Offset = end
+		body["next"] = c.String()
+	}
+	writeJSONResponse(w, http.StatusOK, body)
+	s.metrics.add("zhiquery_requests_total", labels("result", "ok"), 1)
+	s.metrics.observe("zhiquery_request_duration_seconds", "", time.Since(begin).Seconds())
+	s.metrics.observe("zhiquery_request_matches", "", float64(len(results)))
+}
+
+type seriesResponse struct {
+	Dataset    string    `json:"dataset"`
+	Repository string    `json:"repository,omitempty"`
+	Months     []string  `json:"months"`
+	Values     []float64 `json:"values"`
+}
+
+// handleSeries answers GET /api/series?zip=<zip_code> with the price
+// history of the zip code in every dataset.
+func (s *server) handleSeries(w http.ResponseWriter, r *http.Request) {
+	zipCode, err := strconv.ParseUint(r.URL.Query().Get("zip"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("zip expects a zip code"))
+		return
+	}
+
+	_, datas, _ := s.snapshot()
+	series := []seriesResponse{}
+	for i := range datas {
+		d := &datas[i]
+		if d.ZipCode == zipCode {
+			series = append(series, seriesResponse{d.Dataset, d.Repository, d.Months, d.ZHIs})
+		}
+	}
+	writeJSONResponse(w, http.StatusOK, series)
+}
+
+// handleSchema answers GET /schema with the vocabulary of queries, see
+// querySchema.
+func (s *server) handleSchema(w http.ResponseWriter, r *http.Request) {
+	writeJSONResponse(w, http.StatusOK, querySchema(s.opts.vocabulary))
+}
+
+// handleKinds answers GET /api/kinds with the filter kinds and sort keys
+// for the query builder.
+func (s *server) handleKinds(w http.ResponseWriter, r *http.Request) {
+	keys := sortedNames(allFields(s.opts.vocabulary).sortKeys)
+	writeJSONResponse(w, http.StatusOK, map[string][]string{"kinds": filterKinds(s.opts.vocabulary), "sortKeys": keys})
+}
+
+func (s *server) handler() http.Handler {
+	static, err := fs.Sub(webAssets, "web")
+	must(err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/query", s.handleQuery)
+	mux.HandleFunc("/api/series", s.handleSeries)
+	mux.HandleFunc("/api/kinds", s.handleKinds)
+	mux.HandleFunc("/schema", s.handleSchema)
+	mux.Handle("/metrics", s.metrics)
+	mux.Handle("/", http.FileServer(http.FS(static)))
+	return mux
+}
+
+func serveCmd(args []string) {
+	var opts options
+	var listen string
+	var cacheTTL time.Duration
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	opts.register(fs)
+	fs.StringVar(&listen, "listen", "localhost:8080", "")
+	fs.DurationVar(&cacheTTL, "cache-ttl", 10*time.Minute, "")
+	args = parseArgs(fs, args)
+
+	if len(args) > 1 {
+		usage("serve")
+		os.Exit(exitUsage)
+	}
+
+	must(usageError(opts.prepare()))
+	repo := opts.repository(repository())
+	if len(args) == 1 {
+		repo = args[0]
+	}
+
+	s, err := newServer(repo, opts, cacheTTL)
+	must(err)
+	fmt.Printf("Serving the dashboard on http://%s\n", listen)
+	must(http.ListenAndServe(listen, s.handler()))
+}
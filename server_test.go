@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPageCursor(t *testing.T) {
+	c := pageCursor{Query: "[ State:CA ]", Sort: "Price", Desc: true, Offset: 40}
+	parsed, err := parseCursor(c.String())
+	if err != nil || parsed != c {
+		t.Errorf("parseCursor(%s) = %+v, %v, want %+v", c, parsed, err, c)
+	}
+
+	for _, s := range []string{"", "not base64!", pageCursor{Offset: -1}.String()} {
+		if _, err := parseCursor(s); err == nil {
+			t.Errorf("parseCursor(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+// serveOptions are the options of serve without flags.
+func serveOptions(t *testing.T) options {
+	var opts options
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	opts.register(fs)
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	return opts
+}
+
+func TestReloadKeepsRowsOnError(t *testing.T) {
+	repository := testRepository(t)
+	// the files added later don't have the region column of their layout
+	config := `{"layouts": [{"pattern": "zips_*.csv", "region": "Zip"}]}`
+	if err := ioutil.WriteFile(filepath.Join(repository, repositoryConfig), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := serveOptions(t)
+	s, err := newServer(repository, opts, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := s.query("[ ]", "")
+	if err != nil || len(before) == 0 {
+		t.Fatalf("query = %d rows, %v", len(before), err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(repository, "zips_2024.csv"), []byte("RegionName,2024-01-31\n94110,1000000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	after, err := s.query("[ ]", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) {
+		t.Errorf("query after a failed reload = %d rows, want the %d loaded before", len(after), len(before))
+	}
+	if s.failed == "" {
+		t.Fatal("reloading a file without the columns of its layout succeeded")
+	}
+	if _, _, fp := s.snapshot(); fp == s.failed {
+		t.Error("the failed reload replaced the fingerprint of the loaded rows")
+	}
+
+	if _, err := newServer(filepath.Join(repository, "missing"), opts, time.Minute); err == nil {
+		t.Error("newServer of a missing dataset_dir succeeded, want an error")
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	repository := testRepository(t)
+	s, err := newServer(repository, serveOptions(t), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := s.handler()
+	get := func(url string) (int, string) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w.Code, w.Body.String()
+	}
+
+	for _, tt := range []struct {
+		url    string
+		status int
+	}{
+		{"/api/query?q=GrowthRate:-100", http.StatusOK},
+		{"/api/query?q=Unknown:1", http.StatusBadRequest},
+		{"/api/query?limit=-1", http.StatusBadRequest},
+	} {
+		if status, body := get(tt.url); status != tt.status {
+			t.Fatalf("GET %s = %d %s, want %d", tt.url, status, body, tt.status)
+		}
+	}
+
+	status, body := get("/metrics")
+	if status != http.StatusOK {
+		t.Fatalf("GET /metrics = %d", status)
+	}
+	for _, want := range []string{
+		`zhiquery_requests_total{result="ok"} 1`,
+		`zhiquery_requests_total{result="error"} 2`,
+		`zhiquery_request_duration_seconds_count 1`,
+		`zhiquery_request_matches_sum 50`,
+		`zhiquery_dataset_rows{repository="` + repository + `",dataset="Zip_zhvi.csv"} 50`,
+		`zhiquery_dataset_reloads_total{result="ok"} 1`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("GET /metrics has no %s:\n%s", want, body)
+		}
+	}
+}
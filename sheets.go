@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	sheetsEndpoint = "https://sheets.googleapis.com/v4/spreadsheets"
+	sheetsScope    = "https://www.googleapis.com/auth/spreadsheets"
+)
+
+type serviceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+func parsePrivateKey(key string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, fmt.Errorf("Invalid service account private key")
+	}
+
+	if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		rsaKey, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("Service account private key isn't an RSA key")
+		}
+		return rsaKey, nil
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// serviceAccountToken exchanges a JWT signed by the service account for an
+// access token.
+func serviceAccountToken(credentials string) (string, error) {
+	b, err := ioutil.ReadFile(credentials)
+	if err != nil {
+		return "", err
+	}
+
+	var account serviceAccount
+	if err := json.Unmarshal(b, &account); err != nil {
+		return "", fmt.Errorf("Invalid service account credentials in %s: %v", credentials, err)
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	key, err := parsePrivateKey(account.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+
+	encode := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	now := time.Now().Unix()
+	unsigned := encode(map[string]string{"alg": "RS256", "typ": "JWT"}) + "." + encode(map[string]interface{}{
+		"iss":   account.ClientEmail,
+		"scope": sheetsScope,
+		"aud":   account.TokenURI,
+		"iat":   now,
+		"exp":   now + 3600,
+	})
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := httpClient.PostForm(account.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("%s responded %s", account.TokenURI, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// sheetsToken returns an OAuth access token from $ZHIQUERY_SHEETS_TOKEN, or
+// for the service account of $GOOGLE_APPLICATION_CREDENTIALS.
+func sheetsToken() (string, error) {
+	if token := os.Getenv("ZHIQUERY_SHEETS_TOKEN"); token != "" {
+		return token, nil
+	}
+	if credentials := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); credentials != "" {
+		return serviceAccountToken(credentials)
+	}
+	return "", fmt.Errorf("--export-sheet needs $ZHIQUERY_SHEETS_TOKEN or $GOOGLE_APPLICATION_CREDENTIALS")
+}
+
+func sheetsRequest(method, url, token string, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Google Sheets responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func sheetValue(v interface{}) interface{} {
+	if f, ok := v.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+		return formatValue(f)
+	}
+	return v
+}
+
+// exportSheet replaces the content of the tab of the spreadsheet with the
+// columns of the csv and json formats.
+func exportSheet(spreadsheet, tab string, datas []Data, columns []column) error {
+	token, err := sheetsToken()
+	if err != nil {
+		return err
+	}
+
+	header := make([]interface{}, len(columns))
+	for i, c := range columns {
+		header[i] = c.name
+	}
+	values := [][]interface{}{header}
+	for i := range datas {
+		row := make([]interface{}, len(columns))
+		for j, c := range columns {
+			row[j] = sheetValue(c.value(&datas[i]))
+		}
+		values = append(values, row)
+	}
+
+	quoted := "'" + strings.ReplaceAll(tab, "'", "''") + "'"
+	base := sheetsEndpoint + "/" + url.PathEscape(spreadsheet) + "/values/"
+	if err := sheetsRequest("POST", base+url.PathEscape(quoted)+":clear", token, struct{}{}); err != nil {
+		return err
+	}
+	return sheetsRequest("PUT", base+url.PathEscape(quoted+"!A1")+"?valueInputOption=RAW", token, map[string]interface{}{
+		"range":          quoted + "!A1",
+		"majorDimension": "ROWS",
+		"values":         values,
+	})
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// isSnapshot reports whether name is the name of a snapshot directory, a
+// month, e.g. 2023-06, or a day, e.g. 2023-06-15.
+func isSnapshot(name string) bool {
+	for _, layout := range []string{"2006-01", "2006-01-02"} {
+		if _, err := time.Parse(layout, name); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshotOf is the snapshot of a resolved repository, "" when it isn't a
+// snapshot directory.
+func snapshotOf(repository string) string {
+	if name := path.Base(strings.TrimSuffix(repository, "/")); isSnapshot(name) {
+		return name
+	}
+	return ""
+}
+
+// snapshotRoot is the repository holding the snapshot directory of a
+// resolved repository.
+func snapshotRoot(repository string) string {
+	repository = strings.TrimSuffix(repository, "/")
+	switch i := strings.LastIndex(repository, "/"); i {
+	case -1:
+		return "."
+	case 0:
+		return "/"
+	default:
+		return repository[:i]
+	}
+}
+
+// listSnapshots returns the snapshot directories of a local repository from
+// the oldest to the latest, none when its datasets aren't in snapshots.
+func listSnapshots(repository string) ([]string, error) {
+	if isRemote(repository) {
+		return nil, nil
+	}
+	infos, err := ioutil.ReadDir(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []string
+	for _, info := range infos {
+		if info.IsDir() && isSnapshot(info.Name()) {
+			snapshots = append(snapshots, info.Name())
+		}
+	}
+	sort.Strings(snapshots)
+	return snapshots, nil
+}
+
+// resolveSnapshot returns the directory of the snapshot of repository,
+// its latest one when snapshot is "", or repository itself when its
+// datasets aren't in snapshots. Remote repositories can't be listed by
+// directory, so they only resolve to the snapshot given.
+func resolveSnapshot(repository, snapshot string) (string, error) {
+	if isRemote(repository) {
+		if snapshot == "" {
+			return repository, nil
+		}
+		return strings.TrimSuffix(repository, "/") + "/" + snapshot, nil
+	}
+
+	snapshots, err := listSnapshots(repository)
+	if err != nil || len(snapshots) == 0 {
+		if snapshot != "" && err == nil {
+			err = fmt.Errorf("Couldn't find snapshot %s of %s, it has no snapshot directories", snapshot, repository)
+		}
+		return repository, err
+	}
+	if snapshot == "" {
+		return path.Join(repository, snapshots[len(snapshots)-1]), nil
+	}
+	if i := sort.SearchStrings(snapshots, snapshot); i == len(snapshots) || snapshots[i] != snapshot {
+		return "", fmt.Errorf("Couldn't find snapshot %s of %s, it has %s", snapshot, repository, strings.Join(snapshots, ", "))
+	}
+	return path.Join(repository, snapshot), nil
+}
+
+// queriedRepositories splits repositories like splitRepositories and
+// resolves each to snapshot, "" for the latest one.
+func queriedRepositories(repositories, snapshot string) ([]string, error) {
+	var resolved []string
+	for _, repository := range splitRepositories(repositories) {
+		r, err := resolveSnapshot(repository, snapshot)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, r)
+	}
+	return resolved, nil
+}
+
+// commonSnapshots returns the snapshots every repository has, from the
+// oldest to the latest, for --all-snapshots.
+func commonSnapshots(repositories string) ([]string, error) {
+	counts := map[string]int{}
+	split := splitRepositories(repositories)
+	for _, repository := range split {
+		snapshots, err := listSnapshots(repository)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range snapshots {
+			counts[s]++
+		}
+	}
+
+	var common []string
+	for s, n := range counts {
+		if n == len(split) {
+			common = append(common, s)
+		}
+	}
+	if len(common) == 0 {
+		return nil, fmt.Errorf("Couldn't find a snapshot directory shared by %s for --all-snapshots", strings.Join(split, ", "))
+	}
+	sort.Strings(common)
+	return common, nil
+}
+
+// searchSnapshots runs search on every snapshot of repository, and orders
+// the rows of each zip code of a dataset from the oldest snapshot to the
+// latest, the zip codes in the order of --sort of their latest row. --limit
+// counts zip codes rather than rows.
+func searchSnapshots(repository string, filter FilterFn, opts options) ([]Data, error) {
+	snapshots, err := commonSnapshots(repository)
+	if err != nil {
+		return nil, err
+	}
+	var datas []Data
+	for _, s := range snapshots {
+		opts.snapshot = s
+		matched, err := search(repository, filter, opts)
+		if err != nil {
+			return nil, err
+		}
+		if opts.byHomeType {
+			matched = byHomeType(matched)
+		}
+		datas = append(datas, matched...)
+	}
+
+	type key struct {
+		repository, dataset string
+		zipCode             uint64
+		neighborhood        string
+	}
+	keyOf := func(d *Data) key {
+		return key{path.Dir(d.Repository), d.Dataset, d.ZipCode, d.Neighborhood}
+	}
+	latest := map[key]int{}
+	for i := range datas {
+		latest[keyOf(&datas[i])] = i
+	}
+	var latests []Data
+	for i := range datas {
+		if latest[keyOf(&datas[i])] == i {
+			latests = append(latests, datas[i])
+		}
+	}
+	if err := sortDatas(latests, opts.sort, opts.vocabulary); err != nil {
+		return nil, err
+	}
+	if opts.limit > 0 && len(latests) > opts.limit {
+		latests = latests[:opts.limit]
+	}
+
+	ranks := make(map[key]int, len(latests))
+	for i := range latests {
+		ranks[keyOf(&latests[i])] = i
+	}
+	tracked := datas[:0]
+	for _, d := range datas {
+		if _, ok := ranks[keyOf(&d)]; ok {
+			tracked = append(tracked, d)
+		}
+	}
+	sort.SliceStable(tracked, func(i, j int) bool {
+		a, b := ranks[keyOf(&tracked[i])], ranks[keyOf(&tracked[j])]
+		if a != b {
+			return a < b
+		}
+		return tracked[i].Snapshot < tracked[j].Snapshot
+	})
+	return tracked, nil
+}
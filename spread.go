@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+// spread is the history of the price ratio of two zip codes over the months
+// where both have a price.
+type spread struct {
+	current float64
+	mean    float64
+	stdDev  float64
+	min     float64
+	max     float64
+	// z is how many standard deviations current is from mean.
+	z      float64
+	months int
+}
+
+// calculateSpread returns the spread of a over b, aligned by month.
+func calculateSpread(a, b *Data) spread {
+	bs := make(map[string]float64, len(b.Months))
+	for i, month := range b.Months {
+		if i < len(b.ZHIs) {
+			bs[month] = b.ZHIs[i]
+		}
+	}
+
+	s := spread{current: math.NaN(), min: math.Inf(1), max: math.Inf(-1)}
+	var ratios []float64
+	for i, month := range a.Months {
+		if i >= len(a.ZHIs) || a.ZHIs[i] == 0 || bs[month] == 0 {
+			continue
+		}
+		ratio := a.ZHIs[i] / bs[month]
+		ratios = append(ratios, ratio)
+		s.current = ratio
+		s.min, s.max = math.Min(s.min, ratio), math.Max(s.max, ratio)
+	}
+	s.months = len(ratios)
+	if len(ratios) < 2 {
+		s.z = math.NaN()
+		return s
+	}
+
+	for _, r := range ratios {
+		s.mean += r
+	}
+	s.mean /= float64(len(ratios))
+	var variance float64
+	for _, r := range ratios {
+		variance += (r - s.mean) * (r - s.mean)
+	}
+	s.stdDev = math.Sqrt(variance / float64(len(ratios)-1))
+	s.z = (s.current - s.mean) / s.stdDev
+	return s
+}
+
+// signal flags a spread whose z-score is beyond threshold standard
+// deviations from its mean.
+func (s spread) signal(threshold float64) string {
+	switch {
+	case math.IsNaN(s.z) || math.IsInf(s.z, 0):
+		return "-"
+	case s.z >= threshold:
+		return "wide"
+	case s.z <= -threshold:
+		return "narrow"
+	default:
+		return "normal"
+	}
+}
+
+func spreadCmd(args []string) {
+	var threshold float64
+	fs := flag.NewFlagSet("spread", flag.ExitOnError)
+	fs.Float64Var(&threshold, "threshold", 2, "")
+	args = parseArgs(fs, args)
+
+	repo := repository()
+	if len(args) == 3 {
+		repo, args = args[0], args[1:]
+	}
+	if len(args) != 2 {
+		usage("spread")
+		os.Exit(exitUsage)
+	}
+
+	zipA, err := strconv.ParseUint(args[0], 10, 64)
+	must(usageError(err))
+	zipB, err := strconv.ParseUint(args[1], 10, 64)
+	must(usageError(err))
+
+	datas := load(repo, chainByOr(filterByZipCode(zipA), filterByZipCode(zipB)))
+	as, bs := map[string]*Data{}, map[string]*Data{}
+	for i := range datas {
+		if datas[i].ZipCode == zipA {
+			as[datas[i].Dataset] = &datas[i]
+		}
+		if datas[i].ZipCode == zipB {
+			bs[datas[i].Dataset] = &datas[i]
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Dataset\tRatio %d/%d\tMean\tStd Dev\tMin\tMax\tZ-Score\tMonths\tSpread\t\n", zipA, zipB)
+	found := false
+	for _, dataset := range sortedDatasets(as) {
+		b, ok := bs[dataset]
+		if !ok {
+			continue
+		}
+
+		found = true
+		s := calculateSpread(as[dataset], b)
+		fmt.Fprintf(w, "%s\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f\t%+.2f\t%d\t%s\t\n", dataset, s.current, s.mean, s.stdDev, s.min, s.max, s.z, s.months, s.signal(threshold))
+	}
+	if !found {
+		must(datasetError(fmt.Errorf("Couldn't find a dataset with both %d and %d", zipA, zipB)))
+	}
+	must(w.Flush())
+}
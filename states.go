@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// states lists the USPS abbreviation, name, and AP style abbreviation of
+// the states, DC, and Puerto Rico.
+var states = [][3]string{
+	{"AL", "Alabama", "Ala."},
+	{"AK", "Alaska", "Alaska"},
+	{"AZ", "Arizona", "Ariz."},
+	{"AR", "Arkansas", "Ark."},
+	{"CA", "California", "Calif."},
+	{"CO", "Colorado", "Colo."},
+	{"CT", "Connecticut", "Conn."},
+	{"DE", "Delaware", "Del."},
+	{"DC", "District of Columbia", "D.C."},
+	{"FL", "Florida", "Fla."},
+	{"GA", "Georgia", "Ga."},
+	{"HI", "Hawaii", "Hawaii"},
+	{"ID", "Idaho", "Idaho"},
+	{"IL", "Illinois", "Ill."},
+	{"IN", "Indiana", "Ind."},
+	{"IA", "Iowa", "Iowa"},
+	{"KS", "Kansas", "Kan."},
+	{"KY", "Kentucky", "Ky."},
+	{"LA", "Louisiana", "La."},
+	{"ME", "Maine", "Maine"},
+	{"MD", "Maryland", "Md."},
+	{"MA", "Massachusetts", "Mass."},
+	{"MI", "Michigan", "Mich."},
+	{"MN", "Minnesota", "Minn."},
+	{"MS", "Mississippi", "Miss."},
+	{"MO", "Missouri", "Mo."},
+	{"MT", "Montana", "Mont."},
+	{"NE", "Nebraska", "Neb."},
+	{"NV", "Nevada", "Nev."},
+	{"NH", "New Hampshire", "N.H."},
+	{"NJ", "New Jersey", "N.J."},
+	{"NM", "New Mexico", "N.M."},
+	{"NY", "New York", "N.Y."},
+	{"NC", "North Carolina", "N.C."},
+	{"ND", "North Dakota", "N.D."},
+	{"OH", "Ohio", "Ohio"},
+	{"OK", "Oklahoma", "Okla."},
+	{"OR", "Oregon", "Ore."},
+	{"PA", "Pennsylvania", "Pa."},
+	{"PR", "Puerto Rico", "P.R."},
+	{"RI", "Rhode Island", "R.I."},
+	{"SC", "South Carolina", "S.C."},
+	{"SD", "South Dakota", "S.D."},
+	{"TN", "Tennessee", "Tenn."},
+	{"TX", "Texas", "Texas"},
+	{"UT", "Utah", "Utah"},
+	{"VT", "Vermont", "Vt."},
+	{"VA", "Virginia", "Va."},
+	{"WA", "Washington", "Wash."},
+	{"WV", "West Virginia", "W.Va."},
+	{"WI", "Wisconsin", "Wis."},
+	{"WY", "Wyoming", "Wyo."},
+}
+
+// stateAbbreviations maps every form of a state, reduced by stateLetters, to
+// its USPS abbreviation.
+var stateAbbreviations = func() map[string]string {
+	abbreviations := map[string]string{}
+	for _, state := range states {
+		for _, form := range state {
+			abbreviations[stateLetters(form)] = state[0]
+		}
+	}
+	// other common abbreviations
+	for form, abbreviation := range map[string]string{
+		"calif": "CA", "cal": "CA", "colo": "CO", "penn": "PA", "penna": "PA",
+		"tex": "TX", "wisc": "WI", "mass": "MA", "wash": "WA", "ariz": "AZ",
+	} {
+		abbreviations[form] = abbreviation
+	}
+	return abbreviations
+}()
+
+// stateLetters lowercases s and drops everything but letters, so "N.Y.", "ny",
+// and "New York" compare by their letters.
+func stateLetters(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsLetter(r) {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
+
+// normalizeState returns the USPS abbreviation of a state written in any
+// of its forms, or s when it isn't a known state.
+func normalizeState(s string) string {
+	if abbreviation, ok := stateAbbreviations[stateLetters(s)]; ok {
+		return abbreviation
+	}
+	return s
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// statsCmd prints the summary statistics of the zip codes matching the
+// query of args, per dataset.
+func statsCmd(args []string) {
+	var opts options
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	opts.register(fs)
+	args = parseArgs(fs, args)
+
+	repo := opts.repository("")
+	if repo == "" {
+		if len(args) == 0 {
+			usage("stats")
+			os.Exit(exitUsage)
+		}
+		repo, args = args[0], args[1:]
+	}
+	must(usageError(opts.prepare()))
+	if opts.format != "text" {
+		must(usageError(fmt.Errorf("stats prints a table, --format %s isn't supported", opts.format)))
+	}
+
+	tokens := tokenize(args)
+	tree := &filterNode{description: "every zip code", filter: matchAll}
+	if len(tokens) > 0 {
+		var err error
+		if tree, _, err = parseFilterTree(tokens, opts.vocabulary); err != nil {
+			must(&queryError{err, tokens})
+		}
+	}
+
+	datas, err := matchTree(repo, opts.restrict(tree), opts)
+	must(err)
+	must(writeStats(os.Stdout, datas, opts))
+	if len(datas) == 0 {
+		os.Exit(noMatches())
+	}
+}
+
+// writeStats writes the reportStats of the rows of each dataset of datas,
+// and of all of them when they're of several datasets.
+func writeStats(out io.Writer, datas []Data, opts options) error {
+	byDataset := map[string][]Data{}
+	for _, d := range datas {
+		// rows of several dataset_dirs are tagged with theirs
+		name := d.Dataset
+		if d.Repository != "" {
+			name = d.Repository + "/" + d.Dataset
+		}
+		byDataset[name] = append(byDataset[name], d)
+	}
+	names := make([]string, 0, len(byDataset))
+	for name := range byDataset {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	writeRow := func(name string, stats []reportStat) {
+		cells := []string{name}
+		for _, stat := range stats {
+			// the datasets are counted by the name of the last row
+			if stat.Name != "Datasets" {
+				cells = append(cells, stat.Value)
+			}
+		}
+		fmt.Fprintf(w, "%s\t\n", strings.Join(cells, "\t"))
+	}
+
+	header := []reportStat{}
+	for _, stat := range reportStats(nil, opts) {
+		header = append(header, reportStat{stat.Name, stat.Name})
+	}
+	writeRow("Dataset", header)
+	for _, name := range names {
+		writeRow(name, reportStats(byDataset[name], opts))
+	}
+	if len(names) > 1 {
+		writeRow(fmt.Sprintf("All %d datasets", len(names)), reportStats(datas, opts))
+	}
+	return w.Flush()
+}
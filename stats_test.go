@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteStats(t *testing.T) {
+	row := func(dataset string, price, growth float64) Data {
+		return Data{Dataset: dataset, ZHIs: []float64{price}, GrowthRate: growth, YoY: growth}
+	}
+	datas := []Data{row("zhvi.csv", 100000, 2), row("zhvi.csv", 300000, 4), row("zori.csv", 2000, 1)}
+
+	var b bytes.Buffer
+	if err := writeStats(&b, datas, options{}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	want := [][]string{
+		{"Dataset", "Zip codes", "Median price"},
+		{"zhvi.csv", "2", "$200,000", "$100,000 to $300,000", "3.00%"},
+		{"zori.csv", "1", "$2,000", "$2,000 to $2,000", "1.00%"},
+		{"All 2 datasets", "3", "$100,000", "$2,000 to $300,000", "2.00%"},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("writeStats wrote %d lines, want %d:\n%s", len(lines), len(want), b.String())
+	}
+	for i, cells := range want {
+		for _, cell := range cells {
+			if !strings.Contains(lines[i], cell) {
+				t.Errorf("line %d = %q, want %s in it", i+1, lines[i], cell)
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// supplyTable holds the latest value of each region of a Zillow for-sale
+// inventory or median days to pending export, by regionKey. Those exports
+// are mostly by metro, and have the RegionName and RegionType columns of
+// the price exports followed by one column per month.
+type supplyTable map[string]float64
+
+// loadSupply reads a Zillow supply export, keeping the latest value of each
+// region up to asOf when set.
+func loadSupply(p, asOf string) (supplyTable, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cr := csv.NewReader(f)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid supply table %s: %v", p, err)
+	}
+
+	name, regionType, state, months := -1, -1, -1, len(header)
+	for i, column := range header {
+		column = strings.TrimSpace(column)
+		if len(column) >= 7 {
+			if _, err := time.Parse("2006-01", column[:7]); err == nil {
+				months = min(months, i)
+				continue
+			}
+		}
+		switch column {
+		case "RegionName":
+			name = i
+		case "RegionType":
+			regionType = i
+		case "State":
+			state = i
+		case "StateName":
+			if state < 0 {
+				state = i
+			}
+		}
+	}
+	if name < 0 || regionType < 0 {
+		return nil, fmt.Errorf("Invalid supply table %s: no RegionName or RegionType column", p)
+	}
+
+	end := len(header)
+	if asOf != "" {
+		for end > months && header[end-1][:min(7, len(header[end-1]))] > asOf {
+			end--
+		}
+	}
+
+	table := supplyTable{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Invalid supply table %s: %v", p, err)
+		}
+		if len(record) <= name || len(record) <= regionType {
+			continue
+		}
+
+		var st string
+		if state >= 0 && state < len(record) {
+			st = record[state]
+		}
+		key := regionKey(record[regionType], record[name], st)
+		if key == "" {
+			continue
+		}
+		for i := min(end, len(record)) - 1; i >= months; i-- {
+			if v, err := strconv.ParseFloat(record[i], 64); err == nil {
+				table[key] = v
+				break
+			}
+		}
+	}
+
+	if len(table) == 0 {
+		return nil, fmt.Errorf("Empty supply table %s", p)
+	}
+	return table, nil
+}
+
+// regionKey identifies a region of a RegionType, or returns "" for the
+// types rows can't be joined to. Metros are identified by their first
+// principal city and state, as the metros of the price exports may list all
+// their principal cities, e.g. San Francisco-Oakland-Berkeley, and the
+// metros of the supply exports only the first one.
+func regionKey(regionType, name, state string) string {
+	switch strings.ToLower(strings.TrimSpace(regionType)) {
+	case "zip":
+		zipCode, err := strconv.ParseUint(strings.TrimSpace(name), 10, 64)
+		if err != nil {
+			return ""
+		}
+		return "zip:" + strconv.FormatUint(zipCode, 10)
+	case "city":
+		return "city:" + foldText(name) + "," + normalizeState(state)
+	case "county":
+		return "county:" + foldText(name) + "," + normalizeState(state)
+	case "msa", "metro":
+		metro, metroState, ok := strings.Cut(name, ",")
+		if ok {
+			state = metroState
+		}
+		return "metro:" + metroKey(metro, state)
+	case "state":
+		return "state:" + normalizeState(name)
+	case "country":
+		return "country"
+	}
+	return ""
+}
+
+// metroKey is the first principal city of metro folded and the first
+// state of state, which lists every state of multistate metros, e.g.
+// NY-NJ-PA.
+func metroKey(metro, state string) string {
+	city, _, _ := strings.Cut(metro, "-")
+	state, _, _ = strings.Cut(strings.TrimSpace(state), "-")
+	return foldText(city) + "," + normalizeState(state)
+}
+
+// lookup returns the value of the smallest region of t holding d, from its
+// zip code up to the country, or 0 when t holds none. metro is the Metro
+// column of the row of d.
+func (t supplyTable) lookup(d *Data, metro string) float64 {
+	if t == nil {
+		return 0
+	}
+
+	keys := []string{
+		regionKey("zip", strconv.FormatUint(d.ZipCode, 10), ""),
+		regionKey("city", d.City, d.State),
+		regionKey("county", d.County, d.State),
+		"metro:" + metroKey(metro, d.State),
+		regionKey("state", d.State, ""),
+		"country",
+	}
+	for _, key := range keys {
+		if v, ok := t[key]; ok {
+			return v
+		}
+	}
+	return 0
+}
+
+// inventory and daysOnMarket are NaN without a value so that comparisons
+// don't match regions missing from the supply tables.
+func inventory(d *Data) float64 {
+	if d.Inventory == 0 {
+		return math.NaN()
+	}
+	return d.Inventory
+}
+
+func daysOnMarket(d *Data) float64 {
+	if d.DaysOnMarket == 0 {
+		return math.NaN()
+	}
+	return d.DaysOnMarket
+}
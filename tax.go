@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// taxTable maps a county to its effective property tax rate in percent, by
+// 5 digit FIPS code and by state and county name.
+type taxTable struct {
+	byFips   map[string]float64
+	byCounty map[[2]string]float64
+}
+
+// countyKey matches county names with or without their suffix, e.g. San
+// Mateo County and san mateo.
+func countyKey(state, county string) [2]string {
+	county = foldText(county)
+	for _, suffix := range []string{" county", " parish", " borough"} {
+		county = strings.TrimSuffix(county, suffix)
+	}
+	return [2]string{normalizeState(strings.TrimSpace(state)), county}
+}
+
+// loadTax reads a csv with a Rate column, the effective yearly property tax
+// rate in percent, and either a Fips column or State and County columns.
+func loadTax(p string) (*taxTable, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cr := csv.NewReader(f)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid tax table %s: %v", p, err)
+	}
+
+	fips, state, county, rate := -1, -1, -1, -1
+	for i, column := range header {
+		switch strings.ToLower(strings.TrimSpace(column)) {
+		case "fips":
+			fips = i
+		case "state":
+			state = i
+		case "county":
+			county = i
+		case "rate":
+			rate = i
+		}
+	}
+	if rate < 0 || (fips < 0 && (state < 0 || county < 0)) {
+		return nil, fmt.Errorf("Invalid tax table %s: expected a Rate column and a Fips column or State and County columns", p)
+	}
+
+	t := &taxTable{byFips: map[string]float64{}, byCounty: map[[2]string]float64{}}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Invalid tax table %s: %v", p, err)
+		}
+
+		v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(record[rate]), "%"), 64)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("Invalid tax rate %s in %s", record[rate], p)
+		}
+		if fips >= 0 && record[fips] != "" {
+			code := strings.TrimSpace(record[fips])
+			if len(code) == 4 {
+				code = "0" + code
+			}
+			t.byFips[code] = v
+		}
+		if state >= 0 && county >= 0 {
+			t.byCounty[countyKey(record[state], record[county])] = v
+		}
+	}
+
+	if len(t.byFips) == 0 && len(t.byCounty) == 0 {
+		return nil, fmt.Errorf("Empty tax table %s", p)
+	}
+	return t, nil
+}
+
+func (t *taxTable) rate(d *Data) (float64, bool) {
+	if v, ok := t.byFips[d.Fips]; ok && d.Fips != "" {
+		return v, true
+	}
+	v, ok := t.byCounty[countyKey(d.State, d.County)]
+	return v, ok
+}
+
+// setTax sets the yearly tax of d at its latest price, and adds it to the
+// payment estimate.
+func (t *taxTable) setTax(d *Data) {
+	if t == nil {
+		return
+	}
+
+	rate, ok := t.rate(d)
+	if !ok {
+		return
+	}
+	d.TaxRate = rate
+	d.Tax = d.Price() * rate / 100
+	if d.Payment != 0 {
+		d.Payment += d.Tax / 12
+	}
+}
+
+// tax is the yearly tax of d, NaN when its county has no rate so that
+// comparisons don't match.
+func tax(d *Data) float64 {
+	if d.TaxRate == 0 {
+		return math.NaN()
+	}
+	return d.Tax
+}
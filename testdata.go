@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+)
+
+// testdataSpec shapes the rows of gen-testdata.
+type testdataSpec struct {
+	rows    int
+	months  int
+	start   time.Time
+	missing float64
+	seed    int64
+}
+
+// writeTestdata writes a ZHVI shaped export of synthetic zip codes. Each
+// price history follows its own yearly trend, with a spring and summer
+// bump, monthly noise, and a slowly changing growth so that markets heat
+// up and cool down. Histories may start late, like newer zip codes do, and
+// spec.missing of their later months are left empty at random.
+func writeTestdata(w io.Writer, spec testdataSpec) error {
+	r := rand.New(rand.NewSource(spec.seed))
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprint(bw, "RegionID,SizeRank,RegionName,RegionType,StateName,State,City,Metro,CountyName")
+	for i := 0; i < spec.months; i++ {
+		// Zillow dates each month by its last day
+		month := spec.start.AddDate(0, i+1, -1)
+		fmt.Fprintf(bw, ",%s", month.Format("2006-01-02"))
+	}
+	fmt.Fprintln(bw)
+
+	for row := 0; row < spec.rows; row++ {
+		state := states[r.Intn(len(states))][0]
+		county := r.Intn(20) + 1
+		city := county*10 + r.Intn(10)
+		// zip codes are unique per row, which keeps them 5 digits
+		zipCode := 10000 + row
+		fmt.Fprintf(bw, "%d,%d,%05d,zip,%s,%s,City %d,Metro %d,County %d", 100000+row, row, zipCode, state, state, city, county, county)
+
+		price := math.Exp(r.NormFloat64()*0.6 + math.Log(250000))
+		growth := r.NormFloat64()*0.02 + 0.04
+		season := r.Float64() * 0.015
+		// a quarter of the zip codes start later
+		first := 0
+		if r.Float64() < 0.25 {
+			first = r.Intn(spec.months)
+		}
+		for i := 0; i < spec.months; i++ {
+			growth += r.NormFloat64() * 0.002
+			growth = math.Max(-0.15, math.Min(0.25, growth))
+			price *= math.Pow(1+growth, 1.0/12) * (1 + r.NormFloat64()*0.003)
+
+			if i < first || (i > first && r.Float64() < spec.missing) {
+				bw.WriteString(",")
+				continue
+			}
+			month := (int(spec.start.Month()) - 1 + i) % 12
+			// peaks in late spring, bottoms out in winter
+			v := price * (1 + season*math.Sin(2*math.Pi*float64(month-1)/12))
+			bw.WriteString(",")
+			bw.WriteString(strconv.FormatFloat(math.Round(v), 'f', 0, 64))
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
+
+func genTestdataCmd(args []string) {
+	var spec testdataSpec
+	var start, out string
+	fs := flag.NewFlagSet("gen-testdata", flag.ExitOnError)
+	fs.IntVar(&spec.rows, "rows", 1000, "")
+	fs.IntVar(&spec.months, "months", 240, "")
+	fs.StringVar(&start, "start", "2000-01", "")
+	fs.Float64Var(&spec.missing, "missing", 0.01, "")
+	fs.Int64Var(&spec.seed, "seed", 1, "")
+	fs.StringVar(&out, "out", "", "")
+	if args = parseArgs(fs, args); len(args) != 0 {
+		usage("gen-testdata")
+		os.Exit(exitUsage)
+	}
+
+	if spec.rows < 0 || spec.rows > 90000 || spec.months < 1 {
+		must(usageError(fmt.Errorf("gen-testdata expects 0 to 90000 rows and at least 1 month, got %d rows and %d months", spec.rows, spec.months)))
+	}
+	if spec.missing < 0 || spec.missing >= 1 {
+		must(usageError(fmt.Errorf("--missing expects a rate from 0 to 1, got %v", spec.missing)))
+	}
+	var err error
+	spec.start, err = time.Parse("2006-01", start)
+	must(usageError(err))
+
+	w := io.Writer(os.Stdout)
+	if out != "" {
+		f, err := os.Create(out)
+		must(err)
+		defer f.Close()
+		w = f
+	}
+	must(writeTestdata(w, spec))
+}
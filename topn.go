@@ -0,0 +1,111 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// aggregateSortKeys are the sort keys depending on the whole result set,
+// which searchTop can't sort by while loading.
+var aggregateSortKeys = map[string]bool{
+	"RelGrowth":      true,
+	"GrowthPct":      true,
+	"PricePct":       true,
+	"GrowthStatePct": true,
+	"PriceStatePct":  true,
+	"Score":          true,
+}
+
+// canSearchTop reports whether the first --limit results of tree sorted by
+// --sort can be kept while loading: the order and the filters can't depend
+// on the whole result set, and no row may be dropped after loading.
+func canSearchTop(tree *filterNode, opts options) bool {
+	return opts.limit > 0 && !tree.aggregate && !aggregateSortKeys[opts.sort] &&
+		opts.dedupeWins == nil && len(opts.definitions) == 0 && opts.scoreExpr == nil && !opts.rollup && !opts.byHomeType
+}
+
+// topHeap keeps the first n rows in the order of less, with the last one
+// of them on top so it's the one replaced.
+type topHeap struct {
+	datas []Data
+	less  func(a, b *Data) bool
+}
+
+func (h *topHeap) Len() int           { return len(h.datas) }
+func (h *topHeap) Less(i, j int) bool { return h.less(&h.datas[j], &h.datas[i]) }
+func (h *topHeap) Swap(i, j int)      { h.datas[i], h.datas[j] = h.datas[j], h.datas[i] }
+func (h *topHeap) Push(x interface{}) { h.datas = append(h.datas, x.(Data)) }
+func (h *topHeap) Pop() interface{} {
+	last := h.datas[len(h.datas)-1]
+	h.datas = h.datas[:len(h.datas)-1]
+	return last
+}
+
+func (h *topHeap) offer(d *Data, n int) {
+	if len(h.datas) < n {
+		heap.Push(h, *d)
+	} else if h.less(d, &h.datas[0]) {
+		h.datas[0] = *d
+		heap.Fix(h, 0)
+	}
+}
+
+// searchTop is like search followed by sortDatas for the first
+// --limit results, but only keeps --limit rows per dataset while loading,
+// one heap per dataset as each dataset is parsed by its own goroutine.
+// The heaps are merged once every dataset is parsed.
+func searchTop(repository string, tree *filterNode, opts options) ([]Data, error) {
+	less, ok := lookupSortKey(opts.sort, opts.vocabulary)
+	if !ok {
+		return nil, fmt.Errorf("Couldn't find sort key %s", opts.sort)
+	}
+	if _, ok := benchmarks[opts.benchmark]; !ok {
+		return nil, fmt.Errorf("Couldn't find benchmark %s", opts.benchmark)
+	}
+
+	p := newPopulation()
+	matched := newMatchedStats()
+	var mu sync.Mutex
+	// by repository and dataset, --data can query datasets with the same
+	// name in several repositories
+	heaps := map[[2]string]*topHeap{}
+	loadWith(repository, func(d *Data) bool {
+		if !tree.filter(d) {
+			return false
+		}
+		matched.add(d)
+
+		mu.Lock()
+		key := [2]string{d.Repository, d.Dataset}
+		h := heaps[key]
+		if h == nil {
+			h = &topHeap{less: less}
+			heaps[key] = h
+		}
+		mu.Unlock()
+		h.offer(d, opts.limit)
+		return false
+	}, opts, p.observe)
+
+	start := time.Now()
+	defer func() { timings.add("sort", time.Since(start)) }()
+	var top []Data
+	for _, h := range heaps {
+		top = append(top, h.datas...)
+	}
+	if err := sortDatas(top, opts.sort, opts.vocabulary); err != nil {
+		return nil, err
+	}
+	if len(top) > opts.limit {
+		top = top[:opts.limit]
+	}
+
+	for i := range top {
+		if err := p.complete(&top[i], matched, opts); err != nil {
+			return nil, err
+		}
+	}
+	return top, nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize/english"
+)
+
+// editDistance is the Levenshtein distance of a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
+
+// closestKind returns the filter kind kind is most likely a typo of, or ""
+// when none is close.
+func closestKind(kind string, v *vocabulary) string {
+	closest, best := "", len(kind)/3+1
+	for _, candidate := range filterKinds(v) {
+		if d := editDistance(strings.ToLower(kind), strings.ToLower(candidate)); d <= best {
+			closest, best = candidate, d
+			if d == 0 {
+				break
+			}
+		}
+	}
+	return closest
+}
+
+// datasetReport is the problems found in one dataset file.
+type datasetReport struct {
+	dataset  string
+	rows     int
+	problems []string
+}
+
+func (r *datasetReport) problem(format string, args ...interface{}) {
+	r.problems = append(r.problems, fmt.Sprintf(format, args...))
+}
+
+// maxExamples bounds the rows listed per kind of problem.
+const maxExamples = 3
+
+func examples(rows []int) string {
+	var listed []string
+	for i, row := range rows {
+		if i == maxExamples {
+			listed = append(listed, "...")
+			break
+		}
+		listed = append(listed, strconv.Itoa(row))
+	}
+	return english.PluralWord(len(rows), "row", "") + " " + strings.Join(listed, ", ")
+}
+
+// checkDataset reads a dataset file the way loadWith does and reports its
+// header, column counts, months, and duplicate zip codes.
+func checkDataset(repository, dataset string) datasetReport {
+	r := datasetReport{dataset: dataset}
+	f, err := openRepositoryDataset(repository, dataset)
+	if err != nil {
+		r.problem("couldn't open: %v", err)
+		return r
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		r.problem("missing header")
+		if err := scanner.Err(); err != nil {
+			r.problem("couldn't read: %v", err)
+		}
+		return r
+	}
+
+	header := strings.Split(scanner.Text(), ",")
+	l, err := datasetLayout(repository, dataset, header)
+	if err != nil {
+		r.problem("%v", err)
+		return r
+	}
+	months := parseMonths(header, l)
+	if l.pattern == "" && !strings.Contains(","+scanner.Text()+",", ",RegionName,") {
+		r.problem("header has no RegionName column")
+	}
+	if len(months) == 0 {
+		r.problem("header has no month columns")
+	}
+	for i, month := range months {
+		if _, err := time.Parse("2006-01", month); err != nil {
+			r.problem("month column %d is %s, not a date", l.months+i+1, header[l.months+i])
+		} else if i > 0 && month <= months[i-1] {
+			r.problem("month %s follows %s, months must increase", month, months[i-1])
+		}
+	}
+
+	var short, long, invalid []int
+	seen := map[uint64]int{}
+	duplicates := map[uint64][]int{}
+	for scanner.Scan() {
+		r.rows++
+		fields := strings.Split(scanner.Text(), ",")
+		if len(fields) < len(header) {
+			short = append(short, r.rows)
+		} else if len(fields) > len(header) {
+			long = append(long, r.rows)
+		}
+		if len(fields) <= l.zipCode {
+			continue
+		}
+
+		zipCode, err := strconv.ParseUint(fields[l.zipCode], 10, 64)
+		if err != nil {
+			invalid = append(invalid, r.rows)
+			continue
+		}
+		if first, ok := seen[zipCode]; ok {
+			if len(duplicates[zipCode]) == 0 {
+				duplicates[zipCode] = []int{first}
+			}
+			duplicates[zipCode] = append(duplicates[zipCode], r.rows)
+		} else {
+			seen[zipCode] = r.rows
+		}
+	}
+
+	if len(short) > 0 {
+		r.problem("%s fewer than %d columns, e.g. %s", english.Plural(len(short), "row has", "rows have"), len(header), examples(short))
+	}
+	if len(long) > 0 {
+		r.problem("%s more than %d columns, e.g. %s", english.Plural(len(long), "row has", "rows have"), len(header), examples(long))
+	}
+	if len(invalid) > 0 {
+		r.problem("%s an invalid zip code, e.g. %s", english.Plural(len(invalid), "row has", "rows have"), examples(invalid))
+	}
+
+	zipCodes := make([]uint64, 0, len(duplicates))
+	for zipCode := range duplicates {
+		zipCodes = append(zipCodes, zipCode)
+	}
+	sort.Slice(zipCodes, func(i, j int) bool { return duplicates[zipCodes[i]][0] < duplicates[zipCodes[j]][0] })
+	for i, zipCode := range zipCodes {
+		if i == maxExamples {
+			r.problem("%s duplicated", english.Plural(len(zipCodes)-maxExamples, "more zip code is", "more zip codes are"))
+			break
+		}
+		r.problem("zip code %d is duplicated in %s", zipCode, examples(duplicates[zipCode]))
+	}
+
+	if err := scanner.Err(); err != nil {
+		r.problem("stopped reading after row %d: %v", r.rows, err)
+	}
+	return r
+}
+
+// validateCmd prints a report per dataset file and exits with 1 when any
+// file has problems.
+func validateCmd(args []string) {
+	if len(args) > 1 {
+		usage("validate")
+		os.Exit(exitUsage)
+	}
+
+	repo := repository()
+	if len(args) == 1 {
+		repo = args[0]
+	}
+	repo, err := resolveSnapshot(repo, "")
+	must(err)
+	datasets, err := listDatasets(repo)
+	must(err)
+
+	failed := 0
+	for _, dataset := range datasets {
+		r := checkDataset(repo, dataset)
+		if len(r.problems) == 0 {
+			fmt.Printf("%s: ok, %s\n", r.dataset, english.Plural(r.rows, "row", ""))
+			continue
+		}
+
+		failed++
+		fmt.Printf("%s: %s, %s\n", r.dataset, english.Plural(len(r.problems), "problem", ""), english.Plural(r.rows, "row", ""))
+		for _, problem := range r.problems {
+			fmt.Printf("  * %s\n", problem)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %s problems\n", failed, english.Plural(len(datasets), "dataset file has", "dataset files have"))
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,35 @@
+//go:build js && wasm
+
+// The WASM module of the query engine, built with
+//
+//	GOOS=js GOARCH=wasm go build -o zhiquery.wasm .
+//
+// and loaded with the wasm_exec.js of the Go distribution. It defines the
+// global function zhiqueryQuery, taking the arguments of the query command
+// as a json array and returning a promise of the json of queryLibraryJSON.
+// Browsers can only read http(s) and s3 dataset_dirs, through fetch.
+package main
+
+import "syscall/js"
+
+func init() {
+	embedded = true
+	libraryMain = func() {
+		js.Global().Set("zhiqueryQuery", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			argsJSON := ""
+			if len(args) == 1 && args[0].Type() == js.TypeString {
+				argsJSON = args[0].String()
+			}
+			// fetching the datasets blocks, which the event loop calling
+			// the function can't do
+			return js.Global().Get("Promise").New(js.FuncOf(func(this js.Value, callbacks []js.Value) interface{} {
+				go func() {
+					callbacks[0].Invoke(queryLibraryJSON(argsJSON))
+				}()
+				return nil
+			}))
+		}))
+		// the function lives as long as the module runs
+		select {}
+	}
+}
@@ -0,0 +1,241 @@
+"use strict";
+
+const colors = ["#1f77b4", "#d62728", "#2ca02c", "#ff7f0e", "#9467bd", "#8c564b"];
+
+const $ = (selector) => document.querySelector(selector);
+
+let kinds = [];
+let results = { columns: [], results: [] };
+let sorted = { column: null, desc: false };
+
+async function api(path, params) {
+  const resp = await fetch(path + "?" + new URLSearchParams(params));
+  const body = await resp.json();
+  if (!resp.ok) {
+    throw new Error(body.error);
+  }
+  return body;
+}
+
+function addCondition(kind, arg) {
+  const node = $("#condition").content.firstElementChild.cloneNode(true);
+  const select = node.querySelector(".kind");
+  for (const k of kinds) {
+    select.add(new Option(k, k));
+  }
+  select.value = kind || "State";
+  node.querySelector(".arg").value = arg || "";
+  node.querySelector(".remove").addEventListener("click", () => {
+    node.remove();
+    buildQuery();
+  });
+  node.addEventListener("input", buildQuery);
+  $("#conditions").append(node);
+  buildQuery();
+}
+
+// buildQuery writes the conditions as a zhiquery query, quoting arguments
+// with spaces like County:"San Mateo County".
+function buildQuery() {
+  const tokens = [];
+  for (const node of document.querySelectorAll(".condition")) {
+    const arg = node.querySelector(".arg").value.trim();
+    if (arg === "") {
+      continue;
+    }
+    if (tokens.length > 0) {
+      tokens.push(node.querySelector(".join").value);
+    }
+    const quoted = /\s/.test(arg) ? '"' + arg + '"' : arg;
+    tokens.push(node.querySelector(".kind").value + ":" + quoted);
+  }
+  $("#query").value = tokens.length > 0 ? "[ " + tokens.join(" ") + " ]" : "";
+}
+
+async function search() {
+  $("#error").hidden = true;
+  try {
+    results = await api("api/query", {
+      q: $("#query").value,
+      sort: $("#sort").value,
+      desc: $("#desc").checked ? "1" : "",
+    });
+  } catch (err) {
+    $("#error").textContent = err.message;
+    $("#error").hidden = false;
+    return;
+  }
+
+  sorted = { column: null, desc: false };
+  showResults();
+  $("#results").hidden = false;
+}
+
+// more appends the next page of the results, the client side sort of a
+// column applies to the rows loaded so far.
+async function more() {
+  let page;
+  try {
+    page = await api("api/query", { cursor: results.next });
+  } catch (err) {
+    $("#error").textContent = err.message;
+    $("#error").hidden = false;
+    return;
+  }
+
+  results.results.push(...page.results);
+  results.next = page.next;
+  if (sorted.column !== null) {
+    sortRows();
+  }
+  showResults();
+}
+
+function showResults() {
+  const shown = results.results.length;
+  $("#summary").textContent = results.total === shown
+    ? `${results.total} zip codes`
+    : `${results.total} zip codes, showing the first ${shown}`;
+  $("#more").hidden = !results.next;
+  renderTable();
+}
+
+function formatCell(column, value) {
+  if (typeof value !== "number") {
+    return value;
+  }
+  if (column === "Price") {
+    return "$" + Math.round(value).toLocaleString();
+  }
+  if (column === "ZipCode") {
+    return String(value).padStart(5, "0");
+  }
+  return Number.isInteger(value) ? String(value) : value.toFixed(2);
+}
+
+function renderTable() {
+  const head = $("#results thead tr");
+  head.replaceChildren();
+  for (const column of results.columns) {
+    const th = document.createElement("th");
+    th.textContent = column;
+    if (sorted.column === column) {
+      th.className = sorted.desc ? "sorted-desc" : "sorted-asc";
+    }
+    th.addEventListener("click", () => sortBy(column));
+    head.append(th);
+  }
+
+  const body = $("#results tbody");
+  body.replaceChildren();
+  for (const record of results.results) {
+    const tr = document.createElement("tr");
+    for (const column of results.columns) {
+      const td = document.createElement("td");
+      td.textContent = formatCell(column, record[column]);
+      if (typeof record[column] === "number") {
+        td.className = "number";
+      }
+      tr.append(td);
+    }
+    tr.addEventListener("click", () => {
+      for (const selected of body.querySelectorAll(".selected")) {
+        selected.classList.remove("selected");
+      }
+      tr.classList.add("selected");
+      showChart(record.ZipCode, record.City, record.State);
+    });
+    body.append(tr);
+  }
+}
+
+function sortBy(column) {
+  sorted = { column, desc: sorted.column === column ? !sorted.desc : true };
+  sortRows();
+  renderTable();
+}
+
+function sortRows() {
+  const { column, desc } = sorted;
+  results.results.sort((a, b) => {
+    const x = a[column], y = b[column];
+    const order = x < y ? -1 : x > y ? 1 : 0;
+    return desc ? -order : order;
+  });
+}
+
+async function showChart(zipCode, city, state) {
+  const series = await api("api/series", { zip: zipCode });
+  const svg = $("#chart svg");
+  const width = 800, height = 300, left = 70, bottom = 25;
+  svg.replaceChildren();
+  $("#legend").replaceChildren();
+
+  const values = series.flatMap((s) => s.values.filter((v) => v > 0));
+  const months = [...new Set(series.flatMap((s) => s.months))].sort();
+  if (values.length === 0 || months.length < 2) {
+    return;
+  }
+  const min = Math.min(...values), max = Math.max(...values);
+  const x = (month) => left + (months.indexOf(month) / (months.length - 1)) * (width - left - 10);
+  const y = (v) => 10 + (1 - (v - min) / (max - min || 1)) * (height - bottom - 20);
+
+  const ns = "http://www.w3.org/2000/svg";
+  const add = (name, attrs, text) => {
+    const el = document.createElementNS(ns, name);
+    for (const [k, v] of Object.entries(attrs)) {
+      el.setAttribute(k, v);
+    }
+    if (text !== undefined) {
+      el.textContent = text;
+    }
+    svg.append(el);
+  };
+
+  add("line", { class: "axis", x1: left, y1: height - bottom, x2: width, y2: height - bottom });
+  add("line", { class: "axis", x1: left, y1: 0, x2: left, y2: height - bottom });
+  for (const v of [min, (min + max) / 2, max]) {
+    add("text", { x: left - 5, y: y(v) + 4, "text-anchor": "end" }, "$" + Math.round(v).toLocaleString());
+  }
+  for (const month of [months[0], months[Math.floor(months.length / 2)], months[months.length - 1]]) {
+    add("text", { x: x(month), y: height - 5, "text-anchor": "middle" }, month);
+  }
+
+  series.forEach((s, i) => {
+    const points = s.months
+      .map((month, j) => [month, s.values[j]])
+      .filter(([, v]) => v > 0)
+      .map(([month, v]) => `${x(month)},${y(v)}`);
+    add("polyline", { points: points.join(" "), stroke: colors[i % colors.length] });
+
+    const li = document.createElement("li");
+    const swatch = document.createElement("span");
+    swatch.style.background = colors[i % colors.length];
+    li.append(swatch, s.repository ? `${s.dataset} (${s.repository})` : s.dataset);
+    $("#legend").append(li);
+  });
+
+  $("#chart-title").textContent = `${String(zipCode).padStart(5, "0")} ${city}, ${state}`;
+  $("#chart").hidden = false;
+}
+
+async function init() {
+  const vocabulary = await api("api/kinds", {});
+  kinds = vocabulary.kinds;
+  for (const key of vocabulary.sortKeys) {
+    $("#sort").add(new Option(key, key));
+  }
+  $("#sort").value = "GrowthRate";
+
+  $("#add").addEventListener("click", () => addCondition());
+  $("#run").addEventListener("click", search);
+  $("#more").addEventListener("click", more);
+  $("#query").addEventListener("keydown", (e) => {
+    if (e.key === "Enter") {
+      search();
+    }
+  });
+  addCondition("State", "");
+}
+
+init();
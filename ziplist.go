@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readZipList reads one zip code per line from p, or from stdin when p is
+// -. Blank lines and lines starting with # are skipped, and ZIP+4 codes are
+// taken by their first 5 digits.
+func readZipList(p string) (map[uint64]bool, error) {
+	var r io.Reader = os.Stdin
+	if p != "-" {
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	zips := map[uint64]bool{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		zip := strings.TrimSpace(scanner.Text())
+		if zip == "" || strings.HasPrefix(zip, "#") {
+			continue
+		}
+		if i := strings.IndexByte(zip, '-'); i == 5 {
+			zip = zip[:i]
+		}
+
+		zipCode, err := strconv.ParseUint(zip, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid zip code %s on line %d of %s", zip, line, p)
+		}
+		zips[zipCode] = true
+	}
+	return zips, scanner.Err()
+}
+
+func filterByZipCodes(zips map[uint64]bool) FilterFn {
+	return FilterFn(func(d *Data) bool {
+		return zips[d.ZipCode]
+	})
+}
+
+// readExclusions reads one zip code or filter per line, and returns a
+// filter matching the rows any line matches.
+func readExclusions(p string, v *vocabulary) (FilterFn, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var filters []FilterFn
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		if zipCode, err := strconv.ParseUint(text, 10, 64); err == nil {
+			filters = append(filters, filterByZipCode(zipCode))
+			continue
+		}
+
+		node, err := parseFilter(text, v)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d of %s: %v", line, p, err)
+		}
+		if node.aggregate {
+			return nil, fmt.Errorf("Line %d of %s: %s can't be excluded, it depends on the whole result set", line, p, text)
+		}
+		filters = append(filters, node.filter)
+	}
+	return chainByOr(filters...), scanner.Err()
+}
+
+// restrict narrows tree with --zips, --exclude-file, and the exclusion of
+// missing prices unless --include-missing.
+func (o *options) restrict(tree *filterNode) *filterNode {
+	and := func(left, right *filterNode) *filterNode {
+		if left == nil {
+			return right
+		} else if right == nil {
+			return left
+		}
+		return &filterNode{op: "and", left: left, right: right, filter: chainByAnd(left.filter, right.filter), aggregate: left.aggregate || right.aggregate}
+	}
+
+	if o.zipList != nil {
+		tree = and(&filterNode{
+			description: fmt.Sprintf("ZipCode in the %d zip codes of %s", len(o.zipList), o.zips),
+			filter:      filterByZipCodes(o.zipList),
+		}, tree)
+	}
+	if !o.includeMissing {
+		tree = and(tree, &filterNode{
+			description: "latest price isn't missing",
+			filter:      func(d *Data) bool { return d.Price() > 0 },
+		})
+	}
+	if o.exclusions != nil {
+		excluded := o.exclusions
+		tree = and(tree, &filterNode{
+			description: "not excluded by " + o.excludeFile,
+			filter:      func(d *Data) bool { return !excluded(d) },
+		})
+	}
+	return tree
+}